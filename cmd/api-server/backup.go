@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"currency-rates-notifier/internal/config"
+	"currency-rates-notifier/internal/storage"
+	"currency-rates-notifier/internal/storage/sqlite"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// backupStorage copies the database to a file while the server keeps running
+func backupStorage(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: api-server backup <file>")
+		fmt.Fprintln(flags.Output(), "Writes a consistent copy of the SQLite database to a file that must not exist yet.")
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("the backup file is required")
+	}
+
+	store, err := openStorageBackend(cfg.Storage, false)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	backingUp, ok := store.(storage.BackingUp)
+	if !ok {
+		return fmt.Errorf("storage driver %q has no backup command, use the tools of the database", cfg.Storage.Driver)
+	}
+
+	if err := backingUp.Backup(context.Background(), flags.Arg(0)); err != nil {
+		return err
+	}
+	fmt.Printf("backed up %s to %s\n", cfg.Storage.DSN, flags.Arg(0))
+	return nil
+}
+
+// restoreStorage replaces the database with a backup. The replaced database is backed
+// up first, so a restore can be undone.
+func restoreStorage(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: api-server restore <file>")
+		fmt.Fprintln(flags.Output(), "Replaces the SQLite database with a backup. Stop the server first.")
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("the backup file is required")
+	}
+	if cfg.Storage.Driver != "sqlite" {
+		return fmt.Errorf("storage driver %q has no restore command, use the tools of the database", cfg.Storage.Driver)
+	}
+
+	ctx := context.Background()
+	if _, err := os.Stat(cfg.Storage.DSN); err == nil {
+		previous := fmt.Sprintf("%s.%s.bak", cfg.Storage.DSN, time.Now().Format("20060102-150405"))
+		store, err := sqlite.New(cfg.Storage.DSN, sqlite.WithoutMigrations(),
+			sqlite.WithSuppressionKey([]byte(cfg.Storage.SuppressionKey)))
+		if err != nil {
+			return err
+		}
+		err = store.Backup(ctx, previous)
+		store.Close()
+		if err != nil {
+			return fmt.Errorf("back up the current database: %w", err)
+		}
+		fmt.Printf("backed up the current database to %s\n", previous)
+	}
+
+	version, err := sqlite.Restore(ctx, flags.Arg(0), cfg.Storage.DSN)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %s from %s at schema version %d\n", cfg.Storage.DSN, flags.Arg(0), version)
+	return nil
+}
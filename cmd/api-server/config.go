@@ -0,0 +1,36 @@
+package main
+
+import (
+	"currency-rates-notifier/internal/config"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// checkConfig validates the config and lists every problem found
+func checkConfig(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("config", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: api-server [-config file] config check")
+		fmt.Fprintln(flags.Output(), "Reads the config file and the environment like serve does and reports every invalid setting.")
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || flags.Arg(0) != "check" {
+		flags.Usage()
+		return errors.New("unknown config command")
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return invalidConfig(err)
+	}
+	fmt.Println("config is valid")
+	return nil
+}
+
+// invalidConfig lists the problems of a config one per line
+func invalidConfig(err error) error {
+	return fmt.Errorf("invalid config:\n  %s", strings.ReplaceAll(err.Error(), "\n", "\n  "))
+}
@@ -1,61 +1,248 @@
 package main
 
 import (
-	"currency-rates-notifier/internal/api/monobank"
+	"context"
 	"currency-rates-notifier/internal/config"
-	"currency-rates-notifier/internal/handler"
-	"currency-rates-notifier/internal/job"
+	"currency-rates-notifier/internal/lib/emailaddr"
+	"currency-rates-notifier/internal/lib/fieldcrypt"
+	"currency-rates-notifier/internal/storage"
+	"currency-rates-notifier/internal/storage/memory"
+	"currency-rates-notifier/internal/storage/postgres"
 	"currency-rates-notifier/internal/storage/sqlite"
+	"errors"
+	"flag"
 	"fmt"
-	"github.com/robfig/cron/v3"
-	"github.com/wneessen/go-mail"
 	"log/slog"
-	"net/http"
+	"net"
 	"os"
 )
 
+// defaultConfigPath is read when it exists and neither -config nor CONFIG_PATH is set,
+// otherwise the config comes from the environment alone
+const defaultConfigPath = "./config/local.yaml"
+
+const usage = `usage: api-server [-config file] [-dsn dsn] [command] [flags]
+
+flags:
+  -config  config file, defaults to $CONFIG_PATH or ./config/local.yaml
+  -dsn     storage DSN, overrides storage.dsn and $STORAGE_DSN
+
+commands:
+  serve        run the HTTP server and the scheduled jobs (default)
+  import       import subscribers from a CSV file
+  export       export subscribers as CSV or JSON
+  migrate      apply or revert schema migrations
+  reencrypt    encrypt subscriber addresses with the current key
+  backup       copy the SQLite database while the server runs
+  restore      replace the SQLite database with a backup
+  config       check the config for invalid settings
+  subscribers  list, add or remove subscribers
+  send-now     mail the current rates to subscribers at once
+  outbox       retry the deliveries that failed
+  rates        show the current rates
+
+Run "api-server <command> -h" for the flags of a command.
+`
+
 func main() {
-	cfg := config.ReadConfig("./config/local.yaml")
-	log := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
-	monobankClient := monobank.NewClient(cfg.Monobank.API.URL, log)
+	flags := flag.NewFlagSet("api-server", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(flags.Output(), usage) }
+	configPath := flags.String("config", os.Getenv("CONFIG_PATH"), "")
+	dsn := flags.String("dsn", "", "")
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		os.Exit(2)
+	}
 
-	storage, err := sqlite.New("./storage.db")
+	command, args := "serve", flags.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	if *configPath == "" {
+		if _, err := os.Stat(defaultConfigPath); err == nil {
+			*configPath = defaultConfigPath
+		}
+	}
+	loadConfig := func() (*config.Config, error) {
+		cfg, err := config.ReadConfig(*configPath)
+		if err != nil {
+			return nil, err
+		}
+		if *dsn != "" {
+			cfg.Storage.DSN = *dsn
+		}
+		return cfg, nil
+	}
+	cfg, err := loadConfig()
 	if err != nil {
-		log.Error("failed to init storage", "error", err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
-	emailClient, err := mail.NewClient(cfg.Email.Host,
-		mail.WithSMTPAuth(mail.SMTPAuthPlain), mail.WithTLSPortPolicy(mail.TLSMandatory),
-		mail.WithUsername(cfg.Email.User), mail.WithPassword(cfg.Email.Password),
-	)
+	switch command {
+	case "serve":
+		if err := cfg.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, invalidConfig(err))
+			os.Exit(1)
+		}
+		log := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		if err = serve(cfg, *configPath, loadConfig, log); err != nil {
+			log.Error("server failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "import":
+		err = importSubscribers(cfg, args)
+	case "export":
+		err = exportSubscribers(cfg, args)
+	case "migrate":
+		err = migrateSchema(cfg, args)
+	case "reencrypt":
+		err = reencryptSubscribers(cfg, args)
+	case "backup":
+		err = backupStorage(cfg, args)
+	case "restore":
+		err = restoreStorage(cfg, args)
+	case "config":
+		err = checkConfig(cfg, args)
+	case "subscribers":
+		err = manageSubscribers(cfg, args)
+	case "send-now":
+		err = sendNow(cfg, args)
+	case "outbox":
+		err = retryOutbox(cfg, args)
+	case "rates":
+		err = showRates(cfg, args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
 
-	notifier := job.NewCurrencyRateNotifier(monobankClient, storage, emailClient, log, cfg.Email)
-	c := cron.New()
-	_, err = c.AddFunc("0 1 * * *", notifier.SendEmailToSubscribers)
 	if err != nil {
-		log.Error("failed to schedule notification job", "error", err)
+		fmt.Fprintf(os.Stderr, "%s: %s\n", command, err)
 		os.Exit(1)
 	}
-	c.Start()
+}
+
+// openStorage opens the database, migrating it unless migrations are run manually,
+// in which case it fails while migrations are pending
+func openStorage(ctx context.Context, cfg config.Storage) (storage.Store, error) {
+	store, err := openStorageBackend(cfg, !cfg.ManualMigrations)
+	if err != nil || !cfg.ManualMigrations {
+		return store, err
+	}
 
-	router := http.NewServeMux()
-	currencyRateHandler := handler.NewCurrencyRateHandler(monobankClient, log)
-	subscriptionHandler := handler.NewSubscriptionHandler(storage, log)
+	migrating, ok := store.(storage.Migrating)
+	if !ok {
+		return store, nil
+	}
+	pending, err := migrating.Migrator().Pending(ctx)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	if len(pending) > 0 {
+		store.Close()
+		return nil, fmt.Errorf("%d schema migrations are pending, run \"api-server migrate up\"", len(pending))
+	}
 
-	router.HandleFunc("GET /rate", currencyRateHandler.GetCurrencyRate)
-	router.HandleFunc("POST /subscribe", subscriptionHandler.Subscribe)
+	return store, nil
+}
 
-	server := http.Server{
-		Addr:    fmt.Sprintf("%s:%s", cfg.HTTPServer.Host, cfg.HTTPServer.Port),
-		Handler: router,
+func openStorageBackend(cfg config.Storage, migrate bool) (storage.Store, error) {
+	keyring, err := newKeyring(cfg.Encryption)
+	if err != nil {
+		return nil, fmt.Errorf("init email encryption: %w", err)
+	}
+
+	switch cfg.Driver {
+	case "sqlite":
+		opts := []sqlite.Option{sqlite.WithSuppressionKey([]byte(cfg.SuppressionKey))}
+		if !migrate {
+			opts = append(opts, sqlite.WithoutMigrations())
+		}
+		if keyring != nil {
+			opts = append(opts, sqlite.WithEncryption(keyring))
+		}
+		return sqlite.New(cfg.DSN, opts...)
+	case "postgres":
+		opts := []postgres.Option{postgres.WithSuppressionKey([]byte(cfg.SuppressionKey))}
+		if !migrate {
+			opts = append(opts, postgres.WithoutMigrations())
+		}
+		if keyring != nil {
+			opts = append(opts, postgres.WithEncryption(keyring))
+		}
+		return postgres.New(cfg.DSN, opts...)
+	case "memory":
+		// nothing is stored at rest, so encryption does not apply
+		return memory.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+	}
+}
+
+// newKeyring returns nil when encryption is disabled
+func newKeyring(cfg config.Encryption) (*fieldcrypt.Keyring, error) {
+	if cfg.CurrentKey == "" {
+		return nil, nil
 	}
 
-	log.Info("starting server", "host", cfg.HTTPServer.Host, "port", cfg.HTTPServer.Port)
+	encoded := make(map[string]string, len(cfg.Keys))
+	for id, key := range cfg.Keys {
+		encoded[id] = key
+	}
+	if cfg.KeysFile != "" {
+		fileKeys, err := fieldcrypt.LoadKeys(cfg.KeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("load keys: %w", err)
+		}
+		for id, key := range fileKeys {
+			encoded[id] = key
+		}
+	}
 
-	err = server.ListenAndServe()
+	keys := make(map[string][]byte, len(encoded))
+	for id, value := range encoded {
+		key, err := fieldcrypt.DecodeKey(value)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		keys[id] = key
+	}
+	indexKey, err := fieldcrypt.DecodeKey(cfg.IndexKey)
 	if err != nil {
-		log.Error("failed to start server", "error", err)
-		return
+		return nil, fmt.Errorf("index key: %w", err)
 	}
+
+	return fieldcrypt.NewKeyring(cfg.CurrentKey, keys, indexKey)
+}
+
+func newEmailValidator(cfg config.EmailValidation) (*emailaddr.Validator, error) {
+	blockedDomains := cfg.BlockedDomains
+	if cfg.BlocklistFile != "" {
+		fileDomains, err := emailaddr.LoadBlocklist(cfg.BlocklistFile)
+		if err != nil {
+			return nil, fmt.Errorf("load email domain blocklist: %w", err)
+		}
+		blockedDomains = append(blockedDomains, fileDomains...)
+	}
+
+	var resolver emailaddr.MXResolver
+	if cfg.CheckMX {
+		resolver = net.DefaultResolver
+	}
+
+	validator, err := emailaddr.NewValidator(resolver, blockedDomains)
+	if err != nil {
+		return nil, fmt.Errorf("init email validator: %w", err)
+	}
+
+	return validator, nil
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"currency-rates-notifier/internal/config"
+	"currency-rates-notifier/internal/storage"
+	"flag"
+	"fmt"
+	"time"
+)
+
+func migrateSchema(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: api-server migrate [up | down [-steps n] | status]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	action, args := "up", flags.Args()
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+
+	store, err := openStorageBackend(cfg.Storage, false)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	migrating, ok := store.(storage.Migrating)
+	if !ok {
+		return fmt.Errorf("storage driver %q has no schema to migrate", cfg.Storage.Driver)
+	}
+
+	ctx := context.Background()
+	migrator := migrating.Migrator()
+
+	switch action {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("the schema is up to date")
+		}
+	case "down":
+		downFlags := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+		steps := downFlags.Int("steps", 1, "number of migrations to revert")
+		if err := downFlags.Parse(args); err != nil {
+			return err
+		}
+		reverted, err := migrator.Down(ctx, *steps)
+		for _, migration := range reverted {
+			fmt.Printf("reverted %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Local().Format(time.DateTime)
+			}
+			fmt.Printf("%04d_%-30s %s\n", status.Version, status.Name, applied)
+		}
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown migrate action %q", action)
+	}
+
+	return nil
+}
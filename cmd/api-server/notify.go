@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"currency-rates-notifier/internal/api/monobank"
+	"currency-rates-notifier/internal/config"
+	"currency-rates-notifier/internal/job"
+	"currency-rates-notifier/internal/storage"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/wneessen/go-mail"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// sendNow runs the mailing of the notification job at once
+func sendNow(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("send-now", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: api-server send-now [flags]")
+		fmt.Fprintln(flags.Output(), "Mails the current rates to every active subscriber and notifies the channels.")
+		flags.PrintDefaults()
+	}
+	dryRun := flags.Bool("dry-run", false, "print the messages instead of sending them")
+	to := flags.String("to", "", "only mail the subscriber with this address, channels are not notified")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, err := openStorage(ctx, cfg.Storage)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	notifier, err := newCLINotifier(cfg, store, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	if *to == "" {
+		return notifier.Notify(ctx)
+	}
+
+	subscription, err := store.GetSubscriptionByEmail(ctx, *to)
+	if errors.Is(err, storage.SubscriptionNotFound) {
+		return fmt.Errorf("%s is not subscribed", *to)
+	}
+	if err != nil {
+		return err
+	}
+	if subscription.Status != storage.StatusActive {
+		return fmt.Errorf("the subscription of %s is %s", *to, subscription.Status)
+	}
+	return notifier.NotifySubscribers(ctx, []storage.Subscription{subscription})
+}
+
+// retryOutbox mails the subscribers again whose last delivery failed. The delivery log
+// is the outbox, messages are rendered anew from the current rates.
+func retryOutbox(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("outbox", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: api-server outbox retry [flags]")
+		fmt.Fprintln(flags.Output(), "Mails the current rates to the active subscribers whose last delivery failed.")
+		flags.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "retry" {
+		flags.Usage()
+		return errors.New("unknown outbox command")
+	}
+	since := flags.Duration("since", 24*time.Hour, "only retry deliveries that failed within this period")
+	dryRun := flags.Bool("dry-run", false, "print the messages instead of sending them")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, err := openStorage(ctx, cfg.Storage)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ids, err := store.FailedSubscriptionIDs(ctx, time.Now().Add(-*since))
+	if err != nil {
+		return err
+	}
+	var subscriptions []storage.Subscription
+	for _, id := range ids {
+		subscription, err := store.GetSubscription(ctx, id)
+		if errors.Is(err, storage.SubscriptionNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if subscription.Status == storage.StatusActive {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	if len(subscriptions) == 0 {
+		fmt.Println("no failed deliveries to retry")
+		return nil
+	}
+
+	notifier, err := newCLINotifier(cfg, store, *dryRun)
+	if err != nil {
+		return err
+	}
+	return notifier.NotifySubscribers(ctx, subscriptions)
+}
+
+// newCLINotifier logs to stderr. A dry run prints the messages to stdout, records no
+// deliveries and skips the channels.
+func newCLINotifier(cfg *config.Config, store storage.Store, dryRun bool) (*job.CurrencyRateNotifier, error) {
+	log := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	monobankClient := monobank.NewClient(cfg.Monobank.API.URL, &http.Client{}, log, monobankOptions(cfg.Monobank.API)...)
+
+	if dryRun {
+		return job.NewCurrencyRateNotifier(monobankClient, store, store, discardDeliveries{}, printingMailSender{out: os.Stdout}, nil, log, cfg.Email), nil
+	}
+
+	emailClient, err := newEmailClient(cfg.Email)
+	if err != nil {
+		return nil, fmt.Errorf("init email client: %w", err)
+	}
+	return job.NewCurrencyRateNotifier(monobankClient, store, store, store, emailClient, newChannels(cfg.Channels, log), log, cfg.Email), nil
+}
+
+// printingMailSender writes messages to out instead of sending them
+type printingMailSender struct {
+	out io.Writer
+}
+
+func (p printingMailSender) DialWithContext(context.Context) error {
+	return nil
+}
+
+func (p printingMailSender) Send(messages ...*mail.Msg) error {
+	for _, message := range messages {
+		if _, err := message.WriteTo(p.out); err != nil {
+			return err
+		}
+		fmt.Fprint(p.out, "\r\n\r\n")
+	}
+	return nil
+}
+
+func (p printingMailSender) Close() error {
+	return nil
+}
+
+type discardDeliveries struct{}
+
+func (discardDeliveries) SaveDeliveries(context.Context, []storage.Delivery) error {
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"currency-rates-notifier/internal/api/monobank"
+	"currency-rates-notifier/internal/config"
+	"currency-rates-notifier/internal/lib/currency"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// showRates prints the rates Monobank quotes right now
+func showRates(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("rates", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: api-server rates show [-pair USD/UAH]")
+		flags.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "show" {
+		flags.Usage()
+		return errors.New("unknown rates command")
+	}
+	pairFlag := flags.String("pair", "", "only show this currency pair")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	var pair currency.Pair
+	if *pairFlag != "" {
+		var err error
+		if pair, err = currency.ParsePair(*pairFlag); err != nil {
+			return err
+		}
+	}
+
+	log := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	client := monobank.NewClient(cfg.Monobank.API.URL, &http.Client{}, log, monobankOptions(cfg.Monobank.API)...)
+	rates, err := client.FetchCurrencyRates(context.Background())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PAIR\tSELL\tBUY\tCROSS\tDATE")
+	found := false
+	for _, rate := range rates {
+		if *pairFlag != "" && rate.Pair() != pair {
+			continue
+		}
+		found = true
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", rate.Pair(), formatRate(rate.RateSell), formatRate(rate.RateBuy),
+			formatRate(rate.RateCross), time.Unix(rate.Date, 0).Format(time.RFC3339))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if !found && *pairFlag != "" {
+		return fmt.Errorf("monobank does not quote %s", pair)
+	}
+	return nil
+}
+
+// formatRate shows a missing rate, which Monobank sends as zero, as a dash
+func formatRate(rate float64) string {
+	if rate == 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(rate, 'f', -1, 64)
+}
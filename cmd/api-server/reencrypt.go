@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"currency-rates-notifier/internal/config"
+	"currency-rates-notifier/internal/storage"
+	"flag"
+	"fmt"
+)
+
+// reencryptSubscribers encrypts addresses stored in plaintext or with a previous key.
+// After it has run the previous keys can be removed from the configuration.
+func reencryptSubscribers(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("reencrypt", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: api-server reencrypt")
+		fmt.Fprintln(flags.Output(), "Encrypts subscriber addresses with storage.encryption.currentKey and recomputes their blind index.")
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if cfg.Storage.Encryption.CurrentKey == "" {
+		return fmt.Errorf("%w: set storage.encryption.currentKey", storage.EncryptionDisabled)
+	}
+
+	store, err := openStorage(context.Background(), cfg.Storage)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	reencrypting, ok := store.(storage.Reencrypting)
+	if !ok {
+		return fmt.Errorf("storage driver %q does not encrypt addresses", cfg.Storage.Driver)
+	}
+
+	updated, err := reencrypting.ReencryptSubscriptions(context.Background())
+	fmt.Printf("re-encrypted %d subscribers\n", updated)
+	return err
+}
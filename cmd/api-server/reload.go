@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"currency-rates-notifier/internal/config"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// configPollInterval is how often the config file is checked for changes
+const configPollInterval = 5 * time.Second
+
+// reloadable are the settings, or prefixes of them, serve applies without a restart.
+// email.validation is not among them, the validator is shared by several handlers.
+var reloadable = []string{
+	"email.host", "email.user", "email.password", "email.envelopeFrom", "email.from",
+	"email.subject", "email.messageTemplate", "email.schedule",
+	"monobank.", "channels.", "subscribe.revealExisting", "subscribe.rateLimit.",
+	"privacy.linkTTL", "privacy.baseURL", "retention.",
+}
+
+// configReloader reads the config again on SIGHUP and when the config file changes. A
+// config that fails to read or validate is logged and the running one is kept.
+type configReloader struct {
+	path    string
+	load    func() (*config.Config, error)
+	apply   func(cfg *config.Config) error
+	current *config.Config
+	modTime time.Time
+	log     *slog.Logger
+}
+
+// newConfigReloader watches the file at path, which is empty when the config is read
+// from the environment only and then reloads on SIGHUP alone
+func newConfigReloader(path string, load func() (*config.Config, error), apply func(cfg *config.Config) error, current *config.Config, log *slog.Logger) *configReloader {
+	r := &configReloader{path: path, load: load, apply: apply, current: current, log: log}
+	r.modTime, _ = r.fileModTime()
+	return r
+}
+
+func (r *configReloader) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var poll <-chan time.Time
+	if r.path != "" {
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.reload("SIGHUP")
+		case <-poll:
+			r.checkFile()
+		}
+	}
+}
+
+// checkFile reloads when the modification time of the file changed
+func (r *configReloader) checkFile() {
+	modTime, err := r.fileModTime()
+	if err != nil || modTime.Equal(r.modTime) {
+		return
+	}
+	r.modTime = modTime
+	r.reload("file change")
+}
+
+func (r *configReloader) fileModTime() (time.Time, error) {
+	if r.path == "" {
+		return time.Time{}, nil
+	}
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func (r *configReloader) reload(trigger string) {
+	cfg, err := r.load()
+	if err != nil {
+		r.log.Error("failed to reload config, keeping the running one", "trigger", trigger, "error", err)
+		return
+	}
+
+	changes := config.Diff(r.current, cfg)
+	if len(changes) == 0 {
+		r.log.Info("Config unchanged.", "trigger", trigger)
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		r.log.Error("failed to reload config, keeping the running one", "trigger", trigger, "changes", changes,
+			"error", err)
+		return
+	}
+	if err := r.apply(cfg); err != nil {
+		r.log.Error("failed to apply config, keeping the running one", "trigger", trigger, "changes", changes,
+			"error", err)
+		return
+	}
+	r.current = cfg
+
+	r.log.Info("Config reloaded.", "trigger", trigger, "changes", changes)
+	var restart []string
+	for _, change := range changes {
+		if key, _, _ := strings.Cut(change, ":"); !isReloadable(key) {
+			restart = append(restart, key)
+		}
+	}
+	if len(restart) > 0 {
+		r.log.Warn("some changed settings take effect after a restart", "settings", restart)
+	}
+}
+
+func isReloadable(key string) bool {
+	for _, prefix := range reloadable {
+		if key == prefix || strings.HasSuffix(prefix, ".") && strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// jobSchedules are the cron entries of the jobs whose schedule can be reloaded
+type jobSchedules struct {
+	cron           *cron.Cron
+	notifyJob      cron.Job
+	retentionJob   cron.Job
+	notifyEntry    cron.EntryID
+	retentionEntry cron.EntryID
+}
+
+// schedule adds the jobs before removing their previous entries, so a failure leaves
+// the running schedules untouched
+func (s *jobSchedules) schedule(notifySpec, retentionSpec string) error {
+	notifyEntry, err := s.cron.AddJob(notifySpec, s.notifyJob)
+	if err != nil {
+		return fmt.Errorf("schedule notification job: %w", err)
+	}
+	retentionEntry, err := s.cron.AddJob(retentionSpec, s.retentionJob)
+	if err != nil {
+		s.cron.Remove(notifyEntry)
+		return fmt.Errorf("schedule retention job: %w", err)
+	}
+
+	// entry ids start at 1, removing 0 is a no-op
+	s.cron.Remove(s.notifyEntry)
+	s.cron.Remove(s.retentionEntry)
+	s.notifyEntry, s.retentionEntry = notifyEntry, retentionEntry
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"currency-rates-notifier/internal/config"
+	"currency-rates-notifier/internal/lib/logger/handler"
+	"errors"
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readSampleConfig(t *testing.T) *config.Config {
+	t.Helper()
+	cfg, err := config.ReadConfig("../../config/local.yaml")
+	require.NoError(t, err)
+	return cfg
+}
+
+func TestReload(t *testing.T) {
+	current := readSampleConfig(t)
+	next := readSampleConfig(t)
+	next.Email.Subject = "Rates"
+
+	var loadErr, applyErr error
+	var applied []*config.Config
+	r := newConfigReloader("", func() (*config.Config, error) { return next, loadErr },
+		func(cfg *config.Config) error {
+			if applyErr == nil {
+				applied = append(applied, cfg)
+			}
+			return applyErr
+		}, current, slog.New(handler.NewNoOpHandler()))
+
+	loadErr = errors.New("unreadable")
+	r.reload("test")
+	require.Same(t, current, r.current, "a config that cannot be read is not applied")
+	loadErr = nil
+
+	next.Port = "0"
+	r.reload("test")
+	require.Empty(t, applied, "an invalid config is not applied")
+	require.Same(t, current, r.current)
+	next.Port = current.Port
+
+	applyErr = errors.New("no mail server")
+	r.reload("test")
+	require.Same(t, current, r.current, "a config that fails to apply is not taken over")
+	applyErr = nil
+
+	r.reload("test")
+	require.Equal(t, []*config.Config{next}, applied)
+	require.Same(t, next, r.current)
+
+	r.reload("test")
+	require.Len(t, applied, 1, "an unchanged config is not applied again")
+}
+
+func TestReloadOnFileChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("env: local\n"), 0o600))
+
+	current := readSampleConfig(t)
+	var loads int
+	load := func() (*config.Config, error) {
+		loads++
+		next := readSampleConfig(t)
+		next.Email.Subject = "Rates"
+		return next, nil
+	}
+	r := newConfigReloader(path, load, func(*config.Config) error { return nil }, current, slog.New(handler.NewNoOpHandler()))
+
+	r.checkFile()
+	require.Zero(t, loads, "an unchanged file is not read")
+
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, later, later))
+	r.checkFile()
+	require.Equal(t, 1, loads)
+	require.Equal(t, "Rates", r.current.Email.Subject)
+
+	r.checkFile()
+	require.Equal(t, 1, loads)
+}
+
+func TestJobSchedules(t *testing.T) {
+	c := cron.New()
+	schedules := &jobSchedules{cron: c, notifyJob: cron.FuncJob(func() {}), retentionJob: cron.FuncJob(func() {})}
+
+	require.NoError(t, schedules.schedule("0 1 * * *", "0 3 * * *"))
+	require.Len(t, c.Entries(), 2)
+	before := schedules.notifyEntry
+
+	require.Error(t, schedules.schedule("0 2 * * *", "nope"))
+	require.Len(t, c.Entries(), 2, "the entry added for the notification job is removed again")
+	require.Equal(t, before, schedules.notifyEntry)
+	require.Equal(t, before, c.Entries()[0].ID)
+
+	require.NoError(t, schedules.schedule("0 2 * * *", "0 4 * * *"))
+	require.Len(t, c.Entries(), 2, "the previous entries are replaced")
+	require.NotEqual(t, before, schedules.notifyEntry)
+}
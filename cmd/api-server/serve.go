@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"currency-rates-notifier/internal/api/monobank"
+	"currency-rates-notifier/internal/api/slack"
+	"currency-rates-notifier/internal/api/teams"
+	"currency-rates-notifier/internal/bounce"
+	"currency-rates-notifier/internal/bulk"
+	"currency-rates-notifier/internal/config"
+	"currency-rates-notifier/internal/handler"
+	"currency-rates-notifier/internal/job"
+	"currency-rates-notifier/internal/lib/apikey"
+	"currency-rates-notifier/internal/lib/httputil"
+	"currency-rates-notifier/internal/lib/verifier"
+	"currency-rates-notifier/internal/middleware"
+	"currency-rates-notifier/internal/privacy"
+	"errors"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"github.com/wneessen/go-mail"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// serve runs the HTTP server and the scheduled jobs until SIGINT or SIGTERM. The config
+// is loaded again on SIGHUP and when the file at configPath changes.
+func serve(cfg *config.Config, configPath string, loadConfig func() (*config.Config, error), log *slog.Logger) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	monobankClient := monobank.NewClient(cfg.Monobank.API.URL, &http.Client{}, log, monobankOptions(cfg.Monobank.API)...)
+
+	storage, err := openStorage(ctx, cfg.Storage)
+	if err != nil {
+		return fmt.Errorf("init storage: %w", err)
+	}
+
+	emailValidator, err := newEmailValidator(cfg.Email.Validation)
+	if err != nil {
+		return err
+	}
+
+	clientIPResolver, err := httputil.NewClientIPResolver(cfg.HTTPServer.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("init client IP resolver: %w", err)
+	}
+
+	subscribeVerifier, challengeHandler, err := newSubscribeVerifier(cfg.Subscribe.Verification, clientIPResolver, log)
+	if err != nil {
+		return fmt.Errorf("init subscribe verification: %w", err)
+	}
+
+	staticTokens := make([]apikey.StaticToken, 0, len(cfg.Admin.Tokens))
+	for _, token := range cfg.Admin.Tokens {
+		staticTokens = append(staticTokens, apikey.StaticToken{Name: token.Name, Token: token.Token, Scope: token.Scope})
+	}
+	adminAuthenticator, err := apikey.NewAuthenticator(storage, staticTokens)
+	if err != nil {
+		return fmt.Errorf("init admin authentication: %w", err)
+	}
+
+	emailClient, err := newEmailClient(cfg.Email)
+	if err != nil {
+		return fmt.Errorf("init email client: %w", err)
+	}
+
+	var (
+		privacyService *privacy.Service
+		privacyHandler *handler.PrivacyHandler
+	)
+	if cfg.Privacy.Secret != "" {
+		privacyService = privacy.NewService(storage, emailClient, privacyOptions(cfg), log)
+		privacyHandler = handler.NewPrivacyHandler(privacyService, emailValidator, log)
+	}
+
+	notifier := job.NewCurrencyRateNotifier(monobankClient, storage, storage, storage, emailClient, newChannels(cfg.Channels, log), log, cfg.Email)
+
+	// jobs are not bound to ctx so that an in-flight mailing can complete during shutdown
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+
+	c := cron.New()
+	retention := job.NewRetentionPruner(storage, cfg.Retention, log)
+	schedules := &jobSchedules{
+		cron: c,
+		notifyJob: cron.FuncJob(func() {
+			if err := notifier.Notify(jobCtx); err != nil {
+				log.Error("failed to deliver notifications", "error", err)
+			}
+		}),
+		retentionJob: cron.FuncJob(func() { retention.Prune(jobCtx) }),
+	}
+	if err := schedules.schedule(cfg.Email.Schedule, cfg.Retention.Schedule); err != nil {
+		return err
+	}
+	c.Start()
+
+	poller := job.NewCurrencyRatePoller(monobankClient, cfg.Poller.Interval, cfg.Poller.HistorySize, log)
+	go poller.Run(ctx)
+
+	hub := handler.NewCurrencyRateHub(poller, log)
+	go hub.Run(ctx)
+
+	if privacyService != nil {
+		go privacyService.Run(ctx)
+	}
+
+	bounceProcessor := bounce.NewProcessor(storage, cfg.Bounces.Threshold, log)
+	bounceMailbox, err := newBounceMailbox(cfg.Bounces.Mailbox)
+	if err != nil {
+		return fmt.Errorf("init bounce mailbox: %w", err)
+	}
+	if bounceMailbox != nil {
+		go job.NewBounceCollector(bounceMailbox, bounceProcessor, cfg.Bounces.Mailbox.Interval, log).Run(ctx)
+	}
+	var bounceHandler *handler.BounceHandler
+	if cfg.Bounces.WebhookToken != "" {
+		bounceHandler = handler.NewBounceHandler(bounceProcessor, cfg.Bounces.WebhookToken, log)
+	}
+
+	subscribeLimiter := middleware.NewRateLimiter(cfg.Subscribe.RateLimit.RequestsPerMinute, cfg.Subscribe.RateLimit.Burst, clientIPResolver)
+	subscriptionHandler := handler.NewSubscriptionHandler(storage, emailValidator, subscribeVerifier, !cfg.Subscribe.RevealExisting, log)
+
+	// applyConfig swaps the settings that can change without a restart. Everything that
+	// can fail comes first, so a failure leaves the running settings untouched.
+	applyConfig := func(next *config.Config) error {
+		emailClient, err := newEmailClient(next.Email)
+		if err != nil {
+			return fmt.Errorf("init email client: %w", err)
+		}
+		if err := schedules.schedule(next.Email.Schedule, next.Retention.Schedule); err != nil {
+			return err
+		}
+
+		monobankClient.Reconfigure(next.Monobank.API.URL, monobankOptions(next.Monobank.API)...)
+		notifier.Reconfigure(job.NotifierSettings{Email: next.Email, EmailClient: emailClient, Channels: newChannels(next.Channels, log)})
+		retention.Reconfigure(next.Retention)
+		if privacyService != nil {
+			privacyService.Reconfigure(emailClient, privacyOptions(next))
+		}
+		subscribeLimiter.SetLimit(next.Subscribe.RateLimit.RequestsPerMinute, next.Subscribe.RateLimit.Burst)
+		subscriptionHandler.SetUniformResponse(!next.Subscribe.RevealExisting)
+		return nil
+	}
+	go newConfigReloader(configPath, loadConfig, applyConfig, cfg, log).Run(ctx)
+
+	router := handler.NewRouter(handler.Handlers{
+		CurrencyRate:       handler.NewCurrencyRateHandler(monobankClient, log),
+		CurrencyRateStream: handler.NewCurrencyRateStreamHandler(poller, cfg.Stream.HeartbeatInterval, log),
+		CurrencyRateWS:     handler.NewCurrencyRateWSHandler(hub, cfg.WebSocket.SendBufferSize, cfg.WebSocket.WriteTimeout, cfg.WebSocket.OriginPatterns, log),
+		Subscription:       subscriptionHandler,
+		Challenge:          challengeHandler,
+		Privacy:            privacyHandler,
+		Bounce:             bounceHandler,
+
+		SubscribeMiddlewares: []middleware.Middleware{middleware.RateLimit(subscribeLimiter, log)},
+
+		Admin:      handler.NewAdminHandler(storage, storage, storage, bulk.NewImporter(storage, emailValidator), log),
+		AdminRead:  middleware.RequireScope(adminAuthenticator, apikey.ScopeRead, log),
+		AdminWrite: middleware.RequireScope(adminAuthenticator, apikey.ScopeWrite, log),
+	})
+
+	server := http.Server{
+		Addr:    fmt.Sprintf("%s:%s", cfg.HTTPServer.Host, cfg.HTTPServer.Port),
+		Handler: middleware.Chain(router, middleware.RequestID(log), middleware.AccessLog(log), middleware.Recover(log)),
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Info("starting server", "host", cfg.HTTPServer.Host, "port", cfg.HTTPServer.Port)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+	}()
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		log.Info("shutting down")
+	case err := <-serverErr:
+		runErr = fmt.Errorf("start server: %w", err)
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTPServer.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Error("failed to shut down server gracefully", "error", err)
+	}
+
+	jobsDone := c.Stop()
+	select {
+	case <-jobsDone.Done():
+	case <-shutdownCtx.Done():
+		log.Warn("running jobs did not finish in time, cancelling")
+		cancelJobs()
+		<-jobsDone.Done()
+	}
+
+	if err := storage.Close(); err != nil {
+		log.Error("failed to close storage", "error", err)
+	}
+
+	log.Info("server stopped")
+	return runErr
+}
+
+func monobankOptions(cfg config.API) []monobank.Option {
+	return []monobank.Option{
+		monobank.WithRequestTimeout(cfg.Timeout),
+		monobank.WithRetry(cfg.MaxRetries, cfg.RetryBaseDelay, cfg.RetryMaxDelay),
+	}
+}
+
+func newEmailClient(cfg config.Email) (*mail.Client, error) {
+	return mail.NewClient(cfg.Host,
+		mail.WithSMTPAuth(mail.SMTPAuthPlain), mail.WithTLSPortPolicy(mail.TLSMandatory),
+		mail.WithUsername(cfg.User), mail.WithPassword(cfg.Password),
+	)
+}
+
+func newChannels(cfg config.Channels, log *slog.Logger) []job.Channel {
+	var channels []job.Channel
+	if cfg.Slack.URL != "" {
+		channels = append(channels, job.NewSlackChannel(slack.NewClient(cfg.Slack.URL, log)))
+	}
+	if cfg.Teams.URL != "" {
+		channels = append(channels, job.NewTeamsChannel(teams.NewClient(cfg.Teams.URL, log)))
+	}
+	return channels
+}
+
+func privacyOptions(cfg *config.Config) privacy.Options {
+	baseURL := cfg.Privacy.BaseURL
+	return privacy.Options{
+		Secret:  []byte(cfg.Privacy.Secret),
+		LinkTTL: cfg.Privacy.LinkTTL,
+		LinkURL: func(action string) string { return baseURL + handler.APIPrefix + "/privacy/" + action },
+		From:    cfg.Email.From,
+	}
+}
+
+func newSubscribeVerifier(cfg config.Verification, resolver *httputil.ClientIPResolver, log *slog.Logger) (handler.RequestVerifier, *handler.ChallengeHandler, error) {
+	switch cfg.Provider {
+	case "":
+		return nil, nil, nil
+	case "turnstile", "hcaptcha":
+		if cfg.Secret == "" {
+			return nil, nil, fmt.Errorf("secret is required for %s verification", cfg.Provider)
+		}
+		verifyURL := cfg.VerifyURL
+		if verifyURL == "" && cfg.Provider == "turnstile" {
+			verifyURL = verifier.TurnstileVerifyURL
+		} else if verifyURL == "" {
+			verifyURL = verifier.HCaptchaVerifyURL
+		}
+		return verifier.NewCaptcha(verifyURL, cfg.Secret, resolver, log), nil, nil
+	case "pow":
+		key := []byte(cfg.Secret)
+		if len(key) == 0 {
+			// challenges then only verify on the replica that issued them
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return nil, nil, err
+			}
+		}
+		pow := verifier.NewProofOfWork(key, cfg.Difficulty, cfg.ChallengeTTL)
+		return pow, handler.NewChallengeHandler(pow, log), nil
+	default:
+		return nil, nil, fmt.Errorf("unknown verification provider %q", cfg.Provider)
+	}
+}
+
+func newBounceMailbox(cfg config.BounceMailbox) (bounce.Mailbox, error) {
+	server := bounce.Server{Addr: cfg.Address, User: cfg.User, Password: cfg.Password, TLS: cfg.TLS.Or(true), Timeout: cfg.Timeout}
+	switch cfg.Protocol {
+	case "":
+		return nil, nil
+	case "imap":
+		return bounce.NewIMAP(server, cfg.Folder), nil
+	case "pop3":
+		return bounce.NewPOP3(server), nil
+	case "maildir":
+		return bounce.NewMaildir(cfg.Address), nil
+	default:
+		return nil, fmt.Errorf("unknown mailbox protocol %q", cfg.Protocol)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"currency-rates-notifier/internal/api/monobank"
+	"currency-rates-notifier/internal/bulk"
+	"currency-rates-notifier/internal/config"
+	"currency-rates-notifier/internal/lib/currency"
+	"currency-rates-notifier/internal/storage"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+func importSubscribers(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: api-server import [flags] <file.csv | ->")
+		flags.PrintDefaults()
+	}
+	dryRun := flags.Bool("dry-run", false, "validate the file and report what would be imported without storing anything")
+	status := flags.String("status", storage.StatusActive, "status of the imported subscriptions")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("a single CSV file is required")
+	}
+	if *status != storage.StatusActive && *status != storage.StatusUnsubscribed {
+		return fmt.Errorf("unknown status %q", *status)
+	}
+
+	var in io.Reader = os.Stdin
+	if name := flags.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, err := openStorage(ctx, cfg.Storage)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	validator, err := newEmailValidator(cfg.Email.Validation)
+	if err != nil {
+		return err
+	}
+
+	report, importErr := bulk.NewImporter(store, validator).Import(ctx, in, bulk.ImportOptions{Status: *status, DryRun: *dryRun})
+	if !*dryRun && report.Imported > 0 {
+		recordAuditEntry(ctx, store, "subscriber.import", "subscribers", map[string]any{"status": *status, "total": report.Total, "imported": report.Imported})
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		printImportReport(report)
+	}
+
+	return importErr
+}
+
+func printImportReport(report bulk.ImportReport) {
+	for _, row := range report.Rows {
+		fmt.Printf("line %d: %s %q", row.Line, row.Result, row.Email)
+		for _, err := range row.Errors {
+			fmt.Printf("; %s", err)
+		}
+		fmt.Println()
+	}
+
+	verb := "imported"
+	if report.DryRun {
+		verb = "would import"
+	}
+	fmt.Printf("%d rows: %s %d, invalid %d, duplicates %d, already subscribed %d, suppressed %d\n",
+		report.Total, verb, report.Imported, report.Invalid, report.Duplicates, report.Existing, report.Suppressed)
+}
+
+func exportSubscribers(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", bulk.FormatCSV, "output format, csv or json")
+	status := flags.String("status", "", "only export subscriptions with this status")
+	output := flags.String("o", "-", "output file, - for stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if !bulk.ValidFormat(*format) {
+		return fmt.Errorf("unsupported format %q", *format)
+	}
+
+	store, err := openStorage(context.Background(), cfg.Storage)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	out := os.Stdout
+	if *output != "-" {
+		out, err = os.Create(*output)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = bulk.Export(context.Background(), store, out, *format, *status)
+	if out != os.Stdout {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+func manageSubscribers(cfg *config.Config, args []string) error {
+	usage := "usage: api-server subscribers [list [flags] | add [-pairs USD/UAH,...] <email> | remove <id | email>]"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return errors.New("a subscribers command is required")
+	}
+
+	ctx := context.Background()
+	store, err := openStorage(ctx, cfg.Storage)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch args[0] {
+	case "list":
+		return listSubscribers(ctx, store, args[1:])
+	case "add":
+		return addSubscriber(ctx, cfg, store, args[1:])
+	case "remove":
+		return removeSubscriber(ctx, store, args[1:])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		return fmt.Errorf("unknown subscribers command %q", args[0])
+	}
+}
+
+func listSubscribers(ctx context.Context, store storage.Store, args []string) error {
+	flags := flag.NewFlagSet("subscribers list", flag.ContinueOnError)
+	search := flags.String("search", "", "only list addresses containing this text")
+	status := flags.String("status", "", "only list subscriptions with this status")
+	limit := flags.Int("limit", 50, "maximum number of subscriptions to list")
+	offset := flags.Int("offset", 0, "number of subscriptions to skip")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	subscriptions, total, err := store.ListSubscriptions(ctx, storage.SubscriptionFilter{Search: *search, Status: *status, Limit: *limit, Offset: *offset})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tEMAIL\tSTATUS\tPAIRS\tCREATED")
+	for _, subscription := range subscriptions {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", subscription.ID, subscription.Email, subscription.Status,
+			strings.Join(subscription.Pairs, ","), subscription.CreatedAt.Format(time.RFC3339))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("%d of %d subscriptions\n", len(subscriptions), total)
+	return nil
+}
+
+func addSubscriber(ctx context.Context, cfg *config.Config, store storage.Store, args []string) error {
+	flags := flag.NewFlagSet("subscribers add", flag.ContinueOnError)
+	pairList := flags.String("pairs", monobank.USDToUAH.String(), "comma separated currency pairs")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("a single email address is required")
+	}
+
+	validator, err := newEmailValidator(cfg.Email.Validation)
+	if err != nil {
+		return err
+	}
+	email, err := validator.Normalize(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var pairs []string
+	for _, value := range strings.Split(*pairList, ",") {
+		pair, err := currency.ParsePair(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(pairs, pair.String()) {
+			pairs = append(pairs, pair.String())
+		}
+	}
+
+	saved, err := store.SaveSubscription(ctx, storage.Subscription{Email: email, Status: storage.StatusActive, Pairs: pairs})
+	if errors.Is(err, storage.EmailExists) {
+		return fmt.Errorf("%s is already subscribed", email)
+	}
+	if err != nil {
+		return err
+	}
+	recordAuditEntry(ctx, store, "subscriber.create", storage.SubscriberAuditTarget(saved.ID), map[string]any{"status": saved.Status, "pairs": saved.Pairs})
+	fmt.Printf("added subscription %d for %s\n", saved.ID, saved.Email)
+	return nil
+}
+
+func removeSubscriber(ctx context.Context, store storage.Store, args []string) error {
+	if len(args) != 1 {
+		return errors.New("a single subscription id or email address is required")
+	}
+
+	var subscription storage.Subscription
+	if id, err := strconv.ParseInt(args[0], 10, 64); err == nil {
+		subscription, err = store.GetSubscription(ctx, id)
+		if err != nil {
+			return err
+		}
+	} else {
+		subscription, err = store.GetSubscriptionByEmail(ctx, args[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := store.DeleteSubscription(ctx, subscription.ID); err != nil {
+		return err
+	}
+	recordAuditEntry(ctx, store, "subscriber.delete", storage.SubscriberAuditTarget(subscription.ID), map[string]any{"status": subscription.Status, "pairs": subscription.Pairs})
+	fmt.Printf("removed subscription %d\n", subscription.ID)
+	return nil
+}
+
+// recordAuditEntry records a change made from the command line. A failure is reported
+// but does not fail the command, the change is made already. Details never hold an
+// address, the audit log outlives the subscription.
+func recordAuditEntry(ctx context.Context, store storage.Store, action, target string, details map[string]any) {
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode audit details: %s\n", err)
+	}
+	entry := storage.AuditEntry{Actor: "cli", Action: action, Target: target, Details: string(encoded)}
+	if err := store.SaveAuditEntry(ctx, entry); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to record audit entry: %s\n", err)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"currency-rates-notifier/internal/storage"
+	"currency-rates-notifier/internal/storage/memory"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSubscriberChangesAreAudited(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	cfg := readSampleConfig(t)
+
+	require.NoError(t, addSubscriber(ctx, cfg, store, []string{"-pairs", "EUR/UAH", "user@example.com"}))
+	subscription, err := store.GetSubscriptionByEmail(ctx, "user@example.com")
+	require.NoError(t, err)
+	require.NoError(t, removeSubscriber(ctx, store, []string{"user@example.com"}))
+
+	entries, total, err := store.ListAuditEntries(ctx, 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, total)
+	actions := make(map[string]storage.AuditEntry)
+	for _, entry := range entries {
+		require.Equal(t, "cli", entry.Actor)
+		require.Equal(t, storage.SubscriberAuditTarget(subscription.ID), entry.Target)
+		actions[entry.Action] = entry
+	}
+	require.Contains(t, actions, "subscriber.create")
+	require.Contains(t, actions, "subscriber.delete")
+	require.JSONEq(t, `{"status":"active","pairs":["EUR/UAH"]}`, actions["subscriber.create"].Details)
+	require.JSONEq(t, `{"status":"active","pairs":["EUR/UAH"]}`, actions["subscriber.delete"].Details)
+}
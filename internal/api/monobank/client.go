@@ -1,21 +1,75 @@
 package monobank
 
 import (
+	"context"
+	"currency-rates-notifier/internal/lib/currency"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math/rand/v2"
 	"net/http"
+	"strconv"
+	"sync/atomic"
 	"time"
 )
 
 type Client struct {
-	baseURL string
-	log     *slog.Logger
+	settings   atomic.Pointer[settings]
+	httpClient *http.Client
+	log        *slog.Logger
 }
 
-func NewClient(baseURL string, log *slog.Logger) *Client {
-	return &Client{baseURL: baseURL, log: log}
+// settings are replaced as a whole by Reconfigure, a request keeps the ones it started with
+type settings struct {
+	baseURL        string
+	requestTimeout time.Duration
+	maxRetries     int
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+}
+
+type Option func(*settings)
+
+const defaultRequestTimeout = 10 * time.Second
+
+// WithRequestTimeout limits the duration of a single attempt, a timeout that is not
+// positive keeps the default
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(s *settings) {
+		if timeout <= 0 {
+			timeout = defaultRequestTimeout
+		}
+		s.requestTimeout = timeout
+	}
+}
+
+// WithRetry enables retries of network errors, 5xx and 429 responses with jittered
+// exponential backoff capped at maxDelay. A Retry-After longer than maxDelay is not waited for.
+func WithRetry(maxRetries int, baseDelay, maxDelay time.Duration) Option {
+	return func(s *settings) {
+		s.maxRetries = maxRetries
+		s.retryBaseDelay = baseDelay
+		s.retryMaxDelay = maxDelay
+	}
+}
+
+func NewClient(baseURL string, httpClient *http.Client, log *slog.Logger, opts ...Option) *Client {
+	c := &Client{httpClient: httpClient, log: log}
+	c.Reconfigure(baseURL, opts...)
+
+	return c
+}
+
+// Reconfigure replaces the base URL and the options, requests in flight finish with the
+// previous ones
+func (c *Client) Reconfigure(baseURL string, opts ...Option) {
+	s := &settings{baseURL: baseURL, requestTimeout: defaultRequestTimeout}
+	for _, opt := range opts {
+		opt(s)
+	}
+	c.settings.Store(s)
 }
 
 type CurrencyRate struct {
@@ -28,26 +82,124 @@ type CurrencyRate struct {
 	FormattedDate string  `json:"-"`
 }
 
+func (r CurrencyRate) Pair() currency.Pair {
+	return currency.Pair{Base: r.CurrencyCodeA, Quote: r.CurrencyCodeB}
+}
+
 const (
 	CurrencyUSD = 840 // ISO 4217 code for USD
 	CurrencyUAH = 980 // ISO 4217 code for UAH
 )
 
-func findUSDToUAH(rates []CurrencyRate) int {
+var USDToUAH = currency.Pair{Base: CurrencyUSD, Quote: CurrencyUAH}
+
+func findRate(rates []CurrencyRate, pair currency.Pair) int {
 	for index, rate := range rates {
-		if rate.CurrencyCodeA == CurrencyUSD && rate.CurrencyCodeB == CurrencyUAH {
+		if rate.Pair() == pair {
 			return index
 		}
 	}
 	return -1
 }
 
-func (c *Client) FetchCurrencyRates() ([]CurrencyRate, error) {
-	url := fmt.Sprintf("%s/bank/currency", c.baseURL)
+func (c *Client) FetchCurrencyRates(ctx context.Context) ([]CurrencyRate, error) {
+	s := c.settings.Load()
+	url := fmt.Sprintf("%s/bank/currency", s.baseURL)
+
+	body, err := c.getWithRetry(ctx, s, url)
+	if err != nil {
+		return nil, err
+	}
 
-	resp, err := http.Get(url)
+	var rates []CurrencyRate
+	err = json.Unmarshal(body, &rates)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, &DecodeError{Err: err}
+	}
+
+	for i, rate := range rates {
+		rates[i].FormattedDate = time.Unix(rate.Date, 0).Format(time.RFC3339)
+	}
+
+	return rates, nil
+}
+
+func (c *Client) getWithRetry(ctx context.Context, s *settings, url string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		body, err := c.get(ctx, s, url)
+		if err == nil {
+			return body, nil
+		}
+
+		delay, retry := s.retryDelay(err, attempt)
+		if !retry {
+			return nil, err
+		}
+
+		c.log.Warn("retrying monobank request", "attempt", attempt+1, "delay", delay, "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%w (last error: %w)", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *settings) retryDelay(err error, attempt int) (time.Duration, bool) {
+	if attempt >= s.maxRetries {
+		return 0, false
+	}
+
+	var rateLimited *RateLimitedError
+	if errors.As(err, &rateLimited) {
+		if rateLimited.RetryAfter > s.retryMaxDelay {
+			return 0, false
+		}
+		if rateLimited.RetryAfter > 0 {
+			return rateLimited.RetryAfter, true
+		}
+		return s.backoff(attempt), true
+	}
+
+	var unavailable *UpstreamUnavailableError
+	if errors.As(err, &unavailable) {
+		return s.backoff(attempt), true
+	}
+
+	return 0, false
+}
+
+// backoff returns a "full jitter" delay between zero and the exponential cap
+func (s *settings) backoff(attempt int) time.Duration {
+	ceiling := s.retryMaxDelay
+	if shifted := s.retryBaseDelay << attempt; shifted > 0 && shifted < ceiling {
+		ceiling = shifted
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+
+	return rand.N(ceiling)
+}
+
+func (c *Client) get(ctx context.Context, s *settings, url string) ([]byte, error) {
+	attemptCtx, cancel := context.WithTimeout(ctx, s.requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(attemptCtx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to send request: %w", err)
+		}
+		return nil, &UpstreamUnavailableError{Err: fmt.Errorf("failed to send request: %w", err)}
 	}
 
 	defer func(Body io.ReadCloser) {
@@ -57,38 +209,55 @@ func (c *Client) FetchCurrencyRates() ([]CurrencyRate, error) {
 		}
 	}(resp.Body)
 
-	if resp.StatusCode != http.StatusOK {
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return nil, &RateLimitedError{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return nil, &UpstreamUnavailableError{StatusCode: resp.StatusCode}
+	case resp.StatusCode != http.StatusOK:
 		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, &UpstreamUnavailableError{Err: fmt.Errorf("failed to read response body: %w", err)}
 	}
 
-	var rates []CurrencyRate
-	err = json.Unmarshal(body, &rates)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode JSON response: %w", err)
-	}
+	return body, nil
+}
 
-	for i, rate := range rates {
-		rates[i].FormattedDate = time.Unix(rate.Date, 0).Format(time.RFC3339)
+// parseRetryAfter accepts both delay-seconds and HTTP-date forms
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
 	}
 
-	return rates, nil
+	return 0
 }
 
-func (c *Client) FetchUSDToUAHCurrencyRate() (CurrencyRate, error) {
-	rates, err := c.FetchCurrencyRates()
+// FetchCurrencyRate returns RateNotFound if Monobank does not quote the pair
+func (c *Client) FetchCurrencyRate(ctx context.Context, pair currency.Pair) (CurrencyRate, error) {
+	rates, err := c.FetchCurrencyRates(ctx)
 	if err != nil {
 		return CurrencyRate{}, err
 	}
 
-	USDToUAHIndex := findUSDToUAH(rates)
-	if USDToUAHIndex >= 0 {
-		return rates[USDToUAHIndex], nil
+	index := findRate(rates, pair)
+	if index >= 0 {
+		return rates[index], nil
 	}
 
-	return CurrencyRate{}, fmt.Errorf("cannot find usd to uah rate")
+	return CurrencyRate{}, fmt.Errorf("%s: %w", pair, RateNotFound)
+}
+
+func (c *Client) FetchUSDToUAHCurrencyRate(ctx context.Context) (CurrencyRate, error) {
+	return c.FetchCurrencyRate(ctx, USDToUAH)
 }
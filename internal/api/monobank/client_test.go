@@ -0,0 +1,86 @@
+package monobank
+
+import (
+	"context"
+	"currency-rates-notifier/internal/lib/logger/handler"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(url string) *Client {
+	return NewClient(url, &http.Client{}, slog.New(handler.NewNoOpHandler()),
+		WithRequestTimeout(time.Second),
+		WithRetry(2, time.Millisecond, 50*time.Millisecond),
+	)
+}
+
+func TestFetchCurrencyRatesRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`[{"currencyCodeA":840,"currencyCodeB":980,"date":1,"rateSell":41.5,"rateBuy":41}]`))
+	}))
+	defer server.Close()
+
+	rate, err := newTestClient(server.URL).FetchUSDToUAHCurrencyRate(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 41.5, rate.RateSell)
+	require.Equal(t, int32(3), calls.Load())
+}
+
+func TestFetchCurrencyRatesReturnsTypedErrors(t *testing.T) {
+	t.Run("rate limited", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer server.Close()
+
+		_, err := newTestClient(server.URL).FetchCurrencyRates(context.Background())
+		var rateLimited *RateLimitedError
+		require.True(t, errors.As(err, &rateLimited))
+		require.Equal(t, time.Minute, rateLimited.RetryAfter)
+	})
+
+	t.Run("upstream unavailable", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		_, err := newTestClient(server.URL).FetchCurrencyRates(context.Background())
+		var unavailable *UpstreamUnavailableError
+		require.True(t, errors.As(err, &unavailable))
+		require.Equal(t, http.StatusServiceUnavailable, unavailable.StatusCode)
+	})
+
+	t.Run("decode failure", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"errorDescription":"oops"}`))
+		}))
+		defer server.Close()
+
+		_, err := newTestClient(server.URL).FetchCurrencyRates(context.Background())
+		var decodeErr *DecodeError
+		require.True(t, errors.As(err, &decodeErr))
+	})
+}
+
+func TestWithRequestTimeoutKeepsDefault(t *testing.T) {
+	for _, timeout := range []time.Duration{0, -time.Second} {
+		client := NewClient("http://localhost", &http.Client{}, slog.New(handler.NewNoOpHandler()), WithRequestTimeout(timeout))
+		require.Equal(t, defaultRequestTimeout, client.settings.Load().requestTimeout)
+	}
+
+	client := NewClient("http://localhost", &http.Client{}, slog.New(handler.NewNoOpHandler()), WithRequestTimeout(time.Second))
+	require.Equal(t, time.Second, client.settings.Load().requestTimeout)
+}
@@ -0,0 +1,54 @@
+package monobank
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	RateNotFound = errors.New("currency rate not found")
+)
+
+// RateLimitedError is returned when Monobank answers 429. RetryAfter is zero
+// when the response did not say how long to wait.
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("rate limited by upstream, retry after %s", e.RetryAfter)
+	}
+	return "rate limited by upstream"
+}
+
+// UpstreamUnavailableError is returned on network failures and 5xx responses.
+type UpstreamUnavailableError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *UpstreamUnavailableError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("upstream unavailable: %s", e.Err)
+	}
+	return fmt.Sprintf("upstream unavailable: status code %d", e.StatusCode)
+}
+
+func (e *UpstreamUnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeError is returned when the response body is not valid currency rates JSON.
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode JSON response: %s", e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,42 @@
+package slack
+
+import (
+	"context"
+	"currency-rates-notifier/internal/lib/httputil"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type Text struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type Block struct {
+	Type     string `json:"type"`
+	Text     *Text  `json:"text,omitempty"`
+	Fields   []Text `json:"fields,omitempty"`
+	Elements []Text `json:"elements,omitempty"`
+}
+
+// Message is an incoming webhook payload; Text is the notification fallback for Blocks.
+type Message struct {
+	Text   string  `json:"text"`
+	Blocks []Block `json:"blocks,omitempty"`
+}
+
+type Client struct {
+	webhookURL string
+	httpClient *http.Client
+	log        *slog.Logger
+}
+
+func NewClient(webhookURL string, log *slog.Logger) *Client {
+	return &Client{webhookURL: webhookURL, httpClient: &http.Client{Timeout: 10 * time.Second}, log: log}
+}
+
+// PostMessage posts a message to the configured incoming webhook
+func (c *Client) PostMessage(ctx context.Context, message Message) error {
+	return httputil.PostJSON(ctx, c.httpClient, c.webhookURL, message, c.log)
+}
@@ -0,0 +1,47 @@
+package slack
+
+import (
+	"context"
+	"currency-rates-notifier/internal/lib/logger/handler"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPostMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr string
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "accepted", status: http.StatusAccepted},
+		{name: "rejected", status: http.StatusForbidden, wantErr: "unexpected status code: 403: invalid_token"},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: "unexpected status code: 500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received Message
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodPost, r.Method)
+				require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte("invalid_token"))
+			}))
+			defer server.Close()
+
+			message := Message{Text: "USD/UAH 41.5", Blocks: []Block{{Type: "section", Text: &Text{Type: "mrkdwn", Text: "*USD/UAH*"}}}}
+			err := NewClient(server.URL, slog.New(handler.NewNoOpHandler())).PostMessage(context.Background(), message)
+			require.Equal(t, message, received)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package teams
+
+import (
+	"context"
+	"currency-rates-notifier/internal/lib/httputil"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	AdaptiveCardContentType = "application/vnd.microsoft.card.adaptive"
+	AdaptiveCardSchema      = "http://adaptivecards.io/schemas/adaptive-card.json"
+	AdaptiveCardVersion     = "1.4"
+)
+
+type Fact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+type Element struct {
+	Type   string `json:"type"`
+	Text   string `json:"text,omitempty"`
+	Weight string `json:"weight,omitempty"`
+	Size   string `json:"size,omitempty"`
+	Wrap   bool   `json:"wrap,omitempty"`
+	Facts  []Fact `json:"facts,omitempty"`
+}
+
+type AdaptiveCard struct {
+	Schema  string    `json:"$schema"`
+	Type    string    `json:"type"`
+	Version string    `json:"version"`
+	Body    []Element `json:"body"`
+}
+
+type Attachment struct {
+	ContentType string       `json:"contentType"`
+	Content     AdaptiveCard `json:"content"`
+}
+
+type Message struct {
+	Type        string       `json:"type"`
+	Attachments []Attachment `json:"attachments"`
+}
+
+// NewCardMessage wraps an Adaptive Card into an incoming webhook message
+func NewCardMessage(body ...Element) Message {
+	return Message{
+		Type: "message",
+		Attachments: []Attachment{{
+			ContentType: AdaptiveCardContentType,
+			Content: AdaptiveCard{
+				Schema:  AdaptiveCardSchema,
+				Type:    "AdaptiveCard",
+				Version: AdaptiveCardVersion,
+				Body:    body,
+			},
+		}},
+	}
+}
+
+type Client struct {
+	webhookURL string
+	httpClient *http.Client
+	log        *slog.Logger
+}
+
+func NewClient(webhookURL string, log *slog.Logger) *Client {
+	return &Client{webhookURL: webhookURL, httpClient: &http.Client{Timeout: 10 * time.Second}, log: log}
+}
+
+// PostMessage posts a message to the configured incoming webhook
+func (c *Client) PostMessage(ctx context.Context, message Message) error {
+	return httputil.PostJSON(ctx, c.httpClient, c.webhookURL, message, c.log)
+}
@@ -0,0 +1,48 @@
+package teams
+
+import (
+	"context"
+	"currency-rates-notifier/internal/lib/logger/handler"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPostMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr string
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "accepted", status: http.StatusAccepted},
+		{name: "bad request", status: http.StatusBadRequest, wantErr: "unexpected status code: 400: card rejected"},
+		{name: "too many requests", status: http.StatusTooManyRequests, wantErr: "unexpected status code: 429"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received Message
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodPost, r.Method)
+				require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte("card rejected"))
+			}))
+			defer server.Close()
+
+			message := NewCardMessage(Element{Type: "TextBlock", Text: "USD/UAH", Weight: "bolder"})
+			err := NewClient(server.URL, slog.New(handler.NewNoOpHandler())).PostMessage(context.Background(), message)
+			require.Equal(t, message, received)
+			require.Equal(t, AdaptiveCardContentType, received.Attachments[0].ContentType)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,98 @@
+// Package bounce turns bounce and complaint reports into suppressions. Reports come from
+// delivery status notifications in a mailbox or from webhooks of email service providers.
+package bounce
+
+import (
+	"context"
+	"currency-rates-notifier/internal/storage"
+	"fmt"
+	"log/slog"
+)
+
+const (
+	// TypeHard is a permanent delivery failure, e.g. an unknown mailbox
+	TypeHard = "hard"
+	// TypeSoft is a temporary delivery failure, e.g. a full mailbox. Soft bounces are
+	// only counted in the summary.
+	TypeSoft = "soft"
+	// TypeComplaint is a recipient reporting a message as spam
+	TypeComplaint = "complaint"
+)
+
+// Event is a bounce or complaint of one recipient
+type Event struct {
+	// ID identifies the event across deliveries of the same report, so that a hard bounce
+	// is counted once. It is empty if the report carries no id.
+	ID     string
+	Email  string
+	Type   string
+	Reason string
+}
+
+type Store interface {
+	SuppressEmail(ctx context.Context, email, reason string) error
+	RecordHardBounce(ctx context.Context, email, eventID string) (int, error)
+}
+
+// Summary counts the processed events by type and the suppressions they caused
+type Summary struct {
+	Hard       int `json:"hard"`
+	Soft       int `json:"soft"`
+	Complaints int `json:"complaints"`
+	Suppressed int `json:"suppressed"`
+}
+
+func (s *Summary) Add(other Summary) {
+	s.Hard += other.Hard
+	s.Soft += other.Soft
+	s.Complaints += other.Complaints
+	s.Suppressed += other.Suppressed
+}
+
+// Processor suppresses an address on its first complaint or once it hard bounced
+// threshold times
+type Processor struct {
+	store     Store
+	threshold int
+	log       *slog.Logger
+}
+
+func NewProcessor(store Store, threshold int, log *slog.Logger) *Processor {
+	return &Processor{store: store, threshold: max(threshold, 1), log: log}
+}
+
+func (p *Processor) Process(ctx context.Context, events []Event) (Summary, error) {
+	var summary Summary
+	for _, event := range events {
+		switch event.Type {
+		case TypeHard:
+			summary.Hard++
+			count, err := p.store.RecordHardBounce(ctx, event.Email, event.ID)
+			if err != nil {
+				return summary, fmt.Errorf("record hard bounce: %w", err)
+			}
+			if count < p.threshold {
+				continue
+			}
+			if err := p.store.SuppressEmail(ctx, event.Email, storage.SuppressionBounced); err != nil {
+				return summary, fmt.Errorf("suppress address: %w", err)
+			}
+			// the count keeps growing with further bounces, only the first crossing is reported
+			if count == p.threshold {
+				summary.Suppressed++
+				p.log.Info("suppressed bouncing address", "hard_bounces", count, "reason", event.Reason)
+			}
+		case TypeSoft:
+			summary.Soft++
+		case TypeComplaint:
+			summary.Complaints++
+			if err := p.store.SuppressEmail(ctx, event.Email, storage.SuppressionComplained); err != nil {
+				return summary, fmt.Errorf("suppress address: %w", err)
+			}
+			summary.Suppressed++
+			p.log.Info("suppressed address after a complaint")
+		}
+	}
+
+	return summary, nil
+}
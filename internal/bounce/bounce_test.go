@@ -0,0 +1,136 @@
+package bounce
+
+import (
+	"context"
+	"currency-rates-notifier/internal/lib/logger/handler"
+	"currency-rates-notifier/internal/storage"
+	"currency-rates-notifier/internal/storage/memory"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+const deliveryStatusNotification = "From: MAILER-DAEMON@mx.example.com\r\n" +
+	"Message-ID: <dsn-1@mx.example.com>\r\n" +
+	"To: noreply+42@test.com\r\n" +
+	"Subject: Undelivered Mail Returned to Sender\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/report; report-type=delivery-status; boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"The mail could not be delivered.\r\n" +
+	"--b1\r\n" +
+	"Content-Type: message/delivery-status\r\n" +
+	"\r\n" +
+	"Reporting-MTA: dns; mx.example.com\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; gone@example.com\r\n" +
+	"Action: failed\r\n" +
+	"Status: 5.1.1\r\n" +
+	"Diagnostic-Code: smtp; 550 5.1.1 user unknown\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; <full@example.com>\r\n" +
+	"Action: delayed\r\n" +
+	"Status: 4.2.2\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; fine@example.com\r\n" +
+	"Action: delivered\r\n" +
+	"Status: 2.0.0\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/rfc822-headers\r\n" +
+	"\r\n" +
+	"To: gone@example.com\r\n" +
+	"Subject: Currency rate update\r\n" +
+	"--b1--\r\n"
+
+const feedbackReport = "From: feedback@isp.example\r\n" +
+	"To: noreply+42@test.com\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/report; report-type=feedback-report; boundary=\"b2\"\r\n" +
+	"\r\n" +
+	"--b2\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"This is an abuse report.\r\n" +
+	"--b2\r\n" +
+	"Content-Type: message/feedback-report\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"RmVlZGJhY2stVHlwZTogYWJ1c2UNClVzZXItQWdlbnQ6IElTUC8xLjANClZlcnNpb246IDEN\r\n" +
+	"Cg==\r\n" +
+	"--b2\r\n" +
+	"Content-Type: message/rfc822\r\n" +
+	"\r\n" +
+	"From: rates@test.com\r\n" +
+	"To: <Annoyed@example.com>\r\n" +
+	"Subject: Currency rate update\r\n" +
+	"\r\n" +
+	"41.5\r\n" +
+	"--b2--\r\n"
+
+func TestParseMessage(t *testing.T) {
+	events, err := ParseMessage(strings.NewReader(deliveryStatusNotification))
+	require.NoError(t, err)
+	require.Equal(t, []Event{
+		{ID: "report:<dsn-1@mx.example.com>/0", Email: "gone@example.com", Type: TypeHard, Reason: "smtp; 550 5.1.1 user unknown"},
+		{ID: "report:<dsn-1@mx.example.com>/1", Email: "full@example.com", Type: TypeSoft, Reason: "4.2.2"},
+	}, events)
+
+	events, err = ParseMessage(strings.NewReader(feedbackReport))
+	require.NoError(t, err)
+	require.Equal(t, []Event{{Email: "Annoyed@example.com", Type: TypeComplaint, Reason: "abuse report"}}, events)
+
+	_, err = ParseMessage(strings.NewReader("From: someone@example.com\r\nSubject: Out of office\r\n\r\nBack on Monday.\r\n"))
+	require.ErrorIs(t, err, NotReport)
+}
+
+func TestProcessor(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	processor := NewProcessor(store, 2, slog.New(handler.NewNoOpHandler()))
+
+	summary, err := processor.Process(ctx, []Event{
+		{Email: "gone@example.com", Type: TypeHard},
+		{Email: "full@example.com", Type: TypeSoft},
+		{Email: "annoyed@example.com", Type: TypeComplaint},
+	})
+	require.NoError(t, err)
+	require.Equal(t, Summary{Hard: 1, Soft: 1, Complaints: 1, Suppressed: 1}, summary)
+
+	suppressed, err := store.SuppressedEmails(ctx, []string{"gone@example.com", "full@example.com", "annoyed@example.com"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]struct{}{"annoyed@example.com": {}}, suppressed, "one hard bounce is below the threshold")
+
+	for range 2 {
+		summary, err = processor.Process(ctx, []Event{{Email: "Gone@example.com", Type: TypeHard}})
+		require.NoError(t, err)
+	}
+	require.Equal(t, Summary{Hard: 1}, summary, "a suppression is only reported once")
+
+	results, err := store.ImportSubscriptions(ctx, []storage.Subscription{{Email: "gone@example.com", Status: storage.StatusActive}}, true)
+	require.NoError(t, err)
+	require.Equal(t, []error{storage.EmailSuppressed}, results)
+}
+
+func TestProcessorCountsRepeatedEventsOnce(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	processor := NewProcessor(store, 2, slog.New(handler.NewNoOpHandler()))
+
+	// a webhook retried after a failure delivers the events that were processed already again
+	events := []Event{{ID: "sendgrid:event-1/0", Email: "gone@example.com", Type: TypeHard}}
+	for range 2 {
+		_, err := processor.Process(ctx, events)
+		require.NoError(t, err)
+	}
+	suppressed, err := store.SuppressedEmails(ctx, []string{"gone@example.com"})
+	require.NoError(t, err)
+	require.Empty(t, suppressed, "the same event is counted once")
+
+	summary, err := processor.Process(ctx, []Event{{ID: "sendgrid:event-2/0", Email: "gone@example.com", Type: TypeHard}})
+	require.NoError(t, err)
+	require.Equal(t, Summary{Hard: 1, Suppressed: 1}, summary)
+}
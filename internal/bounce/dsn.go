@@ -0,0 +1,187 @@
+package bounce
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+var (
+	NotReport = errors.New("not a bounce or complaint report")
+)
+
+// ParseMessage extracts events from a delivery status notification (RFC 3464) or an
+// abuse feedback report (RFC 5965). Other messages, e.g. auto-replies, are reported as
+// NotReport.
+func ParseMessage(r io.Reader) ([]Event, error) {
+	message, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("read message: %w", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" || params["boundary"] == "" {
+		return nil, NotReport
+	}
+
+	// a report that is read again, e.g. after a failure, has the same Message-ID
+	messageID := strings.TrimSpace(message.Header.Get("Message-Id"))
+
+	var (
+		events    []Event
+		complaint bool
+		// the recipient of a complaint may only be found in the returned message
+		complainedRecipient string
+	)
+	parts := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read report part: %w", err)
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		switch partType {
+		case "message/delivery-status", "message/global-delivery-status":
+			recipients, err := parseDeliveryStatus(partBody(part))
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, recipients...)
+		case "message/feedback-report":
+			fields, err := readFieldBlocks(partBody(part))
+			if err != nil {
+				return nil, err
+			}
+			complaint = true
+			for _, block := range fields {
+				if recipient := block.Get("Original-Rcpt-To"); recipient != "" {
+					complainedRecipient = recipient
+				}
+			}
+		case "message/rfc822", "text/rfc822-headers":
+			if complainedRecipient != "" {
+				continue
+			}
+			original, err := mail.ReadMessage(io.MultiReader(partBody(part), strings.NewReader("\r\n\r\n")))
+			if err != nil {
+				continue
+			}
+			if to, err := original.Header.AddressList("To"); err == nil && len(to) == 1 {
+				complainedRecipient = to[0].Address
+			}
+		}
+	}
+
+	if complaint {
+		if complainedRecipient == "" {
+			return nil, errors.New("complaint without a recipient")
+		}
+		return []Event{{ID: eventID("report", messageID, 0), Email: address(complainedRecipient), Type: TypeComplaint, Reason: "abuse report"}}, nil
+	}
+	if len(events) == 0 {
+		return nil, NotReport
+	}
+	for i := range events {
+		events[i].ID = eventID("report", messageID, i)
+	}
+
+	return events, nil
+}
+
+// parseDeliveryStatus reads the per-message block and the per-recipient blocks of a
+// delivery status. Failures with a 5.x.x status are hard bounces, delays and failures
+// with a 4.x.x status are soft bounces, successful deliveries are skipped.
+func parseDeliveryStatus(r io.Reader) ([]Event, error) {
+	blocks, err := readFieldBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	for _, block := range blocks {
+		recipient := block.Get("Final-Recipient")
+		if recipient == "" {
+			recipient = block.Get("Original-Recipient")
+		}
+		if recipient == "" {
+			continue
+		}
+
+		status := strings.TrimSpace(block.Get("Status"))
+		reason := strings.TrimSpace(block.Get("Diagnostic-Code"))
+		if reason == "" {
+			reason = status
+		}
+		event := Event{Email: address(recipient), Reason: reason}
+		switch strings.ToLower(strings.TrimSpace(block.Get("Action"))) {
+		case "failed":
+			event.Type = TypeHard
+			if strings.HasPrefix(status, "4") {
+				event.Type = TypeSoft
+			}
+		case "delayed":
+			event.Type = TypeSoft
+		default:
+			continue
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// readFieldBlocks reads header-like field blocks separated by empty lines
+func readFieldBlocks(r io.Reader) ([]textproto.MIMEHeader, error) {
+	reader := textproto.NewReader(bufio.NewReader(r))
+
+	var blocks []textproto.MIMEHeader
+	for {
+		// skip empty lines between blocks
+		for {
+			peek, err := reader.R.Peek(1)
+			if err != nil || (peek[0] != '\r' && peek[0] != '\n') {
+				break
+			}
+			if _, err := reader.ReadLine(); err != nil {
+				break
+			}
+		}
+
+		block, err := reader.ReadMIMEHeader()
+		if len(block) > 0 {
+			blocks = append(blocks, block)
+		}
+		if err == io.EOF {
+			return blocks, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read report fields: %w", err)
+		}
+	}
+}
+
+func partBody(part *multipart.Part) io.Reader {
+	if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+		return base64.NewDecoder(base64.StdEncoding, part)
+	}
+	return part
+}
+
+// address strips the address type of "rfc822; user@example.com" and angle brackets
+func address(field string) string {
+	if _, addr, ok := strings.Cut(field, ";"); ok {
+		field = addr
+	}
+	return strings.Trim(strings.TrimSpace(field), "<>")
+}
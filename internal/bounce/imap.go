@@ -0,0 +1,155 @@
+package bounce
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// IMAP reads the unseen messages of an IMAP folder, processed messages are flagged as seen
+type IMAP struct {
+	server Server
+	folder string
+}
+
+func NewIMAP(server Server, folder string) *IMAP {
+	if folder == "" {
+		folder = "INBOX"
+	}
+	return &IMAP{server: server, folder: folder}
+}
+
+func (m *IMAP) Drain(ctx context.Context, fn func(message io.Reader) error) error {
+	conn, closeConn, err := m.server.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	c := &imapConn{text: textproto.NewConn(conn)}
+	greeting, err := c.text.ReadLine()
+	if err != nil {
+		return fmt.Errorf("greeting: %w", err)
+	}
+	if !strings.HasPrefix(greeting, "* OK") && !strings.HasPrefix(greeting, "* PREAUTH") {
+		return fmt.Errorf("greeting: unexpected reply %q", greeting)
+	}
+
+	if _, err := c.cmd("LOGIN %s %s", quote(m.server.User), quote(m.server.Password)); err != nil {
+		return fmt.Errorf("login: %w", err)
+	}
+	defer c.cmd("LOGOUT")
+
+	if _, err := c.cmd("SELECT %s", quote(m.folder)); err != nil {
+		return fmt.Errorf("select %s: %w", m.folder, err)
+	}
+
+	responses, err := c.cmd("UID SEARCH UNSEEN")
+	if err != nil {
+		return fmt.Errorf("search: %w", err)
+	}
+	var uids []string
+	for _, response := range responses {
+		if ids, ok := strings.CutPrefix(response.line, "* SEARCH"); ok {
+			uids = append(uids, strings.Fields(ids)...)
+		}
+	}
+
+	for _, uid := range uids {
+		// PEEK leaves the message unseen should processing fail
+		responses, err := c.cmd("UID FETCH %s BODY.PEEK[]", uid)
+		if err != nil {
+			return fmt.Errorf("fetch message %s: %w", uid, err)
+		}
+		var message []byte
+		for _, response := range responses {
+			if response.literal != nil {
+				message = response.literal
+			}
+		}
+		if message == nil {
+			return fmt.Errorf("fetch message %s: no message in the reply", uid)
+		}
+
+		if err := fn(bytes.NewReader(message)); err != nil {
+			return fmt.Errorf("message %s: %w", uid, err)
+		}
+
+		if _, err := c.cmd("UID STORE %s +FLAGS.SILENT (\\Seen)", uid); err != nil {
+			return fmt.Errorf("flag message %s: %w", uid, err)
+		}
+	}
+
+	return nil
+}
+
+type imapConn struct {
+	text *textproto.Conn
+	tag  int
+}
+
+// imapResponse is an untagged response line with the literal it carried, if any
+type imapResponse struct {
+	line    string
+	literal []byte
+}
+
+var literalPattern = regexp.MustCompile(`\{(\d+)\}$`)
+
+// cmd sends a tagged command and reads the untagged responses until its completion.
+// Only the last literal of a response is kept, which is enough for fetching one body.
+func (c *imapConn) cmd(format string, args ...any) ([]imapResponse, error) {
+	c.tag++
+	tag := "a" + strconv.Itoa(c.tag)
+	if err := c.text.PrintfLine(tag+" "+format, args...); err != nil {
+		return nil, err
+	}
+
+	var responses []imapResponse
+	for {
+		line, err := c.text.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if status, ok := strings.CutPrefix(line, tag+" "); ok {
+			if !strings.HasPrefix(status, "OK") {
+				return nil, errors.New(status)
+			}
+			return responses, nil
+		}
+
+		response := imapResponse{line: line}
+		for {
+			match := literalPattern.FindStringSubmatch(line)
+			if match == nil {
+				break
+			}
+			size, err := strconv.Atoi(match[1])
+			if err != nil {
+				return nil, fmt.Errorf("literal size: %w", err)
+			}
+			response.literal = make([]byte, size)
+			if _, err := io.ReadFull(c.text.R, response.literal); err != nil {
+				return nil, fmt.Errorf("read literal: %w", err)
+			}
+			// the response continues after the literal
+			if line, err = c.text.ReadLine(); err != nil {
+				return nil, err
+			}
+			response.line += line
+		}
+		responses = append(responses, response)
+	}
+}
+
+// quote formats s as an IMAP quoted string
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
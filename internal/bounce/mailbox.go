@@ -0,0 +1,107 @@
+package bounce
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Mailbox is a mailbox bounces are returned to, typically the envelope sender of the
+// notifications
+type Mailbox interface {
+	// Drain calls fn with every new message. Messages fn succeeds for are marked processed,
+	// draining stops at the first message it fails for, which is left for the next drain.
+	Drain(ctx context.Context, fn func(message io.Reader) error) error
+}
+
+// Server is a POP3 or IMAP server account
+type Server struct {
+	// Addr is the host:port of the server
+	Addr     string
+	User     string
+	Password string
+	// TLS connects with implicit TLS, as on ports 995 and 993
+	TLS     bool
+	Timeout time.Duration
+}
+
+// dial connects to the server. The connection is closed when ctx is done, which aborts
+// any exchange in progress.
+func (s Server) dial(ctx context.Context) (net.Conn, func(), error) {
+	dialer := &net.Dialer{Timeout: s.Timeout}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if s.TLS {
+		host, _, _ := net.SplitHostPort(s.Addr)
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", s.Addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", s.Addr)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("connect to %s: %w", s.Addr, err)
+	}
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	return conn, func() {
+		stop()
+		conn.Close()
+	}, nil
+}
+
+// Maildir reads the new messages of a Maildir, processed messages are moved to cur and
+// flagged as seen
+type Maildir struct {
+	path string
+}
+
+func NewMaildir(path string) *Maildir {
+	return &Maildir{path: path}
+}
+
+func (m *Maildir) Drain(ctx context.Context, fn func(message io.Reader) error) error {
+	entries, err := os.ReadDir(filepath.Join(m.path, "new"))
+	if err != nil {
+		return fmt.Errorf("read maildir: %w", err)
+	}
+	// names start with the delivery time, sorting keeps the order of arrival
+	slices.SortFunc(entries, func(a, b os.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		path := filepath.Join(m.path, "new", entry.Name())
+		if err := m.process(path, fn); err != nil {
+			return fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		if err := os.Rename(path, filepath.Join(m.path, "cur", entry.Name()+":2,S")); err != nil {
+			return fmt.Errorf("mark %s processed: %w", entry.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+func (m *Maildir) process(path string, fn func(message io.Reader) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return fn(file)
+}
@@ -0,0 +1,150 @@
+package bounce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// drain collects the messages of a mailbox, failing on the one containing failOn
+func drain(t *testing.T, mailbox Mailbox, failOn string) ([]string, error) {
+	var messages []string
+	err := mailbox.Drain(context.Background(), func(message io.Reader) error {
+		body, err := io.ReadAll(message)
+		require.NoError(t, err)
+		if failOn != "" && strings.Contains(string(body), failOn) {
+			return errors.New("storage is down")
+		}
+		messages = append(messages, string(body))
+		return nil
+	})
+	return messages, err
+}
+
+func TestMaildir(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"new", "cur", "tmp"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, sub), 0o700))
+	}
+	for n, body := range []string{"first", "second", "third"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "new", fmt.Sprintf("%d.host", n)), []byte(body), 0o600))
+	}
+	mailbox := NewMaildir(dir)
+
+	messages, err := drain(t, mailbox, "third")
+	require.Error(t, err)
+	require.Equal(t, []string{"first", "second"}, messages)
+
+	messages, err = drain(t, mailbox, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"third"}, messages, "processed messages are not read again")
+
+	processed, err := os.ReadDir(filepath.Join(dir, "cur"))
+	require.NoError(t, err)
+	require.Len(t, processed, 3)
+	require.Equal(t, "0.host:2,S", processed[0].Name())
+}
+
+// serve accepts one connection and answers every line read with reply, recording the lines
+func serve(t *testing.T, greeting string, reply func(line string) string) (string, func() []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	var (
+		mu    sync.Mutex
+		lines []string
+	)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		text := textproto.NewConn(conn)
+		text.PrintfLine("%s", greeting)
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			lines = append(lines, line)
+			mu.Unlock()
+			if _, err := io.WriteString(conn, reply(line)); err != nil {
+				return
+			}
+		}
+	}()
+
+	return listener.Addr().String(), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(lines)
+	}
+}
+
+func TestPOP3(t *testing.T) {
+	messages := []string{"Subject: first\r\n\r\n.hidden dot\r\n", "Subject: second\r\n\r\nbody\r\n"}
+	addr, lines := serve(t, "+OK ready", func(line string) string {
+		var n int
+		switch {
+		case line == "STAT":
+			return fmt.Sprintf("+OK %d 100\r\n", len(messages))
+		case strings.HasPrefix(line, "RETR"):
+			fmt.Sscanf(line, "RETR %d", &n)
+			return "+OK\r\n" + strings.ReplaceAll(messages[n-1], "\r\n.", "\r\n..") + ".\r\n"
+		default:
+			return "+OK\r\n"
+		}
+	})
+
+	got, err := drain(t, NewPOP3(Server{Addr: addr, User: "bounces", Password: "secret", Timeout: time.Second}), "second")
+	require.Error(t, err)
+	require.Equal(t, []string{"Subject: first\n\n.hidden dot\n"}, got, "dot stuffing is removed")
+
+	require.Eventually(t, func() bool { return slices.Contains(lines(), "QUIT") }, time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"USER bounces", "PASS secret", "STAT", "RETR 1", "DELE 1", "RETR 2", "QUIT"}, lines(),
+		"only processed messages are deleted")
+}
+
+func TestIMAP(t *testing.T) {
+	message := "Subject: bounce\r\n\r\nbody\r\n"
+	addr, lines := serve(t, "* OK IMAP4rev1 ready", func(line string) string {
+		tag, command, _ := strings.Cut(line, " ")
+		switch {
+		case command == "UID SEARCH UNSEEN":
+			return "* SEARCH 7\r\n" + tag + " OK done\r\n"
+		case command == "UID FETCH 7 BODY.PEEK[]":
+			return fmt.Sprintf("* 1 FETCH (UID 7 BODY[] {%d}\r\n%s)\r\n%s OK done\r\n", len(message), message, tag)
+		default:
+			return tag + " OK done\r\n"
+		}
+	})
+
+	got, err := drain(t, NewIMAP(Server{Addr: addr, User: "bounces", Password: `se"cret`, Timeout: time.Second}, ""), "")
+	require.NoError(t, err)
+	require.Equal(t, []string{message}, got)
+
+	require.Eventually(t, func() bool { return len(lines()) == 6 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{
+		`a1 LOGIN "bounces" "se\"cret"`,
+		`a2 SELECT "INBOX"`,
+		"a3 UID SEARCH UNSEEN",
+		"a4 UID FETCH 7 BODY.PEEK[]",
+		`a5 UID STORE 7 +FLAGS.SILENT (\Seen)`,
+		"a6 LOGOUT",
+	}, lines())
+}
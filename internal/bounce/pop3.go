@@ -0,0 +1,102 @@
+package bounce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// POP3 reads the messages of a POP3 mailbox. POP3 has no flags, processed messages
+// are deleted.
+type POP3 struct {
+	server Server
+}
+
+func NewPOP3(server Server) *POP3 {
+	return &POP3{server: server}
+}
+
+func (p *POP3) Drain(ctx context.Context, fn func(message io.Reader) error) (err error) {
+	conn, closeConn, err := p.server.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	c := &pop3Conn{text: textproto.NewConn(conn)}
+	if _, err := c.readStatus(); err != nil {
+		return fmt.Errorf("greeting: %w", err)
+	}
+	if _, err := c.cmd("USER %s", p.server.User); err != nil {
+		return fmt.Errorf("login: %w", err)
+	}
+	if _, err := c.cmd("PASS %s", p.server.Password); err != nil {
+		return fmt.Errorf("login: %w", err)
+	}
+	// deletions only take effect on QUIT, which must follow the messages processed so far
+	defer func() {
+		if _, quitErr := c.cmd("QUIT"); quitErr != nil && err == nil {
+			err = fmt.Errorf("quit: %w", quitErr)
+		}
+	}()
+
+	stat, err := c.cmd("STAT")
+	if err != nil {
+		return fmt.Errorf("stat: %w", err)
+	}
+	count, err := strconv.Atoi(strings.Fields(stat + " ")[0])
+	if err != nil {
+		return fmt.Errorf("stat: unexpected reply %q", stat)
+	}
+
+	for n := 1; n <= count; n++ {
+		if _, err := c.cmd("RETR %d", n); err != nil {
+			return fmt.Errorf("retrieve message %d: %w", n, err)
+		}
+		message := c.text.DotReader()
+		processErr := fn(message)
+		// the rest of the message must be read before the next command
+		if _, err := io.Copy(io.Discard, message); err != nil {
+			return fmt.Errorf("retrieve message %d: %w", n, err)
+		}
+		if processErr != nil {
+			return fmt.Errorf("message %d: %w", n, processErr)
+		}
+
+		if _, err := c.cmd("DELE %d", n); err != nil {
+			return fmt.Errorf("delete message %d: %w", n, err)
+		}
+	}
+
+	return nil
+}
+
+type pop3Conn struct {
+	text *textproto.Conn
+}
+
+// cmd sends a command and returns the text of its +OK reply
+func (c *pop3Conn) cmd(format string, args ...any) (string, error) {
+	if err := c.text.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+	return c.readStatus()
+}
+
+func (c *pop3Conn) readStatus() (string, error) {
+	line, err := c.text.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if rest, ok := strings.CutPrefix(line, "+OK"); ok {
+		return strings.TrimSpace(rest), nil
+	}
+	if rest, ok := strings.CutPrefix(line, "-ERR"); ok {
+		return "", errors.New(strings.TrimSpace(rest))
+	}
+	return "", fmt.Errorf("unexpected reply %q", line)
+}
@@ -0,0 +1,238 @@
+package bounce
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	UnknownPayload = errors.New("unknown webhook payload")
+)
+
+// Payload is a parsed webhook request
+type Payload struct {
+	Events []Event
+	// ConfirmURL is set for Amazon SNS subscription confirmations. SNS delivers no
+	// notifications until it has been visited.
+	ConfirmURL string
+}
+
+// ParseWebhook parses the bounce and complaint webhooks of Amazon SES (SNS notifications),
+// Mailgun, SendGrid and Postmark. Events of other kinds, e.g. deliveries or opens, are
+// skipped.
+func ParseWebhook(body []byte) (Payload, error) {
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		return parseSendGrid(trimmed)
+	}
+
+	var probe struct {
+		RecordType       string          `json:"RecordType"`
+		EventData        json.RawMessage `json:"event-data"`
+		SNSType          string          `json:"Type"`
+		NotificationType string          `json:"notificationType"`
+		EventType        string          `json:"eventType"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return Payload{}, fmt.Errorf("%w: %s", UnknownPayload, err)
+	}
+
+	switch {
+	case probe.RecordType != "":
+		return parsePostmark(body)
+	case probe.EventData != nil:
+		return parseMailgun(probe.EventData)
+	case probe.SNSType == "SubscriptionConfirmation" || probe.SNSType == "Notification":
+		return parseSNS(body)
+	case probe.NotificationType != "" || probe.EventType != "":
+		return parseSES(body)
+	}
+
+	return Payload{}, UnknownPayload
+}
+
+func parseSNS(body []byte) (Payload, error) {
+	var notification struct {
+		Type         string `json:"Type"`
+		Message      string `json:"Message"`
+		SubscribeURL string `json:"SubscribeURL"`
+	}
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return Payload{}, fmt.Errorf("%w: %s", UnknownPayload, err)
+	}
+
+	if notification.Type == "SubscriptionConfirmation" {
+		if notification.SubscribeURL == "" {
+			return Payload{}, fmt.Errorf("%w: subscription confirmation without a URL", UnknownPayload)
+		}
+		return Payload{ConfirmURL: notification.SubscribeURL}, nil
+	}
+
+	return parseSES([]byte(notification.Message))
+}
+
+type sesRecipient struct {
+	EmailAddress   string `json:"emailAddress"`
+	DiagnosticCode string `json:"diagnosticCode"`
+}
+
+// parseSES parses an SES notification, published with either notificationType or,
+// by configuration sets, eventType
+func parseSES(body []byte) (Payload, error) {
+	var notification struct {
+		NotificationType string `json:"notificationType"`
+		EventType        string `json:"eventType"`
+		Bounce           struct {
+			FeedbackID        string         `json:"feedbackId"`
+			BounceType        string         `json:"bounceType"`
+			BounceSubType     string         `json:"bounceSubType"`
+			BouncedRecipients []sesRecipient `json:"bouncedRecipients"`
+		} `json:"bounce"`
+		Complaint struct {
+			FeedbackID            string         `json:"feedbackId"`
+			ComplaintFeedbackType string         `json:"complaintFeedbackType"`
+			ComplainedRecipients  []sesRecipient `json:"complainedRecipients"`
+		} `json:"complaint"`
+	}
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return Payload{}, fmt.Errorf("%w: %s", UnknownPayload, err)
+	}
+
+	var payload Payload
+	switch notification.NotificationType + notification.EventType {
+	case "Bounce":
+		// Undetermined bounces are treated as temporary, they are not worth a suppression
+		bounceType := TypeSoft
+		if notification.Bounce.BounceType == "Permanent" {
+			bounceType = TypeHard
+		}
+		for i, recipient := range notification.Bounce.BouncedRecipients {
+			reason := recipient.DiagnosticCode
+			if reason == "" {
+				reason = notification.Bounce.BounceType + "/" + notification.Bounce.BounceSubType
+			}
+			payload.Events = append(payload.Events, Event{ID: eventID("ses", notification.Bounce.FeedbackID, i), Email: recipient.EmailAddress, Type: bounceType, Reason: reason})
+		}
+	case "Complaint":
+		for i, recipient := range notification.Complaint.ComplainedRecipients {
+			payload.Events = append(payload.Events, Event{ID: eventID("ses", notification.Complaint.FeedbackID, i), Email: recipient.EmailAddress, Type: TypeComplaint, Reason: notification.Complaint.ComplaintFeedbackType})
+		}
+	}
+
+	return payload, nil
+}
+
+func parseMailgun(eventData []byte) (Payload, error) {
+	var event struct {
+		ID             string `json:"id"`
+		Event          string `json:"event"`
+		Severity       string `json:"severity"`
+		Recipient      string `json:"recipient"`
+		Reason         string `json:"reason"`
+		DeliveryStatus struct {
+			Description string `json:"description"`
+			Message     string `json:"message"`
+		} `json:"delivery-status"`
+	}
+	if err := json.Unmarshal(eventData, &event); err != nil {
+		return Payload{}, fmt.Errorf("%w: %s", UnknownPayload, err)
+	}
+
+	var payload Payload
+	switch event.Event {
+	case "failed":
+		bounceType := TypeSoft
+		if event.Severity == "permanent" {
+			bounceType = TypeHard
+		}
+		reason := event.DeliveryStatus.Message
+		if reason == "" {
+			reason = event.DeliveryStatus.Description
+		}
+		if reason == "" {
+			reason = event.Reason
+		}
+		payload.Events = append(payload.Events, Event{ID: eventID("mailgun", event.ID, 0), Email: event.Recipient, Type: bounceType, Reason: reason})
+	case "complained":
+		payload.Events = append(payload.Events, Event{ID: eventID("mailgun", event.ID, 0), Email: event.Recipient, Type: TypeComplaint, Reason: "spam complaint"})
+	}
+
+	return payload, nil
+}
+
+func parseSendGrid(body []byte) (Payload, error) {
+	var events []struct {
+		ID     string `json:"sg_event_id"`
+		Email  string `json:"email"`
+		Event  string `json:"event"`
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(body, &events); err != nil {
+		return Payload{}, fmt.Errorf("%w: %s", UnknownPayload, err)
+	}
+
+	var payload Payload
+	for _, event := range events {
+		id := eventID("sendgrid", event.ID, 0)
+		switch event.Event {
+		case "bounce":
+			// blocks are refusals of the receiving server, e.g. for reputation, not dead addresses
+			bounceType := TypeHard
+			if event.Type == "blocked" {
+				bounceType = TypeSoft
+			}
+			payload.Events = append(payload.Events, Event{ID: id, Email: event.Email, Type: bounceType, Reason: event.Reason})
+		case "deferred":
+			payload.Events = append(payload.Events, Event{ID: id, Email: event.Email, Type: TypeSoft, Reason: event.Reason})
+		case "spamreport":
+			payload.Events = append(payload.Events, Event{ID: id, Email: event.Email, Type: TypeComplaint, Reason: "spam report"})
+		}
+	}
+
+	return payload, nil
+}
+
+func parsePostmark(body []byte) (Payload, error) {
+	var record struct {
+		ID          int64  `json:"ID"`
+		RecordType  string `json:"RecordType"`
+		Type        string `json:"Type"`
+		Email       string `json:"Email"`
+		Description string `json:"Description"`
+	}
+	if err := json.Unmarshal(body, &record); err != nil {
+		return Payload{}, fmt.Errorf("%w: %s", UnknownPayload, err)
+	}
+
+	var id string
+	if record.ID != 0 {
+		id = eventID("postmark", strconv.FormatInt(record.ID, 10), 0)
+	}
+
+	var payload Payload
+	switch {
+	case record.RecordType == "SpamComplaint" || record.Type == "SpamComplaint":
+		payload.Events = append(payload.Events, Event{ID: id, Email: record.Email, Type: TypeComplaint, Reason: "spam complaint"})
+	case record.RecordType == "Bounce":
+		bounceType := TypeSoft
+		if strings.EqualFold(record.Type, "HardBounce") || strings.EqualFold(record.Type, "BadEmailAddress") {
+			bounceType = TypeHard
+		}
+		payload.Events = append(payload.Events, Event{ID: id, Email: record.Email, Type: bounceType, Reason: record.Description})
+	}
+
+	return payload, nil
+}
+
+// eventID identifies the n-th event of a report that the provider identifies by id,
+// it is empty if the provider sent no id
+func eventID(provider, id string, n int) string {
+	if id == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%s/%d", provider, id, n)
+}
@@ -0,0 +1,77 @@
+package bounce
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseWebhook(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want Payload
+	}{
+		{
+			name: "ses bounce through sns",
+			body: `{"Type":"Notification","MessageId":"1","TopicArn":"arn:aws:sns:eu-west-1:1:bounces",` +
+				`"Message":"{\"notificationType\":\"Bounce\",\"bounce\":{\"feedbackId\":\"fb-1\",\"bounceType\":\"Permanent\",\"bounceSubType\":\"General\",` +
+				`\"bouncedRecipients\":[{\"emailAddress\":\"gone@example.com\",\"diagnosticCode\":\"smtp; 550 user unknown\"}]}}"}`,
+			want: Payload{Events: []Event{{ID: "ses:fb-1/0", Email: "gone@example.com", Type: TypeHard, Reason: "smtp; 550 user unknown"}}},
+		},
+		{
+			name: "ses complaint event",
+			body: `{"eventType":"Complaint","complaint":{"complaintFeedbackType":"abuse","complainedRecipients":[{"emailAddress":"annoyed@example.com"}]}}`,
+			want: Payload{Events: []Event{{Email: "annoyed@example.com", Type: TypeComplaint, Reason: "abuse"}}},
+		},
+		{
+			name: "sns subscription confirmation",
+			body: `{"Type":"SubscriptionConfirmation","SubscribeURL":"https://sns.eu-west-1.amazonaws.com/?Action=ConfirmSubscription"}`,
+			want: Payload{ConfirmURL: "https://sns.eu-west-1.amazonaws.com/?Action=ConfirmSubscription"},
+		},
+		{
+			name: "mailgun temporary failure",
+			body: `{"signature":{},"event-data":{"id":"mg-1","event":"failed","severity":"temporary","recipient":"full@example.com","delivery-status":{"message":"mailbox full"}}}`,
+			want: Payload{Events: []Event{{ID: "mailgun:mg-1/0", Email: "full@example.com", Type: TypeSoft, Reason: "mailbox full"}}},
+		},
+		{
+			name: "mailgun delivery",
+			body: `{"event-data":{"event":"delivered","recipient":"fine@example.com"}}`,
+			want: Payload{},
+		},
+		{
+			name: "sendgrid batch",
+			body: `[{"sg_event_id":"sg-1","email":"gone@example.com","event":"bounce","type":"bounce","reason":"550 user unknown"},` +
+				`{"email":"blocked@example.com","event":"bounce","type":"blocked","reason":"554 blocked"},` +
+				`{"email":"fine@example.com","event":"open"},` +
+				`{"email":"annoyed@example.com","event":"spamreport"}]`,
+			want: Payload{Events: []Event{
+				{ID: "sendgrid:sg-1/0", Email: "gone@example.com", Type: TypeHard, Reason: "550 user unknown"},
+				{Email: "blocked@example.com", Type: TypeSoft, Reason: "554 blocked"},
+				{Email: "annoyed@example.com", Type: TypeComplaint, Reason: "spam report"},
+			}},
+		},
+		{
+			name: "postmark hard bounce",
+			body: `{"ID":42,"RecordType":"Bounce","Type":"HardBounce","TypeCode":1,"Email":"gone@example.com","Description":"The server was unable to deliver your message"}`,
+			want: Payload{Events: []Event{{ID: "postmark:42/0", Email: "gone@example.com", Type: TypeHard, Reason: "The server was unable to deliver your message"}}},
+		},
+		{
+			name: "postmark spam complaint",
+			body: `{"RecordType":"SpamComplaint","Type":"SpamComplaint","Email":"annoyed@example.com"}`,
+			want: Payload{Events: []Event{{Email: "annoyed@example.com", Type: TypeComplaint, Reason: "spam complaint"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := ParseWebhook([]byte(tt.body))
+			require.NoError(t, err)
+			require.Equal(t, tt.want, payload)
+		})
+	}
+
+	for _, body := range []string{`{"hello":"world"}`, `not json`} {
+		_, err := ParseWebhook([]byte(body))
+		require.ErrorIs(t, err, UnknownPayload)
+	}
+}
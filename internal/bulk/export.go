@@ -0,0 +1,103 @@
+package bulk
+
+import (
+	"context"
+	"currency-rates-notifier/internal/storage"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+
+	exportPageSize = 1000
+)
+
+type SubscriptionLister interface {
+	ListSubscriptions(ctx context.Context, filter storage.SubscriptionFilter) ([]storage.Subscription, int, error)
+}
+
+// ExportedSubscription is the JSON representation of a subscription, matching the API
+type ExportedSubscription struct {
+	ID        int64     `json:"id"`
+	Email     string    `json:"email"`
+	Status    string    `json:"status"`
+	Pairs     []string  `json:"pairs"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ValidFormat reports whether Export supports the format
+func ValidFormat(format string) bool {
+	return format == FormatCSV || format == FormatJSON
+}
+
+// Export writes the subscriptions with the given status, or all of them when status is
+// empty, page by page. In CSV the pairs of a subscription are separated by spaces.
+func Export(ctx context.Context, lister SubscriptionLister, w io.Writer, format, status string) error {
+	var write func(storage.Subscription) error
+	var finish func() error
+
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"id", "email", "status", "pairs", "created_at"}); err != nil {
+			return err
+		}
+		write = func(s storage.Subscription) error {
+			return cw.Write([]string{strconv.FormatInt(s.ID, 10), s.Email, s.Status, strings.Join(s.Pairs, " "), s.CreatedAt.UTC().Format(time.RFC3339)})
+		}
+		finish = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case FormatJSON:
+		if _, err := io.WriteString(w, "["); err != nil {
+			return err
+		}
+		first := true
+		write = func(s storage.Subscription) error {
+			b, err := json.Marshal(ExportedSubscription{ID: s.ID, Email: s.Email, Status: s.Status, Pairs: s.Pairs, CreatedAt: s.CreatedAt})
+			if err != nil {
+				return err
+			}
+			separator := ",\n"
+			if first {
+				separator, first = "\n", false
+			}
+			if _, err := io.WriteString(w, separator); err != nil {
+				return err
+			}
+			_, err = w.Write(b)
+			return err
+		}
+		finish = func() error {
+			_, err := io.WriteString(w, "\n]\n")
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported export format %q", format)
+	}
+
+	for offset := 0; ; offset += exportPageSize {
+		subscriptions, _, err := lister.ListSubscriptions(ctx, storage.SubscriptionFilter{Status: status, Limit: exportPageSize, Offset: offset})
+		if err != nil {
+			return fmt.Errorf("list subscriptions: %w", err)
+		}
+		for _, subscription := range subscriptions {
+			if err := write(subscription); err != nil {
+				return err
+			}
+		}
+		if len(subscriptions) < exportPageSize {
+			break
+		}
+	}
+
+	return finish()
+}
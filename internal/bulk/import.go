@@ -0,0 +1,233 @@
+package bulk
+
+import (
+	"context"
+	"currency-rates-notifier/internal/api/monobank"
+	"currency-rates-notifier/internal/lib/currency"
+	"currency-rates-notifier/internal/storage"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+const (
+	importBatchSize = 500
+
+	RowInvalid    = "invalid"
+	RowDuplicate  = "duplicate"
+	RowExists     = "exists"
+	RowSuppressed = "suppressed"
+)
+
+var (
+	InvalidCSV = errors.New("invalid CSV")
+)
+
+type SubscriptionImporter interface {
+	ImportSubscriptions(ctx context.Context, subscriptions []storage.Subscription, dryRun bool) ([]error, error)
+}
+
+type EmailNormalizer interface {
+	Normalize(ctx context.Context, email string) (string, error)
+}
+
+// ImportOptions apply to a whole import. Status is given to every imported subscription.
+type ImportOptions struct {
+	Status string
+	DryRun bool
+}
+
+// RowResult describes a row that was not imported. Line is the line of the row in the file.
+type RowResult struct {
+	Line   int      `json:"line"`
+	Email  string   `json:"email"`
+	Result string   `json:"result"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// ImportReport counts rows by outcome. Rows lists every row that was not imported:
+// invalid ones, duplicates of an earlier row, addresses that are already subscribed and
+// suppressed addresses, e.g. of subscribers who had their data erased.
+type ImportReport struct {
+	DryRun     bool        `json:"dry_run"`
+	Total      int         `json:"total"`
+	Imported   int         `json:"imported"`
+	Invalid    int         `json:"invalid"`
+	Duplicates int         `json:"duplicates"`
+	Existing   int         `json:"existing"`
+	Suppressed int         `json:"suppressed"`
+	Rows       []RowResult `json:"rows"`
+}
+
+// Importer reads subscribers from CSV with a header row. The email column is required,
+// an optional pairs column holds pairs separated by spaces, commas or semicolons.
+// Other columns are ignored, so files produced by Export can be imported back.
+type Importer struct {
+	store      SubscriptionImporter
+	normalizer EmailNormalizer
+}
+
+func NewImporter(store SubscriptionImporter, normalizer EmailNormalizer) *Importer {
+	return &Importer{store: store, normalizer: normalizer}
+}
+
+type pendingRow struct {
+	line         int
+	subscription storage.Subscription
+}
+
+// Import streams the CSV from r and stores valid rows in batches. An error is only
+// returned when the file cannot be read at all or storing a batch fails; in the latter
+// case the batches before it have been imported.
+func (i *Importer) Import(ctx context.Context, r io.Reader, opts ImportOptions) (ImportReport, error) {
+	report := ImportReport{DryRun: opts.DryRun, Rows: []RowResult{}}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return report, fmt.Errorf("%w: the file is empty", InvalidCSV)
+	}
+	if err != nil {
+		return report, fmt.Errorf("%w: %w", InvalidCSV, err)
+	}
+	emailColumn, pairsColumn := -1, -1
+	for n, name := range header {
+		switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))) {
+		case "email":
+			emailColumn = n
+		case "pairs":
+			pairsColumn = n
+		}
+	}
+	if emailColumn < 0 {
+		return report, fmt.Errorf("%w: the header has no email column", InvalidCSV)
+	}
+
+	seen := make(map[string]struct{})
+	batch := make([]pendingRow, 0, importBatchSize)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return report, fmt.Errorf("%w: %w", InvalidCSV, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		report.Total++
+
+		row := RowResult{Line: line, Email: field(record, emailColumn)}
+		subscription, errs := i.parseRow(ctx, row.Email, field(record, pairsColumn), opts.Status)
+		if len(errs) > 0 {
+			row.Result, row.Errors = RowInvalid, errs
+			report.Invalid++
+			report.Rows = append(report.Rows, row)
+			continue
+		}
+
+		key := strings.ToLower(subscription.Email)
+		if _, ok := seen[key]; ok {
+			row.Result = RowDuplicate
+			report.Duplicates++
+			report.Rows = append(report.Rows, row)
+			continue
+		}
+		seen[key] = struct{}{}
+
+		batch = append(batch, pendingRow{line: line, subscription: subscription})
+		if len(batch) == importBatchSize {
+			if err := i.flush(ctx, batch, opts.DryRun, &report); err != nil {
+				return report, err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if err := i.flush(ctx, batch, opts.DryRun, &report); err != nil {
+		return report, err
+	}
+
+	// rows of existing addresses are only known once their batch is stored
+	slices.SortFunc(report.Rows, func(a, b RowResult) int { return a.Line - b.Line })
+
+	return report, nil
+}
+
+func (i *Importer) parseRow(ctx context.Context, rawEmail, rawPairs, status string) (storage.Subscription, []string) {
+	var errs []string
+
+	email, err := i.normalizer.Normalize(ctx, rawEmail)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("email: %s", err))
+	}
+
+	pairs := []string{monobank.USDToUAH.String()}
+	if values := strings.FieldsFunc(rawPairs, isPairSeparator); len(values) > 0 {
+		pairs = pairs[:0]
+		for _, value := range values {
+			pair, err := currency.ParsePair(value)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("pairs: %s", err))
+				continue
+			}
+			if !slices.Contains(pairs, pair.String()) {
+				pairs = append(pairs, pair.String())
+			}
+		}
+	}
+
+	return storage.Subscription{Email: email, Status: status, Pairs: pairs}, errs
+}
+
+func (i *Importer) flush(ctx context.Context, batch []pendingRow, dryRun bool, report *ImportReport) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	subscriptions := make([]storage.Subscription, len(batch))
+	for n, row := range batch {
+		subscriptions[n] = row.subscription
+	}
+
+	results, err := i.store.ImportSubscriptions(ctx, subscriptions, dryRun)
+	if err != nil {
+		return fmt.Errorf("import rows from line %d: %w", batch[0].line, err)
+	}
+
+	for n, err := range results {
+		if errors.Is(err, storage.EmailSuppressed) {
+			report.Suppressed++
+			report.Rows = append(report.Rows, RowResult{Line: batch[n].line, Email: batch[n].subscription.Email, Result: RowSuppressed})
+			continue
+		}
+		if errors.Is(err, storage.EmailExists) {
+			report.Existing++
+			report.Rows = append(report.Rows, RowResult{Line: batch[n].line, Email: batch[n].subscription.Email, Result: RowExists})
+			continue
+		}
+		report.Imported++
+	}
+
+	return nil
+}
+
+func field(record []string, column int) string {
+	if column < 0 || column >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[column])
+}
+
+func isPairSeparator(r rune) bool {
+	return r == ',' || r == ';' || r == ' ' || r == '\t'
+}
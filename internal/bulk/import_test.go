@@ -0,0 +1,128 @@
+package bulk
+
+import (
+	"context"
+	"currency-rates-notifier/internal/storage"
+	"currency-rates-notifier/internal/storage/memory"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// batchRecorder stores into memory and records the size of every batch
+type batchRecorder struct {
+	*memory.Storage
+	batches []int
+}
+
+func (s *batchRecorder) ImportSubscriptions(ctx context.Context, subscriptions []storage.Subscription, dryRun bool) ([]error, error) {
+	s.batches = append(s.batches, len(subscriptions))
+	return s.Storage.ImportSubscriptions(ctx, subscriptions, dryRun)
+}
+
+type lowercaseNormalizer struct{}
+
+func (lowercaseNormalizer) Normalize(_ context.Context, email string) (string, error) {
+	if !strings.Contains(email, "@") {
+		return "", errors.New("not an address")
+	}
+	return strings.ToLower(email), nil
+}
+
+func newImporter(t *testing.T) (*Importer, *batchRecorder) {
+	store := &batchRecorder{Storage: memory.New()}
+	_, err := store.SaveSubscription(context.Background(), storage.Subscription{Email: "existing@example.com", Status: storage.StatusActive, Pairs: []string{"USD/UAH"}})
+	require.NoError(t, err)
+	return NewImporter(store, lowercaseNormalizer{}), store
+}
+
+func TestImport(t *testing.T) {
+	importer, store := newImporter(t)
+
+	// the BOM Excel puts in front of the header must not hide the email column
+	file := "\ufeffname,Email,pairs\n" +
+		"a,user@example.com,EUR/UAH USD/UAH\n" +
+		"b,nope,\n" +
+		"c,USER@example.com,\n" +
+		"d,existing@example.com,\n" +
+		"e,other@example.com,XXX/UAH\n"
+
+	report, err := importer.Import(context.Background(), strings.NewReader(file), ImportOptions{Status: storage.StatusActive})
+	require.NoError(t, err)
+	require.Equal(t, ImportReport{
+		Total: 5, Imported: 1, Invalid: 2, Duplicates: 1, Existing: 1,
+		Rows: []RowResult{
+			{Line: 3, Email: "nope", Result: RowInvalid, Errors: []string{"email: not an address"}},
+			{Line: 4, Email: "USER@example.com", Result: RowDuplicate},
+			{Line: 5, Email: "existing@example.com", Result: RowExists},
+			{Line: 6, Email: "other@example.com", Result: RowInvalid, Errors: []string{`pairs: unknown currency code: "XXX"`}},
+		},
+	}, report)
+	require.Equal(t, []int{2}, store.batches)
+
+	subscription, err := store.GetSubscriptionByEmail(context.Background(), "user@example.com")
+	require.NoError(t, err)
+	require.Equal(t, []string{"EUR/UAH", "USD/UAH"}, subscription.Pairs)
+}
+
+func TestImportBatches(t *testing.T) {
+	for _, dryRun := range []bool{false, true} {
+		t.Run(fmt.Sprintf("dry run %t", dryRun), func(t *testing.T) {
+			importer, store := newImporter(t)
+
+			var file strings.Builder
+			file.WriteString("email\n")
+			for n := range importBatchSize + 1 {
+				fmt.Fprintf(&file, "user%d@example.com\n", n)
+			}
+			file.WriteString("existing@example.com\n")
+
+			report, err := importer.Import(context.Background(), strings.NewReader(file.String()), ImportOptions{Status: storage.StatusActive, DryRun: dryRun})
+			require.NoError(t, err)
+			require.Equal(t, dryRun, report.DryRun)
+			require.Equal(t, importBatchSize+2, report.Total)
+			require.Equal(t, importBatchSize+1, report.Imported)
+			require.Equal(t, []RowResult{{Line: importBatchSize + 3, Email: "existing@example.com", Result: RowExists}}, report.Rows,
+				"the line of a row in a later batch counts the rows of the earlier ones")
+			require.Equal(t, []int{importBatchSize, 2}, store.batches)
+
+			_, err = store.GetSubscriptionByEmail(context.Background(), fmt.Sprintf("user%d@example.com", importBatchSize))
+			if dryRun {
+				require.ErrorIs(t, err, storage.SubscriptionNotFound, "a dry run stores nothing")
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestImportInvalidFile(t *testing.T) {
+	importer, _ := newImporter(t)
+
+	_, err := importer.Import(context.Background(), strings.NewReader(""), ImportOptions{})
+	require.ErrorIs(t, err, InvalidCSV)
+	_, err = importer.Import(context.Background(), strings.NewReader("name,pairs\na,USD/UAH\n"), ImportOptions{})
+	require.ErrorIs(t, err, InvalidCSV)
+	_, err = importer.Import(context.Background(), strings.NewReader("email\n\"user@example.com\n"), ImportOptions{})
+	require.ErrorIs(t, err, InvalidCSV)
+}
+
+func TestImportTooLargeFile(t *testing.T) {
+	importer, _ := newImporter(t)
+
+	var file strings.Builder
+	file.WriteString("email\n")
+	for n := range 100 {
+		fmt.Fprintf(&file, "user%d@example.com\n", n)
+	}
+	body := http.MaxBytesReader(httptest.NewRecorder(), io.NopCloser(strings.NewReader(file.String())), 64)
+
+	_, err := importer.Import(context.Background(), body, ImportOptions{Status: storage.StatusActive})
+	var maxBytesErr *http.MaxBytesError
+	require.ErrorAs(t, err, &maxBytesErr, "the handler answers 413 for it")
+}
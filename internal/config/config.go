@@ -1,21 +1,66 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"github.com/ilyakaznacheev/cleanenv"
-	"log"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	Env        string `yaml:"env" env-default:"local"`
+	Env        string `yaml:"env" env:"APP_ENV" env-default:"local"`
 	HTTPServer `yaml:"server"`
-	Monobank   Monobank `yaml:"monobank"`
-	Email      Email    `yaml:"email"`
+	Monobank   Monobank  `yaml:"monobank"`
+	Email      Email     `yaml:"email"`
+	Poller     Poller    `yaml:"poller"`
+	Stream     Stream    `yaml:"stream"`
+	WebSocket  WebSocket `yaml:"websocket"`
+	Channels   Channels  `yaml:"channels"`
+	Subscribe  Subscribe `yaml:"subscribe"`
+	Admin      Admin     `yaml:"admin"`
+	Storage    Storage   `yaml:"storage"`
+	Privacy    Privacy   `yaml:"privacy"`
+	Bounces    Bounces   `yaml:"bounces"`
+	Retention  Retention `yaml:"retention"`
+}
+
+type Storage struct {
+	// Driver is "sqlite", "postgres" or "memory". For SQLite DSN is the path of the database
+	// file, for PostgreSQL a postgres:// URL or a key=value connection string. The memory
+	// driver keeps everything in the process and ignores DSN.
+	Driver string `yaml:"driver" env:"STORAGE_DRIVER" env-default:"sqlite"`
+	DSN    string `yaml:"dsn" env:"STORAGE_DSN" env-default:"./storage.db" secret:"true"`
+	// ManualMigrations stops the server from migrating the schema at startup, it then
+	// refuses to start until "api-server migrate up" has been run
+	ManualMigrations bool `yaml:"manualMigrations" env:"STORAGE_MANUAL_MIGRATIONS"`
+	// SuppressionKey keys the hashes that identify suppressed and bouncing addresses, e.g.
+	// from "openssl rand -base64 32". Changing it forgets every suppression.
+	SuppressionKey string     `yaml:"suppressionKey" env:"STORAGE_SUPPRESSION_KEY" secret:"true"`
+	Encryption     Encryption `yaml:"encryption"`
+}
+
+// Encryption of subscriber addresses at rest, disabled while CurrentKey is empty. Keys are
+// base64 encoded 32 byte AES keys by id, e.g. from "openssl rand -base64 32". Addresses are
+// encrypted with CurrentKey, the other keys decrypt addresses until "api-server reencrypt"
+// has re-encrypted them. IndexKey keys the blind index that keeps addresses unique and
+// searchable; changing it also requires "api-server reencrypt".
+type Encryption struct {
+	CurrentKey string            `yaml:"currentKey" env:"EMAIL_ENCRYPTION_CURRENT_KEY"`
+	Keys       map[string]string `yaml:"keys" env:"EMAIL_ENCRYPTION_KEYS" secret:"true"`
+	// KeysFile holds more keys, one id=key pair per line
+	KeysFile string `yaml:"keysFile" env:"EMAIL_ENCRYPTION_KEYS_FILE"`
+	IndexKey string `yaml:"indexKey" env:"EMAIL_ENCRYPTION_INDEX_KEY" secret:"true"`
 }
 
 type HTTPServer struct {
-	Host string `yaml:"host"`
-	Port string `yaml:"port" env-default:"8080"`
+	Host            string        `yaml:"host" env:"SERVER_HOST"`
+	Port            string        `yaml:"port" env:"SERVER_PORT" env-default:"8080"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" env:"SERVER_SHUTDOWN_TIMEOUT" env-default:"30s"`
+	TrustedProxies  []string      `yaml:"trustedProxies" env:"SERVER_TRUSTED_PROXIES"`
 }
 
 type Monobank struct {
@@ -23,33 +68,308 @@ type Monobank struct {
 }
 
 type API struct {
-	URL string `yaml:"url"`
+	URL            string        `yaml:"url" env:"MONOBANK_API_URL"`
+	Timeout        time.Duration `yaml:"timeout" env:"MONOBANK_API_TIMEOUT" env-default:"10s"`
+	MaxRetries     int           `yaml:"maxRetries" env:"MONOBANK_API_MAX_RETRIES" env-default:"3"`
+	RetryBaseDelay time.Duration `yaml:"retryBaseDelay" env:"MONOBANK_API_RETRY_BASE_DELAY" env-default:"500ms"`
+	RetryMaxDelay  time.Duration `yaml:"retryMaxDelay" env:"MONOBANK_API_RETRY_MAX_DELAY" env-default:"30s"`
+}
+
+type Poller struct {
+	Interval    time.Duration `yaml:"interval" env:"POLLER_INTERVAL" env-default:"1m"`
+	HistorySize int           `yaml:"historySize" env:"POLLER_HISTORY_SIZE" env-default:"1000"`
+}
+
+type Stream struct {
+	HeartbeatInterval time.Duration `yaml:"heartbeatInterval" env:"STREAM_HEARTBEAT_INTERVAL" env-default:"15s"`
+}
+
+type WebSocket struct {
+	SendBufferSize int           `yaml:"sendBufferSize" env:"WEBSOCKET_SEND_BUFFER_SIZE" env-default:"32"`
+	WriteTimeout   time.Duration `yaml:"writeTimeout" env:"WEBSOCKET_WRITE_TIMEOUT" env-default:"10s"`
+	OriginPatterns []string      `yaml:"originPatterns" env:"WEBSOCKET_ORIGIN_PATTERNS"`
+}
+
+type Channels struct {
+	Slack Webhook `yaml:"slack" env-prefix:"SLACK_"`
+	Teams Webhook `yaml:"teams" env-prefix:"TEAMS_"`
+}
+
+// Webhook is an incoming webhook channel, disabled when URL is empty.
+type Webhook struct {
+	URL string `yaml:"webhookURL" env:"WEBHOOK_URL" secret:"true"`
+}
+
+type Subscribe struct {
+	// RevealExisting answers 201/409 instead of a uniform 202, which discloses whether an address is subscribed
+	RevealExisting bool         `yaml:"revealExisting" env:"SUBSCRIBE_REVEAL_EXISTING"`
+	RateLimit      RateLimit    `yaml:"rateLimit"`
+	Verification   Verification `yaml:"verification"`
+}
+
+type RateLimit struct {
+	RequestsPerMinute float64 `yaml:"requestsPerMinute" env:"SUBSCRIBE_RATE_LIMIT_REQUESTS_PER_MINUTE" env-default:"5"`
+	Burst             int     `yaml:"burst" env:"SUBSCRIBE_RATE_LIMIT_BURST" env-default:"5"`
+}
+
+// Verification selects the subscribe verifier: "" (none), "turnstile", "hcaptcha" or "pow".
+// Secret is the captcha secret key or the proof-of-work signing key.
+type Verification struct {
+	Provider     string        `yaml:"provider" env:"SUBSCRIBE_VERIFICATION_PROVIDER"`
+	Secret       string        `yaml:"secret" env:"SUBSCRIBE_VERIFICATION_SECRET" secret:"true"`
+	VerifyURL    string        `yaml:"verifyURL" env:"SUBSCRIBE_VERIFICATION_VERIFY_URL"`
+	Difficulty   int           `yaml:"difficulty" env:"SUBSCRIBE_VERIFICATION_DIFFICULTY" env-default:"20"`
+	ChallengeTTL time.Duration `yaml:"challengeTTL" env:"SUBSCRIBE_VERIFICATION_CHALLENGE_TTL" env-default:"5m"`
+}
+
+// Privacy configures the data export and erasure endpoints, which are disabled while
+// Secret is empty. Secret signs the mailed links and BaseURL is the public URL of the
+// server the links point to.
+type Privacy struct {
+	Secret  string        `yaml:"secret" env:"PRIVACY_SECRET" secret:"true"`
+	LinkTTL time.Duration `yaml:"linkTTL" env:"PRIVACY_LINK_TTL" env-default:"1h"`
+	BaseURL string        `yaml:"baseURL" env:"PRIVACY_BASE_URL"`
+}
+
+// Bounces configures bounce and complaint processing. An address is suppressed on its
+// first complaint or its Threshold-th hard bounce. The webhook is disabled while
+// WebhookToken is empty.
+type Bounces struct {
+	Threshold    int           `yaml:"threshold" env:"BOUNCE_THRESHOLD" env-default:"3"`
+	WebhookToken string        `yaml:"webhookToken" env:"BOUNCE_WEBHOOK_TOKEN" secret:"true"`
+	Mailbox      BounceMailbox `yaml:"mailbox"`
+}
+
+// BounceMailbox is the mailbox bounces are returned to. Protocol is "" (none), "imap",
+// "pop3" or "maildir". Address is the host:port of the server or the Maildir path.
+type BounceMailbox struct {
+	Protocol string `yaml:"protocol" env:"BOUNCE_MAILBOX_PROTOCOL"`
+	Address  string `yaml:"address" env:"BOUNCE_MAILBOX_ADDRESS"`
+	User     string `yaml:"user" env:"BOUNCE_MAILBOX_USER"`
+	Password string `yaml:"password" env:"BOUNCE_MAILBOX_PASSWORD" secret:"true"`
+	// TLS is on unless set to false, e.g. for a local test server
+	TLS      OptionalBool  `yaml:"tls" env:"BOUNCE_MAILBOX_TLS"`
+	Folder   string        `yaml:"folder" env:"BOUNCE_MAILBOX_FOLDER" env-default:"INBOX"`
+	Interval time.Duration `yaml:"interval" env:"BOUNCE_MAILBOX_INTERVAL" env-default:"5m"`
+	Timeout  time.Duration `yaml:"timeout" env:"BOUNCE_MAILBOX_TIMEOUT" env-default:"30s"`
+}
+
+// Retention configures the pruning of old records, which runs on Schedule, a cron
+// expression. A period of zero keeps the records forever. Rate history is not stored,
+// the poller only keeps the last poller.historySize changes in memory.
+type Retention struct {
+	Schedule string `yaml:"schedule" env:"RETENTION_SCHEDULE" env-default:"0 3 * * *"`
+	// Deliveries is how long the delivery log is kept
+	Deliveries time.Duration `yaml:"deliveries" env:"RETENTION_DELIVERIES" env-default:"2160h"`
+	// Bounces is how long hard bounces count towards a suppression after the last one
+	Bounces time.Duration `yaml:"bounces" env:"RETENTION_BOUNCES" env-default:"4320h"`
+}
+
+// Admin configures access to the admin API. Tokens are accepted in addition to the
+// API keys stored in the database and are typically used to create the first of them.
+type Admin struct {
+	Tokens AdminTokens `yaml:"tokens" env:"ADMIN_TOKENS" secret:"true"`
+}
+
+// AdminToken is a bearer token with either the "read" or the "write" scope
+type AdminToken struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+	Scope string `yaml:"scope"`
 }
 
 type Email struct {
-	Host            string `yaml:"host"`
-	User            string `yaml:"user"`
-	Password        string `yaml:"password"`
-	EnvelopeFrom    string `yaml:"envelopeFrom"`
-	From            string `yaml:"from"`
-	Subject         string `yaml:"subject"`
-	MessageTemplate string `yaml:"messageTemplate"`
+	Host            string `yaml:"host" env:"EMAIL_HOST"`
+	User            string `yaml:"user" env:"EMAIL_USER"`
+	Password        string `yaml:"password" env:"EMAIL_PASSWORD" secret:"true"`
+	EnvelopeFrom    string `yaml:"envelopeFrom" env:"EMAIL_ENVELOPE_FROM"`
+	From            string `yaml:"from" env:"EMAIL_FROM"`
+	Subject         string `yaml:"subject" env:"EMAIL_SUBJECT"`
+	MessageTemplate string `yaml:"messageTemplate" env:"EMAIL_MESSAGE_TEMPLATE"`
+	// Schedule is the cron expression of the mailing
+	Schedule   string          `yaml:"schedule" env:"EMAIL_SCHEDULE" env-default:"0 1 * * *"`
+	Validation EmailValidation `yaml:"validation"`
 }
 
-func ReadConfig(configPath string) *Config {
-	if configPath == "" {
-		log.Fatal("configPath is not set")
+type EmailValidation struct {
+	CheckMX        bool     `yaml:"checkMX" env:"EMAIL_VALIDATION_CHECK_MX"`
+	BlockedDomains []string `yaml:"blockedDomains" env:"EMAIL_VALIDATION_BLOCKED_DOMAINS"`
+	BlocklistFile  string   `yaml:"blocklistFile" env:"EMAIL_VALIDATION_BLOCKLIST_FILE"`
+}
+
+// AdminTokens are read from the environment as comma separated name:scope:token triples
+type AdminTokens []AdminToken
+
+func (t *AdminTokens) SetValue(value string) error {
+	tokens := AdminTokens{}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		name, rest, _ := strings.Cut(field, ":")
+		scope, token, ok := strings.Cut(rest, ":")
+		if !ok || name == "" || token == "" {
+			return fmt.Errorf("admin token %q is not name:scope:token", name)
+		}
+		tokens = append(tokens, AdminToken{Name: name, Token: token, Scope: scope})
 	}
+	*t = tokens
+	return nil
+}
 
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		log.Fatalf("config file does not exist: %s", configPath)
+// OptionalBool tells an unset bool from false, so a setting that is on by default can be
+// turned off in the file; env-default only applies to zero values
+type OptionalBool int8
+
+func (b *OptionalBool) SetValue(value string) error {
+	return b.UnmarshalText([]byte(value))
+}
+
+func (b *OptionalBool) UnmarshalText(text []byte) error {
+	value, err := strconv.ParseBool(string(text))
+	if err != nil {
+		return err
+	}
+	*b = -1
+	if value {
+		*b = 1
 	}
+	return nil
+}
 
+// Or returns the value, or def when it is not set
+func (b OptionalBool) Or(def bool) bool {
+	if b == 0 {
+		return def
+	}
+	return b > 0
+}
+
+func (b OptionalBool) String() string {
+	if b == 0 {
+		return "unset"
+	}
+	return strconv.FormatBool(b > 0)
+}
+
+// ReadConfig reads the config file at configPath, or only the environment when configPath
+// is empty. Environment variables override the file. Every variable can also be read
+// from a file named by the variable with a _FILE suffix, e.g. EMAIL_PASSWORD_FILE or
+// ADMIN_TOKENS_FILE, for secrets mounted into a container. EMAIL_ENCRYPTION_KEYS_FILE is
+// the keysFile setting instead, which holds one id=key pair per line.
+func ReadConfig(configPath string) (*Config, error) {
 	var cfg Config
 
-	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
-		log.Fatalf("cannot read config: %s", err)
+	if configPath == "" {
+		if err := cleanenv.ReadEnv(&cfg); err != nil {
+			return nil, fmt.Errorf("cannot read config from the environment: %w", err)
+		}
+	} else {
+		if _, err := os.Stat(configPath); err != nil {
+			return nil, fmt.Errorf("cannot read config: %w", err)
+		}
+		if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
+			return nil, fmt.Errorf("cannot read config %s: %w", configPath, err)
+		}
+	}
+
+	v := reflect.ValueOf(&cfg).Elem()
+	names := make(map[string]struct{})
+	envNames(v, "", names)
+	if err := readSecretFiles(v, "", names); err != nil {
+		return nil, fmt.Errorf("cannot read config: %w", err)
 	}
 
-	return &cfg
+	return &cfg, nil
+}
+
+// envNames collects the variables of the fields, following the env and env-prefix tags
+// the way cleanenv does
+func envNames(v reflect.Value, prefix string, names map[string]struct{}) {
+	for i := range v.NumField() {
+		field, structField := v.Field(i), v.Type().Field(i)
+		if field.Kind() == reflect.Struct && !isSetter(field) {
+			envNames(field, prefix+structField.Tag.Get("env-prefix"), names)
+			continue
+		}
+		for _, name := range strings.Split(structField.Tag.Get("env"), ",") {
+			if name != "" {
+				names[prefix+name] = struct{}{}
+			}
+		}
+	}
+}
+
+// readSecretFiles sets the fields whose variable has a _FILE counterpart, unless that is
+// a variable of its own
+func readSecretFiles(v reflect.Value, prefix string, names map[string]struct{}) error {
+	for i := range v.NumField() {
+		field, structField := v.Field(i), v.Type().Field(i)
+
+		if field.Kind() == reflect.Struct && !isSetter(field) {
+			if err := readSecretFiles(field, prefix+structField.Tag.Get("env-prefix"), names); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, name := range strings.Split(structField.Tag.Get("env"), ",") {
+			if name == "" {
+				continue
+			}
+			name = prefix + name
+			if _, ok := names[name+"_FILE"]; ok {
+				continue
+			}
+			path, ok := os.LookupEnv(name + "_FILE")
+			if !ok {
+				continue
+			}
+			if _, ok := os.LookupEnv(name); ok {
+				return fmt.Errorf("both %s and %s_FILE are set", name, name)
+			}
+			secret, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("%s_FILE: %w", name, err)
+			}
+			if err := setValue(field, strings.TrimRight(string(secret), "\r\n")); err != nil {
+				return fmt.Errorf("%s_FILE: %w", name, err)
+			}
+			break
+		}
+	}
+
+	return nil
+}
+
+func isSetter(field reflect.Value) bool {
+	_, ok := field.Addr().Interface().(cleanenv.Setter)
+	return ok
+}
+
+// setValue parses a value the way cleanenv parses the variable of the field. Only the
+// kinds secrets have are supported.
+func setValue(field reflect.Value, value string) error {
+	if setter, ok := field.Addr().Interface().(cleanenv.Setter); ok {
+		return setter.SetValue(value)
+	}
+
+	switch {
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Map && field.Type().Key().Kind() == reflect.String && field.Type().Elem().Kind() == reflect.String:
+		m := reflect.MakeMap(field.Type())
+		for _, pair := range strings.Split(value, ",") {
+			key, val, ok := strings.Cut(strings.TrimSpace(pair), ":")
+			if !ok {
+				return fmt.Errorf("%q is not a key:value pair", pair)
+			}
+			m.SetMapIndex(reflect.ValueOf(key).Convert(field.Type().Key()), reflect.ValueOf(val).Convert(field.Type().Elem()))
+		}
+		field.Set(m)
+	default:
+		return errors.New("cannot be read from a file")
+	}
+	return nil
 }
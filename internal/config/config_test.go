@@ -0,0 +1,80 @@
+package config
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte("email:\n  host: smtp.file.com\n  password: from-file\nstorage:\n  dsn: file.db\n"), 0o600))
+	secretPath := filepath.Join(dir, "password")
+	require.NoError(t, os.WriteFile(secretPath, []byte("mounted secret\n"), 0o600))
+
+	t.Setenv("STORAGE_DSN", "env.db")
+	t.Setenv("EMAIL_PASSWORD_FILE", secretPath)
+	t.Setenv("SLACK_WEBHOOK_URL", "https://hooks.slack.com/services/x")
+	t.Setenv("ADMIN_TOKENS", "ops:write:s3cret, ci:read:t0ken")
+	t.Setenv("EMAIL_ENCRYPTION_KEYS", "k1:a2V5,k2:b3RoZXI=")
+	t.Setenv("EMAIL_ENCRYPTION_KEYS_FILE", "/run/secrets/keys")
+
+	cfg, err := ReadConfig(configPath)
+	require.NoError(t, err)
+	require.Equal(t, "smtp.file.com", cfg.Email.Host)
+	require.Equal(t, "env.db", cfg.Storage.DSN, "the environment overrides the file")
+	require.Equal(t, "mounted secret", cfg.Email.Password)
+	require.Equal(t, "https://hooks.slack.com/services/x", cfg.Channels.Slack.URL)
+	require.Empty(t, cfg.Channels.Teams.URL)
+	require.Equal(t, AdminTokens{{Name: "ops", Scope: "write", Token: "s3cret"}, {Name: "ci", Scope: "read", Token: "t0ken"}}, cfg.Admin.Tokens)
+	require.Equal(t, map[string]string{"k1": "a2V5", "k2": "b3RoZXI="}, cfg.Storage.Encryption.Keys)
+	require.Equal(t, "/run/secrets/keys", cfg.Storage.Encryption.KeysFile, "the variable is a setting of its own")
+	require.Equal(t, "8080", cfg.Port)
+
+	cfg, err = ReadConfig("")
+	require.NoError(t, err)
+	require.Equal(t, "env.db", cfg.Storage.DSN)
+	require.Equal(t, "mounted secret", cfg.Email.Password)
+
+	t.Setenv("EMAIL_PASSWORD", "plain")
+	_, err = ReadConfig("")
+	require.ErrorContains(t, err, "both EMAIL_PASSWORD and EMAIL_PASSWORD_FILE are set")
+
+	tokensPath := filepath.Join(dir, "tokens")
+	require.NoError(t, os.WriteFile(tokensPath, []byte("ops:write:from-file\n"), 0o600))
+	os.Unsetenv("ADMIN_TOKENS")
+	os.Unsetenv("EMAIL_PASSWORD_FILE")
+	t.Setenv("ADMIN_TOKENS_FILE", tokensPath)
+	cfg, err = ReadConfig("")
+	require.NoError(t, err)
+	require.Equal(t, AdminTokens{{Name: "ops", Scope: "write", Token: "from-file"}}, cfg.Admin.Tokens)
+
+	require.NoError(t, os.WriteFile(tokensPath, []byte("ops:write\n"), 0o600))
+	_, err = ReadConfig("")
+	require.ErrorContains(t, err, "ADMIN_TOKENS_FILE")
+
+	_, err = ReadConfig(filepath.Join(dir, "missing.yaml"))
+	require.Error(t, err)
+}
+
+func TestOptionalBoolDefaultsToTrue(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+
+	require.NoError(t, os.WriteFile(configPath, []byte("bounces:\n  mailbox:\n    protocol: imap\n"), 0o600))
+	cfg, err := ReadConfig(configPath)
+	require.NoError(t, err)
+	require.True(t, cfg.Bounces.Mailbox.TLS.Or(true))
+
+	require.NoError(t, os.WriteFile(configPath, []byte("bounces:\n  mailbox:\n    tls: false\n"), 0o600))
+	cfg, err = ReadConfig(configPath)
+	require.NoError(t, err)
+	require.False(t, cfg.Bounces.Mailbox.TLS.Or(true), "false in the file is not replaced by the default")
+
+	t.Setenv("BOUNCE_MAILBOX_TLS", "true")
+	cfg, err = ReadConfig(configPath)
+	require.NoError(t, err)
+	require.True(t, cfg.Bounces.Mailbox.TLS.Or(true))
+}
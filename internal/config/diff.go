@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Diff lists the settings that differ between two configs as "key: old -> new". The
+// values of settings tagged secret are not shown.
+func Diff(old, new *Config) []string {
+	var changes []string
+	diff(reflect.ValueOf(*old), reflect.ValueOf(*new), "", &changes)
+	return changes
+}
+
+func diff(old, new reflect.Value, prefix string, changes *[]string) {
+	for i := range old.NumField() {
+		field := old.Type().Field(i)
+		key := prefix + field.Tag.Get("yaml")
+
+		if field.Type.Kind() == reflect.Struct {
+			diff(old.Field(i), new.Field(i), key+".", changes)
+			continue
+		}
+
+		before, after := old.Field(i).Interface(), new.Field(i).Interface()
+		if reflect.DeepEqual(before, after) {
+			continue
+		}
+		if field.Tag.Get("secret") == "true" {
+			*changes = append(*changes, key+": changed")
+			continue
+		}
+		*changes = append(*changes, fmt.Sprintf("%s: %s -> %s", key, format(before), format(after)))
+	}
+}
+
+func format(value any) string {
+	if s, ok := value.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprintf("%v", value)
+}
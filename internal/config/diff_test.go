@@ -0,0 +1,26 @@
+package config
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	old := &Config{}
+	old.Email.Subject = "Rates"
+	old.Email.Password = "old"
+	old.Poller.Interval = time.Minute
+
+	updated := *old
+	updated.Email.Subject = "Today's rates"
+	updated.Email.Password = "new"
+	updated.Poller.Interval = 2 * time.Minute
+
+	require.Equal(t, []string{
+		`email.password: changed`,
+		`email.subject: "Rates" -> "Today's rates"`,
+		`poller.interval: 1m0s -> 2m0s`,
+	}, Diff(old, &updated))
+	require.Empty(t, Diff(old, old))
+}
@@ -0,0 +1,273 @@
+package config
+
+import (
+	"currency-rates-notifier/internal/api/monobank"
+	"errors"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"io"
+	"net"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Validate checks the whole config and returns every problem found, each prefixed with
+// the key of the setting, so a broken config is fixed in one go instead of failing when
+// a job first uses it
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	port, err := strconv.Atoi(c.Port)
+	if err != nil || port < 1 || port > 65535 {
+		v.add("server.port", "%q is not a port between 1 and 65535", c.Port)
+	}
+	v.positive("server.shutdownTimeout", c.ShutdownTimeout)
+	for _, proxy := range c.TrustedProxies {
+		if _, err := netip.ParsePrefix(proxy); err != nil {
+			if _, err := netip.ParseAddr(proxy); err != nil {
+				v.add("server.trustedProxies", "%q is neither an IP address nor a CIDR range", proxy)
+			}
+		}
+	}
+
+	v.url("monobank.api.url", c.Monobank.API.URL, true)
+	v.positive("monobank.api.timeout", c.Monobank.API.Timeout)
+	if c.Monobank.API.MaxRetries < 0 {
+		v.add("monobank.api.maxRetries", "must not be negative")
+	}
+	if c.Monobank.API.MaxRetries > 0 {
+		v.positive("monobank.api.retryBaseDelay", c.Monobank.API.RetryBaseDelay)
+		if c.Monobank.API.RetryMaxDelay < c.Monobank.API.RetryBaseDelay {
+			v.add("monobank.api.retryMaxDelay", "must not be shorter than retryBaseDelay")
+		}
+	}
+
+	c.Email.validate(v)
+
+	v.positive("poller.interval", c.Poller.Interval)
+	if c.Poller.HistorySize < 1 {
+		v.add("poller.historySize", "must be at least 1")
+	}
+	v.positive("stream.heartbeatInterval", c.Stream.HeartbeatInterval)
+	if c.WebSocket.SendBufferSize < 1 {
+		v.add("websocket.sendBufferSize", "must be at least 1")
+	}
+	v.positive("websocket.writeTimeout", c.WebSocket.WriteTimeout)
+
+	v.url("channels.slack.webhookURL", c.Channels.Slack.URL, false)
+	v.url("channels.teams.webhookURL", c.Channels.Teams.URL, false)
+
+	c.Subscribe.validate(v)
+
+	for i, token := range c.Admin.Tokens {
+		key := fmt.Sprintf("admin.tokens[%d]", i)
+		if token.Name == "" {
+			v.add(key+".name", "is required")
+		}
+		if token.Token == "" {
+			v.add(key+".token", "is required")
+		}
+		if token.Scope != "read" && token.Scope != "write" {
+			v.add(key+".scope", "%q is neither \"read\" nor \"write\"", token.Scope)
+		}
+	}
+
+	c.Storage.validate(v)
+
+	if c.Privacy.Secret != "" {
+		v.url("privacy.baseURL", c.Privacy.BaseURL, true)
+		v.positive("privacy.linkTTL", c.Privacy.LinkTTL)
+	}
+
+	c.Bounces.validate(v)
+
+	if _, err := cron.ParseStandard(c.Retention.Schedule); err != nil {
+		v.add("retention.schedule", "%q is not a cron expression: %s", c.Retention.Schedule, err)
+	}
+	if c.Retention.Deliveries < 0 {
+		v.add("retention.deliveries", "must not be negative")
+	}
+	if c.Retention.Bounces < 0 {
+		v.add("retention.bounces", "must not be negative")
+	}
+
+	return v.err()
+}
+
+func (e *Email) validate(v *validator) {
+	if e.Host == "" {
+		v.add("email.host", "is required")
+	} else if strings.Contains(e.Host, "://") || strings.ContainsAny(e.Host, " /") {
+		v.add("email.host", "%q is not a host name", e.Host)
+	}
+	v.address("email.from", e.From)
+
+	// the %d is replaced by a random number so bounces can be told apart, any other
+	// verb would garble the address
+	if verbs := strings.ReplaceAll(e.EnvelopeFrom, "%%", ""); strings.Count(verbs, "%") != 1 || !strings.Contains(verbs, "%d") {
+		v.add("email.envelopeFrom", "%q must contain exactly one %%d verb and no other verbs", e.EnvelopeFrom)
+	} else {
+		v.address("email.envelopeFrom", fmt.Sprintf(e.EnvelopeFrom, 1))
+	}
+
+	if e.Subject == "" {
+		v.add("email.subject", "is required")
+	}
+	if e.MessageTemplate == "" {
+		v.add("email.messageTemplate", "is required")
+	} else if tpl, err := template.New("texttpl").Parse(e.MessageTemplate); err != nil {
+		v.add("email.messageTemplate", "%s", err)
+	} else if err := tpl.Execute(io.Discard, monobank.CurrencyRate{}); err != nil {
+		// catches fields the rates do not have, e.g. {{.RateSel}}
+		v.add("email.messageTemplate", "%s", err)
+	}
+
+	if _, err := cron.ParseStandard(e.Schedule); err != nil {
+		v.add("email.schedule", "%q is not a cron expression: %s", e.Schedule, err)
+	}
+
+	if e.Validation.BlocklistFile != "" {
+		if _, err := os.Stat(e.Validation.BlocklistFile); err != nil {
+			v.add("email.validation.blocklistFile", "%s", err)
+		}
+	}
+}
+
+func (s *Subscribe) validate(v *validator) {
+	if s.RateLimit.RequestsPerMinute <= 0 {
+		v.add("subscribe.rateLimit.requestsPerMinute", "must be positive")
+	}
+	if s.RateLimit.Burst < 1 {
+		v.add("subscribe.rateLimit.burst", "must be at least 1")
+	}
+
+	verification := s.Verification
+	switch verification.Provider {
+	case "":
+	case "turnstile", "hcaptcha":
+		if verification.Secret == "" {
+			v.add("subscribe.verification.secret", "is required for %s", verification.Provider)
+		}
+		v.url("subscribe.verification.verifyURL", verification.VerifyURL, false)
+	case "pow":
+		// every bit doubles the work of the browser, beyond 32 it never finishes
+		if verification.Difficulty < 1 || verification.Difficulty > 32 {
+			v.add("subscribe.verification.difficulty", "%d is not between 1 and 32", verification.Difficulty)
+		}
+		v.positive("subscribe.verification.challengeTTL", verification.ChallengeTTL)
+	default:
+		v.add("subscribe.verification.provider", "%q is not one of turnstile, hcaptcha or pow", verification.Provider)
+	}
+}
+
+func (s *Storage) validate(v *validator) {
+	switch s.Driver {
+	case "sqlite", "postgres":
+		if s.DSN == "" {
+			v.add("storage.dsn", "is required for %s", s.Driver)
+		}
+		if s.SuppressionKey == "" {
+			v.add("storage.suppressionKey", "is required for %s", s.Driver)
+		}
+	case "memory":
+	default:
+		v.add("storage.driver", "%q is not one of sqlite, postgres or memory", s.Driver)
+	}
+
+	encryption := s.Encryption
+	if encryption.CurrentKey == "" {
+		return
+	}
+	if encryption.IndexKey == "" {
+		v.add("storage.encryption.indexKey", "is required when encryption is enabled")
+	}
+	// keys in keysFile are only known once the file is read
+	if _, ok := encryption.Keys[encryption.CurrentKey]; !ok && encryption.KeysFile == "" {
+		v.add("storage.encryption.currentKey", "%q is not in storage.encryption.keys", encryption.CurrentKey)
+	}
+	if encryption.KeysFile != "" {
+		if _, err := os.Stat(encryption.KeysFile); err != nil {
+			v.add("storage.encryption.keysFile", "%s", err)
+		}
+	}
+}
+
+func (b *Bounces) validate(v *validator) {
+	if b.Threshold < 1 {
+		v.add("bounces.threshold", "must be at least 1")
+	}
+
+	mailbox := b.Mailbox
+	switch mailbox.Protocol {
+	case "":
+		return
+	case "imap", "pop3":
+		if _, port, err := net.SplitHostPort(mailbox.Address); err != nil || port == "" {
+			v.add("bounces.mailbox.address", "%q is not a host:port", mailbox.Address)
+		}
+		if mailbox.User == "" {
+			v.add("bounces.mailbox.user", "is required for %s", mailbox.Protocol)
+		}
+		v.positive("bounces.mailbox.timeout", mailbox.Timeout)
+	case "maildir":
+		if info, err := os.Stat(mailbox.Address); err != nil {
+			v.add("bounces.mailbox.address", "%s", err)
+		} else if !info.IsDir() {
+			v.add("bounces.mailbox.address", "%q is not a directory", mailbox.Address)
+		}
+	default:
+		v.add("bounces.mailbox.protocol", "%q is not one of imap, pop3 or maildir", mailbox.Protocol)
+		return
+	}
+	v.positive("bounces.mailbox.interval", mailbox.Interval)
+}
+
+// validator collects the problems of a config
+type validator struct {
+	problems []error
+}
+
+func (v *validator) add(key, format string, args ...any) {
+	v.problems = append(v.problems, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+}
+
+func (v *validator) positive(key string, d time.Duration) {
+	if d <= 0 {
+		v.add(key, "must be positive")
+	}
+}
+
+// url checks for an absolute http or https URL, an empty one is a problem only when required
+func (v *validator) url(key, value string, required bool) {
+	if value == "" {
+		if required {
+			v.add(key, "is required")
+		}
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || !slices.Contains([]string{"http", "https"}, u.Scheme) || u.Host == "" {
+		v.add(key, "%q is not an absolute http or https URL", value)
+	}
+}
+
+func (v *validator) address(key, value string) {
+	if value == "" {
+		v.add(key, "is required")
+		return
+	}
+	if _, err := mail.ParseAddress(value); err != nil {
+		v.add(key, "%q is not an email address: %s", value, err)
+	}
+}
+
+func (v *validator) err() error {
+	return errors.Join(v.problems...)
+}
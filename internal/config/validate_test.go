@@ -0,0 +1,32 @@
+package config
+
+import (
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	cfg, err := ReadConfig("../../config/local.yaml")
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate(), "the sample config is valid")
+
+	cfg.Port = "0"
+	cfg.Email.EnvelopeFrom = "noreply+%s@test.com"
+	cfg.Email.MessageTemplate = "{{.RateSell}"
+	cfg.Admin.Tokens = AdminTokens{{Name: "ops", Token: "secret", Scope: "admin"}}
+	cfg.Storage.SuppressionKey = ""
+	cfg.Bounces.Mailbox.Protocol = "imap"
+	cfg.Bounces.Mailbox.Address = "imap.test.com"
+
+	err = cfg.Validate()
+	require.Error(t, err)
+	problems := strings.Split(err.Error(), "\n")
+	require.Len(t, problems, 7, "every problem is reported at once")
+	for i, key := range []string{
+		"server.port", "email.envelopeFrom", "email.messageTemplate", "admin.tokens[0].scope",
+		"storage.suppressionKey", "bounces.mailbox.address", "bounces.mailbox.user",
+	} {
+		require.True(t, strings.HasPrefix(problems[i], key+": "), problems[i])
+	}
+}
@@ -0,0 +1,345 @@
+package handler
+
+import (
+	"context"
+	"currency-rates-notifier/internal/lib/httputil"
+	"currency-rates-notifier/internal/lib/logger"
+	"currency-rates-notifier/internal/middleware"
+	"currency-rates-notifier/internal/storage"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+
+	maxAdminBodySize = 64 << 10
+)
+
+type SubscriberStore interface {
+	ListSubscriptions(ctx context.Context, filter storage.SubscriptionFilter) ([]storage.Subscription, int, error)
+	GetSubscription(ctx context.Context, id int64) (storage.Subscription, error)
+	UpdateSubscription(ctx context.Context, subscription storage.Subscription) (storage.Subscription, error)
+	DeleteSubscription(ctx context.Context, id int64) error
+}
+
+type AuditLog interface {
+	SaveAuditEntry(ctx context.Context, entry storage.AuditEntry) error
+	ListAuditEntries(ctx context.Context, limit, offset int) ([]storage.AuditEntry, int, error)
+}
+
+// AdminHandler serves the admin API. Authorisation is left to the router,
+// every change is recorded in the audit log under the authenticated principal.
+type AdminHandler struct {
+	subscribers SubscriberStore
+	keys        APIKeyStore
+	audit       AuditLog
+	importer    SubscriberImporter
+	log         *slog.Logger
+}
+
+func NewAdminHandler(subscribers SubscriberStore, keys APIKeyStore, audit AuditLog, importer SubscriberImporter, log *slog.Logger) *AdminHandler {
+	return &AdminHandler{subscribers: subscribers, keys: keys, audit: audit, importer: importer, log: log}
+}
+
+type pageResponse[T any] struct {
+	Items  []T `json:"items"`
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+type updateSubscriberRequest struct {
+	Status *string  `json:"status"`
+	Pairs  []string `json:"pairs"`
+}
+
+type auditEntryResponse struct {
+	ID        int64           `json:"id"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	Details   json.RawMessage `json:"details,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// ListSubscribers supports paging with limit and offset, filtering by status and
+// searching by a part of the email with search.
+func (h *AdminHandler) ListSubscribers(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context(), h.log)
+
+	query := r.URL.Query()
+	limit, offset, fieldErrors := parsePage(query)
+	status := query.Get("status")
+	if status != "" && !validStatus(status) {
+		fieldErrors = append(fieldErrors, httputil.FieldError{Field: "status", Message: fmt.Sprintf("unknown status %q", status)})
+	}
+	if len(fieldErrors) > 0 {
+		if err := httputil.ValidationError(w, r, fieldErrors); err != nil {
+			log.Error("failed to write a problem", "error", err)
+		}
+		return
+	}
+
+	subscriptions, total, err := h.subscribers.ListSubscriptions(r.Context(), storage.SubscriptionFilter{
+		Search: query.Get("search"),
+		Status: status,
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		log.Error("failed to list subscriptions", "error", err)
+		writeProblem(log, w, r, http.StatusInternalServerError, "failed to list subscribers")
+		return
+	}
+
+	page := pageResponse[subscriptionResponse]{Items: make([]subscriptionResponse, 0, len(subscriptions)), Total: total, Limit: limit, Offset: offset}
+	for _, subscription := range subscriptions {
+		page.Items = append(page.Items, newSubscriptionResponse(subscription))
+	}
+
+	if err := httputil.WriteJSON(w, http.StatusOK, page); err != nil {
+		log.Error("failed to write subscribers", "error", err)
+	}
+}
+
+func (h *AdminHandler) GetSubscriber(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context(), h.log)
+
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+
+	subscription, err := h.subscribers.GetSubscription(r.Context(), id)
+	if err != nil {
+		h.writeStoreError(w, r, err, "failed to get subscriber")
+		return
+	}
+
+	if err := httputil.WriteJSON(w, http.StatusOK, newSubscriptionResponse(subscription)); err != nil {
+		log.Error("failed to write a subscriber", "error", err)
+	}
+}
+
+// UpdateSubscriber changes the status and/or pairs of a subscriber, omitted fields are kept
+func (h *AdminHandler) UpdateSubscriber(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context(), h.log)
+
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+
+	var req updateSubscriberRequest
+	if status, err := decodeAdminRequest(w, r, &req); err != nil {
+		writeProblem(log, w, r, status, err.Error())
+		return
+	}
+
+	before, err := h.subscribers.GetSubscription(r.Context(), id)
+	if err != nil {
+		h.writeStoreError(w, r, err, "failed to get subscriber")
+		return
+	}
+
+	after := before
+	var fieldErrors []httputil.FieldError
+	if req.Status != nil {
+		if validStatus(*req.Status) {
+			after.Status = *req.Status
+		} else {
+			fieldErrors = append(fieldErrors, httputil.FieldError{Field: "status", Message: fmt.Sprintf("unknown status %q", *req.Status)})
+		}
+	}
+	if req.Pairs != nil {
+		if len(req.Pairs) == 0 {
+			fieldErrors = append(fieldErrors, httputil.FieldError{Field: "pairs", Message: "at least one pair is required"})
+		}
+		var pairErrors []httputil.FieldError
+		after.Pairs, pairErrors = normalizePairs(req.Pairs)
+		fieldErrors = append(fieldErrors, pairErrors...)
+	}
+	if len(fieldErrors) > 0 {
+		if err := httputil.ValidationError(w, r, fieldErrors); err != nil {
+			log.Error("failed to write a problem", "error", err)
+		}
+		return
+	}
+
+	updated, err := h.subscribers.UpdateSubscription(r.Context(), after)
+	if err != nil {
+		h.writeStoreError(w, r, err, "failed to update subscriber")
+		return
+	}
+
+	h.record(r, "subscriber.update", storage.SubscriberAuditTarget(id), map[string]any{
+		"before": map[string]any{"status": before.Status, "pairs": before.Pairs},
+		"after":  map[string]any{"status": updated.Status, "pairs": updated.Pairs},
+	})
+
+	if err := httputil.WriteJSON(w, http.StatusOK, newSubscriptionResponse(updated)); err != nil {
+		log.Error("failed to write a subscriber", "error", err)
+	}
+}
+
+func (h *AdminHandler) DeleteSubscriber(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+
+	subscription, err := h.subscribers.GetSubscription(r.Context(), id)
+	if err != nil {
+		h.writeStoreError(w, r, err, "failed to get subscriber")
+		return
+	}
+
+	if err := h.subscribers.DeleteSubscription(r.Context(), id); err != nil {
+		h.writeStoreError(w, r, err, "failed to delete subscriber")
+		return
+	}
+
+	// the address stays out of the audit log, which outlives the subscription
+	h.record(r, "subscriber.delete", storage.SubscriberAuditTarget(id), map[string]any{"status": subscription.Status, "pairs": subscription.Pairs})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListAuditEntries returns the audit log newest first
+func (h *AdminHandler) ListAuditEntries(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context(), h.log)
+
+	limit, offset, fieldErrors := parsePage(r.URL.Query())
+	if len(fieldErrors) > 0 {
+		if err := httputil.ValidationError(w, r, fieldErrors); err != nil {
+			log.Error("failed to write a problem", "error", err)
+		}
+		return
+	}
+
+	entries, total, err := h.audit.ListAuditEntries(r.Context(), limit, offset)
+	if err != nil {
+		log.Error("failed to list audit entries", "error", err)
+		writeProblem(log, w, r, http.StatusInternalServerError, "failed to list audit entries")
+		return
+	}
+
+	page := pageResponse[auditEntryResponse]{Items: make([]auditEntryResponse, 0, len(entries)), Total: total, Limit: limit, Offset: offset}
+	for _, entry := range entries {
+		item := auditEntryResponse{
+			ID:        entry.ID,
+			Actor:     entry.Actor,
+			Action:    entry.Action,
+			Target:    entry.Target,
+			RequestID: entry.RequestID,
+			CreatedAt: entry.CreatedAt,
+		}
+		if json.Valid([]byte(entry.Details)) {
+			item.Details = json.RawMessage(entry.Details)
+		}
+		page.Items = append(page.Items, item)
+	}
+
+	if err := httputil.WriteJSON(w, http.StatusOK, page); err != nil {
+		log.Error("failed to write audit entries", "error", err)
+	}
+}
+
+// record writes an audit entry. The change has already been made at this point,
+// so a failure is only logged.
+func (h *AdminHandler) record(r *http.Request, action, target string, details any) {
+	log := logger.FromContext(r.Context(), h.log)
+
+	entry := storage.AuditEntry{Action: action, Target: target, RequestID: middleware.RequestIDFromContext(r.Context())}
+	if principal, ok := middleware.PrincipalFromContext(r.Context()); ok {
+		entry.Actor = principal.Name
+	}
+	if details != nil {
+		b, err := json.Marshal(details)
+		if err != nil {
+			log.Error("failed to encode audit details", "error", err)
+		}
+		entry.Details = string(b)
+	}
+
+	log.Info("admin action", "actor", entry.Actor, "action", action, "target", target)
+	if err := h.audit.SaveAuditEntry(r.Context(), entry); err != nil {
+		log.Error("failed to record audit entry", "error", err, "action", action, "target", target)
+	}
+}
+
+func (h *AdminHandler) pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeProblem(logger.FromContext(r.Context(), h.log), w, r, http.StatusNotFound, "no such resource")
+		return 0, false
+	}
+	return id, true
+}
+
+func (h *AdminHandler) writeStoreError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	log := logger.FromContext(r.Context(), h.log)
+
+	switch {
+	case errors.Is(err, storage.SubscriptionNotFound):
+		writeProblem(log, w, r, http.StatusNotFound, "subscriber not found")
+	case errors.Is(err, storage.APIKeyNotFound):
+		writeProblem(log, w, r, http.StatusNotFound, "api key not found")
+	default:
+		log.Error(message, "error", err)
+		writeProblem(log, w, r, http.StatusInternalServerError, message)
+	}
+}
+
+func validStatus(status string) bool {
+	return status == storage.StatusActive || status == storage.StatusUnsubscribed
+}
+
+func parsePage(query url.Values) (int, int, []httputil.FieldError) {
+	var fieldErrors []httputil.FieldError
+
+	limit := defaultPageLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxPageLimit {
+			fieldErrors = append(fieldErrors, httputil.FieldError{Field: "limit", Message: fmt.Sprintf("must be between 1 and %d", maxPageLimit)})
+		}
+		limit = n
+	}
+
+	offset := 0
+	if value := query.Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			fieldErrors = append(fieldErrors, httputil.FieldError{Field: "offset", Message: "must be a non-negative integer"})
+		}
+		offset = n
+	}
+
+	return limit, offset, fieldErrors
+}
+
+func decodeAdminRequest(w http.ResponseWriter, r *http.Request, v any) (int, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return http.StatusUnsupportedMediaType, errors.New("unsupported Content-Type, expected application/json")
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return http.StatusBadRequest, fmt.Errorf("failed to parse JSON body: %w", err)
+	}
+
+	return 0, nil
+}
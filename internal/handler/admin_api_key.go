@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"context"
+	"currency-rates-notifier/internal/lib/apikey"
+	"currency-rates-notifier/internal/lib/httputil"
+	"currency-rates-notifier/internal/lib/logger"
+	"currency-rates-notifier/internal/storage"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type APIKeyStore interface {
+	SaveAPIKey(ctx context.Context, key storage.APIKey) (storage.APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]storage.APIKey, error)
+	DeleteAPIKey(ctx context.Context, id int64) error
+}
+
+type createAPIKeyRequest struct {
+	Name  string `json:"name"`
+	Scope string `json:"scope"`
+}
+
+type apiKeyResponse struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Scope     string    `json:"scope"`
+	Key       string    `json:"key,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newAPIKeyResponse(key storage.APIKey) apiKeyResponse {
+	return apiKeyResponse{ID: key.ID, Name: key.Name, Scope: key.Scope, CreatedAt: key.CreatedAt}
+}
+
+// CreateAPIKey issues a new key. The key itself is only returned in this response.
+func (h *AdminHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context(), h.log)
+
+	var req createAPIKeyRequest
+	if status, err := decodeAdminRequest(w, r, &req); err != nil {
+		writeProblem(log, w, r, status, err.Error())
+		return
+	}
+
+	var fieldErrors []httputil.FieldError
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		fieldErrors = append(fieldErrors, httputil.FieldError{Field: "name", Message: "name is required"})
+	}
+	if !apikey.ValidScope(req.Scope) {
+		fieldErrors = append(fieldErrors, httputil.FieldError{Field: "scope", Message: fmt.Sprintf("scope must be %q or %q", apikey.ScopeRead, apikey.ScopeWrite)})
+	}
+	if len(fieldErrors) > 0 {
+		if err := httputil.ValidationError(w, r, fieldErrors); err != nil {
+			log.Error("failed to write a problem", "error", err)
+		}
+		return
+	}
+
+	key, hash, err := apikey.Generate()
+	if err != nil {
+		log.Error("failed to generate api key", "error", err)
+		writeProblem(log, w, r, http.StatusInternalServerError, "failed to create api key")
+		return
+	}
+
+	saved, err := h.keys.SaveAPIKey(r.Context(), storage.APIKey{Name: req.Name, Scope: req.Scope, Hash: hash})
+	if err != nil {
+		log.Error("failed to save api key", "error", err)
+		writeProblem(log, w, r, http.StatusInternalServerError, "failed to create api key")
+		return
+	}
+
+	h.record(r, "api_key.create", apiKeyTarget(saved.ID), map[string]any{"name": saved.Name, "scope": saved.Scope})
+
+	resp := newAPIKeyResponse(saved)
+	resp.Key = key
+	w.Header().Set("Cache-Control", "no-store")
+	if err := httputil.WriteJSON(w, http.StatusCreated, resp); err != nil {
+		log.Error("failed to write an api key", "error", err)
+	}
+}
+
+func (h *AdminHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context(), h.log)
+
+	keys, err := h.keys.ListAPIKeys(r.Context())
+	if err != nil {
+		log.Error("failed to list api keys", "error", err)
+		writeProblem(log, w, r, http.StatusInternalServerError, "failed to list api keys")
+		return
+	}
+
+	items := make([]apiKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, newAPIKeyResponse(key))
+	}
+
+	if err := httputil.WriteJSON(w, http.StatusOK, items); err != nil {
+		log.Error("failed to write api keys", "error", err)
+	}
+}
+
+// DeleteAPIKey revokes a key. Tokens from the configuration cannot be revoked this way.
+func (h *AdminHandler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.keys.DeleteAPIKey(r.Context(), id); err != nil {
+		h.writeStoreError(w, r, err, "failed to delete api key")
+		return
+	}
+
+	h.record(r, "api_key.delete", apiKeyTarget(id), nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiKeyTarget(id int64) string {
+	return fmt.Sprintf("api_key/%d", id)
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"context"
+	"currency-rates-notifier/internal/bulk"
+	"currency-rates-notifier/internal/lib/httputil"
+	"currency-rates-notifier/internal/lib/logger"
+	"currency-rates-notifier/internal/storage"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+const maxImportBodySize = 64 << 20
+
+type SubscriberImporter interface {
+	Import(ctx context.Context, r io.Reader, opts bulk.ImportOptions) (bulk.ImportReport, error)
+}
+
+// ImportSubscribers reads a CSV body, see bulk.Importer for the format. With dry_run=true
+// nothing is stored but the report tells what an import would do.
+func (h *AdminHandler) ImportSubscribers(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context(), h.log)
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "text/csv" {
+		writeProblem(log, w, r, http.StatusUnsupportedMediaType, "unsupported Content-Type, expected text/csv")
+		return
+	}
+
+	query := r.URL.Query()
+	opts := bulk.ImportOptions{Status: storage.StatusActive}
+	var fieldErrors []httputil.FieldError
+	if status := query.Get("status"); status != "" {
+		if validStatus(status) {
+			opts.Status = status
+		} else {
+			fieldErrors = append(fieldErrors, httputil.FieldError{Field: "status", Message: fmt.Sprintf("unknown status %q", status)})
+		}
+	}
+	if value := query.Get("dry_run"); value != "" {
+		opts.DryRun, err = strconv.ParseBool(value)
+		if err != nil {
+			fieldErrors = append(fieldErrors, httputil.FieldError{Field: "dry_run", Message: "must be a boolean"})
+		}
+	}
+	if len(fieldErrors) > 0 {
+		if err := httputil.ValidationError(w, r, fieldErrors); err != nil {
+			log.Error("failed to write a problem", "error", err)
+		}
+		return
+	}
+
+	report, err := h.importer.Import(r.Context(), http.MaxBytesReader(w, r.Body, maxImportBodySize), opts)
+	if !opts.DryRun && report.Imported > 0 {
+		h.record(r, "subscriber.import", "subscribers", map[string]any{
+			"status": opts.Status, "total": report.Total, "imported": report.Imported,
+			"invalid": report.Invalid, "duplicates": report.Duplicates, "existing": report.Existing,
+			"suppressed": report.Suppressed,
+		})
+	}
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		writeProblem(log, w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the file exceeds %d bytes", maxImportBodySize))
+		return
+	case errors.Is(err, bulk.InvalidCSV):
+		writeProblem(log, w, r, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		log.Error("failed to import subscribers", "error", err, "imported", report.Imported)
+		writeProblem(log, w, r, http.StatusInternalServerError, fmt.Sprintf("import stopped after %d imported rows", report.Imported))
+		return
+	}
+
+	if err := httputil.WriteJSON(w, http.StatusOK, report); err != nil {
+		log.Error("failed to write an import report", "error", err)
+	}
+}
+
+// ExportSubscribers streams subscribers as CSV (default) or as a JSON array
+func (h *AdminHandler) ExportSubscribers(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context(), h.log)
+
+	query := r.URL.Query()
+	format := bulk.FormatCSV
+	var fieldErrors []httputil.FieldError
+	if value := query.Get("format"); value != "" {
+		format = value
+		if !bulk.ValidFormat(format) {
+			fieldErrors = append(fieldErrors, httputil.FieldError{Field: "format", Message: fmt.Sprintf("format must be %q or %q", bulk.FormatCSV, bulk.FormatJSON)})
+		}
+	}
+	status := query.Get("status")
+	if status != "" && !validStatus(status) {
+		fieldErrors = append(fieldErrors, httputil.FieldError{Field: "status", Message: fmt.Sprintf("unknown status %q", status)})
+	}
+	if len(fieldErrors) > 0 {
+		if err := httputil.ValidationError(w, r, fieldErrors); err != nil {
+			log.Error("failed to write a problem", "error", err)
+		}
+		return
+	}
+
+	contentType := "text/csv; charset=utf-8"
+	if format == bulk.FormatJSON {
+		contentType = "application/json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="subscribers.%s"`, format))
+
+	// the status is sent with the first page, so a failure can only cut the body short
+	if err := bulk.Export(r.Context(), h.subscribers, w, format, status); err != nil {
+		log.Error("failed to export subscribers", "error", err)
+	}
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"currency-rates-notifier/internal/bounce"
+	"currency-rates-notifier/internal/lib/httputil"
+	"currency-rates-notifier/internal/lib/logger"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// maxBounceBodySize leaves room for batches of events, which SendGrid sends
+const maxBounceBodySize = 1 << 20
+
+type BounceProcessor interface {
+	Process(ctx context.Context, events []bounce.Event) (bounce.Summary, error)
+}
+
+// BounceHandler receives the bounce and complaint webhooks of email service providers.
+// ESPs are configured with a URL carrying the token, as not all of them can sign requests.
+type BounceHandler struct {
+	processor BounceProcessor
+	token     string
+	log       *slog.Logger
+}
+
+func NewBounceHandler(processor BounceProcessor, token string, log *slog.Logger) *BounceHandler {
+	return &BounceHandler{processor: processor, token: token, log: log}
+}
+
+func (h *BounceHandler) Webhook(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context(), h.log)
+
+	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(h.token)) != 1 {
+		writeProblem(log, w, r, http.StatusForbidden, "invalid webhook token")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBounceBodySize))
+	if err != nil {
+		writeProblem(log, w, r, http.StatusBadRequest, "failed to read body")
+		return
+	}
+
+	payload, err := bounce.ParseWebhook(body)
+	if err != nil {
+		writeProblem(log, w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if payload.ConfirmURL != "" {
+		// the URL is not visited automatically, that would make the server fetch any URL it is sent
+		log.Warn("bounce webhook needs a subscription confirmation, visit the URL to confirm", "url", payload.ConfirmURL)
+	}
+
+	summary, err := h.processor.Process(r.Context(), payload.Events)
+	if err != nil {
+		// the ESP retries failed deliveries, events processed already are safe to repeat as
+		// hard bounces are counted once per event id
+		log.Error("failed to process bounces", "error", err)
+		writeProblem(log, w, r, http.StatusInternalServerError, "failed to process bounces")
+		return
+	}
+
+	if err := httputil.WriteJSON(w, http.StatusOK, summary); err != nil {
+		log.Error("failed to write a bounce summary", "error", err)
+	}
+}
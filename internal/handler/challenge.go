@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"currency-rates-notifier/internal/lib/httputil"
+	"currency-rates-notifier/internal/lib/logger"
+	"currency-rates-notifier/internal/lib/verifier"
+	"log/slog"
+	"net/http"
+)
+
+type ChallengeIssuer interface {
+	NewChallenge() (verifier.Challenge, error)
+}
+
+type ChallengeHandler struct {
+	issuer ChallengeIssuer
+	log    *slog.Logger
+}
+
+func NewChallengeHandler(issuer ChallengeIssuer, log *slog.Logger) *ChallengeHandler {
+	return &ChallengeHandler{issuer: issuer, log: log}
+}
+
+// GetChallenge issues a proof-of-work challenge to be solved before subscribing
+func (h *ChallengeHandler) GetChallenge(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context(), h.log)
+
+	challenge, err := h.issuer.NewChallenge()
+	if err != nil {
+		log.Error("failed to issue a challenge", "error", err)
+		writeProblem(log, w, r, http.StatusInternalServerError, "failed to issue a challenge")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err := httputil.WriteJSON(w, http.StatusOK, challenge); err != nil {
+		log.Error("failed to write a challenge", "error", err)
+	}
+}
@@ -0,0 +1,529 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"currency-rates-notifier/internal/api/monobank"
+	"currency-rates-notifier/internal/bounce"
+	"currency-rates-notifier/internal/bulk"
+	"currency-rates-notifier/internal/job"
+	"currency-rates-notifier/internal/lib/apikey"
+	"currency-rates-notifier/internal/lib/currency"
+	"currency-rates-notifier/internal/lib/emailaddr"
+	"currency-rates-notifier/internal/lib/httputil"
+	"currency-rates-notifier/internal/lib/logger/handler"
+	"currency-rates-notifier/internal/lib/verifier"
+	"currency-rates-notifier/internal/middleware"
+	"currency-rates-notifier/internal/openapi"
+	"currency-rates-notifier/internal/privacy"
+	"currency-rates-notifier/internal/storage"
+	"currency-rates-notifier/internal/storage/memory"
+	"encoding/json"
+	"fmt"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/stretchr/testify/require"
+	"github.com/wneessen/go-mail"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type stubRateFetcher struct {
+	err error
+}
+
+func (f *stubRateFetcher) FetchCurrencyRate(_ context.Context, pair currency.Pair) (monobank.CurrencyRate, error) {
+	if f.err != nil {
+		return monobank.CurrencyRate{}, f.err
+	}
+	if pair != monobank.USDToUAH {
+		return monobank.CurrencyRate{}, monobank.RateNotFound
+	}
+	return monobank.CurrencyRate{CurrencyCodeA: monobank.CurrencyUSD, CurrencyCodeB: monobank.CurrencyUAH, Date: 1, RateSell: 41.5, RateBuy: 41}, nil
+}
+
+type stubRateSubscriber struct{}
+
+func (stubRateSubscriber) Subscribe(uint64) ([]job.CurrencyRateEvent, <-chan job.CurrencyRateEvent, func()) {
+	events := make(chan job.CurrencyRateEvent)
+	close(events)
+	backlog := []job.CurrencyRateEvent{{ID: 1, Rate: monobank.CurrencyRate{CurrencyCodeA: monobank.CurrencyUSD, CurrencyCodeB: monobank.CurrencyUAH}}}
+	return backlog, events, func() {}
+}
+
+func (s stubRateSubscriber) Latest() []job.CurrencyRateEvent {
+	backlog, _, _ := s.Subscribe(0)
+	return backlog
+}
+
+type stubSubscriptionSaver struct {
+	emails map[string]struct{}
+}
+
+func (s *stubSubscriptionSaver) SaveSubscription(_ context.Context, subscription storage.Subscription) (storage.Subscription, error) {
+	if _, ok := s.emails[strings.ToLower(subscription.Email)]; ok {
+		return storage.Subscription{}, storage.EmailExists
+	}
+	s.emails[strings.ToLower(subscription.Email)] = struct{}{}
+	subscription.ID = int64(len(s.emails))
+	subscription.CreatedAt = time.Now().UTC()
+	return subscription, nil
+}
+
+// contract checks responses against the operation documented in the OpenAPI document
+type contract struct {
+	spec     map[string]any
+	compiler *jsonschema.Compiler
+}
+
+func newContract(t *testing.T) *contract {
+	var spec map[string]any
+	require.NoError(t, json.Unmarshal(openapi.Spec, &spec))
+	require.Equal(t, "3.1.0", spec["openapi"])
+
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(openapi.Spec))
+	require.NoError(t, err)
+	compiler := jsonschema.NewCompiler()
+	require.NoError(t, compiler.AddResource("openapi.json", doc))
+
+	return &contract{spec: spec, compiler: compiler}
+}
+
+func (c *contract) validate(t *testing.T, method, path string, resp *http.Response) {
+	t.Helper()
+
+	pathItem, ok := c.spec["paths"].(map[string]any)[path].(map[string]any)
+	require.True(t, ok, "path %s is not documented", path)
+	operation, ok := pathItem[strings.ToLower(method)].(map[string]any)
+	require.True(t, ok, "operation %s %s is not documented", method, path)
+
+	status := strconv.Itoa(resp.StatusCode)
+	response, ok := operation["responses"].(map[string]any)[status].(map[string]any)
+	require.True(t, ok, "status %s of %s %s is not documented", status, method, path)
+	pointer := fmt.Sprintf("openapi.json#/paths/%s/%s/responses/%s", escapePointer(path), strings.ToLower(method), status)
+	if ref, ok := response["$ref"].(string); ok {
+		pointer = "openapi.json" + ref
+		name := ref[strings.LastIndex(ref, "/")+1:]
+		response = c.spec["components"].(map[string]any)["responses"].(map[string]any)[name].(map[string]any)
+	}
+
+	headers, _ := response["headers"].(map[string]any)
+	for header := range headers {
+		require.NotEmpty(t, resp.Header.Get(header), "documented header %s is missing", header)
+	}
+
+	if _, ok := response["content"]; !ok {
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Empty(t, body, "%s %s %s is documented without content", method, path, status)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	content, ok := response["content"].(map[string]any)[mediaType].(map[string]any)
+	require.True(t, ok, "content type %s of %s %s %s is not documented", mediaType, method, path, status)
+	if _, ok := content["schema"]; !ok || !strings.HasSuffix(mediaType, "json") {
+		return
+	}
+
+	schema, err := c.compiler.Compile(fmt.Sprintf("%s/content/%s/schema", pointer, escapePointer(mediaType)))
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	instance, err := jsonschema.UnmarshalJSON(bytes.NewReader(body))
+	require.NoError(t, err)
+	require.NoError(t, schema.Validate(instance), "response body: %s", body)
+}
+
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
+
+func TestHandlersConformToOpenAPI(t *testing.T) {
+	log := slog.New(handler.NewNoOpHandler())
+	validator, err := emailaddr.NewValidator(nil, nil)
+	require.NoError(t, err)
+
+	fetcher := &stubRateFetcher{}
+	router := NewRouter(Handlers{
+		CurrencyRate:       NewCurrencyRateHandler(fetcher, log),
+		CurrencyRateStream: NewCurrencyRateStreamHandler(stubRateSubscriber{}, time.Minute, log),
+		CurrencyRateWS:     NewCurrencyRateWSHandler(NewCurrencyRateHub(stubRateSubscriber{}, log), 1, time.Second, nil, log),
+		Subscription:       NewSubscriptionHandler(&stubSubscriptionSaver{emails: map[string]struct{}{}}, validator, nil, false, log),
+		Challenge:          NewChallengeHandler(verifier.NewProofOfWork([]byte("key"), 1, time.Minute), log),
+		Bounce:             NewBounceHandler(bounce.NewProcessor(memory.New(), 1, log), "hook-token", log),
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	c := newContract(t)
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		query       string
+		contentType string
+		body        string
+		fetchErr    error
+		status      int
+	}{
+		{name: "rate", method: http.MethodGet, path: "/rate", status: http.StatusOK},
+		{name: "rate of invalid pair", method: http.MethodGet, path: "/rate", query: "pair=USD", status: http.StatusBadRequest},
+		{name: "rate of unknown pair", method: http.MethodGet, path: "/rate", query: "pair=EUR/UAH", status: http.StatusNotFound},
+		{name: "rate limited", method: http.MethodGet, path: "/rate", fetchErr: &monobank.RateLimitedError{RetryAfter: time.Minute}, status: http.StatusServiceUnavailable},
+		{name: "rate stream", method: http.MethodGet, path: "/rates/stream", status: http.StatusOK},
+		{name: "rate stream with invalid last event id", method: http.MethodGet, path: "/rates/stream", query: "lastEventId=x", status: http.StatusBadRequest},
+		{name: "subscribe with json", method: http.MethodPost, path: "/subscribe", contentType: "application/json", body: `{"email":"user@example.com","pairs":["EUR/UAH"]}`, status: http.StatusCreated},
+		{name: "subscribe with form", method: http.MethodPost, path: "/subscribe", contentType: "application/x-www-form-urlencoded", body: "email=other@example.com", status: http.StatusCreated},
+		{name: "subscribe without content type", method: http.MethodPost, path: "/subscribe", query: "email=third@example.com&pairs=EUR/UAH", status: http.StatusCreated},
+		{name: "subscribe twice", method: http.MethodPost, path: "/subscribe", contentType: "application/json", body: `{"email":"User@Example.com"}`, status: http.StatusConflict},
+		{name: "subscribe with invalid fields", method: http.MethodPost, path: "/subscribe", contentType: "application/json", body: `{"email":"nope","pairs":["XXX"]}`, status: http.StatusBadRequest},
+		{name: "subscribe with unsupported body", method: http.MethodPost, path: "/subscribe", contentType: "text/plain", body: "user@example.com", status: http.StatusUnsupportedMediaType},
+		{name: "openapi document", method: http.MethodGet, path: "/openapi.json", status: http.StatusOK},
+		{name: "proof of work challenge", method: http.MethodGet, path: "/subscribe/challenge", status: http.StatusOK},
+		{name: "bounce webhook", method: http.MethodPost, path: "/bounces/webhook", query: "token=hook-token", contentType: "application/json", body: `[{"email":"gone@example.com","event":"bounce"}]`, status: http.StatusOK},
+		{name: "bounce webhook with invalid token", method: http.MethodPost, path: "/bounces/webhook", query: "token=nope", contentType: "application/json", body: `[]`, status: http.StatusForbidden},
+		{name: "bounce webhook with unknown payload", method: http.MethodPost, path: "/bounces/webhook", query: "token=hook-token", contentType: "application/json", body: `{"hello":"world"}`, status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher.err = tt.fetchErr
+
+			req, err := http.NewRequest(tt.method, server.URL+APIPrefix+tt.path+"?"+tt.query, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, tt.status, resp.StatusCode)
+			c.validate(t, tt.method, tt.path, resp)
+			if tt.status == http.StatusCreated {
+				require.Regexp(t, `^/api/v1/admin/subscribers/\d+$`, resp.Header.Get("Location"))
+			} else {
+				require.Empty(t, resp.Header.Get("Location"))
+			}
+		})
+	}
+}
+
+func TestUniformSubscribeResponsesConformToOpenAPI(t *testing.T) {
+	log := slog.New(handler.NewNoOpHandler())
+	validator, err := emailaddr.NewValidator(nil, nil)
+	require.NoError(t, err)
+	resolver, err := httputil.NewClientIPResolver(nil)
+	require.NoError(t, err)
+
+	router := NewRouter(Handlers{
+		CurrencyRate:       NewCurrencyRateHandler(&stubRateFetcher{}, log),
+		CurrencyRateStream: NewCurrencyRateStreamHandler(stubRateSubscriber{}, time.Minute, log),
+		CurrencyRateWS:     NewCurrencyRateWSHandler(NewCurrencyRateHub(stubRateSubscriber{}, log), 1, time.Second, nil, log),
+		Subscription:       NewSubscriptionHandler(&stubSubscriptionSaver{emails: map[string]struct{}{}}, validator, nil, true, log),
+
+		SubscribeMiddlewares: []middleware.Middleware{middleware.RateLimit(middleware.NewRateLimiter(1, 2, resolver), log)},
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	c := newContract(t)
+	subscribe := func() *http.Response {
+		resp, err := http.Post(server.URL+APIPrefix+"/subscribe", "application/json", strings.NewReader(`{"email":"user@example.com"}`))
+		require.NoError(t, err)
+		return resp
+	}
+
+	for range 2 {
+		resp := subscribe()
+		require.Equal(t, http.StatusAccepted, resp.StatusCode, "new and existing addresses must be indistinguishable")
+		c.validate(t, http.MethodPost, "/subscribe", resp)
+		resp.Body.Close()
+	}
+
+	resp := subscribe()
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	c.validate(t, http.MethodPost, "/subscribe", resp)
+}
+
+func TestAdminHandlersConformToOpenAPI(t *testing.T) {
+	log := slog.New(handler.NewNoOpHandler())
+	store := memory.New()
+
+	subscription, err := store.SaveSubscription(context.Background(), storage.Subscription{Email: "user@example.com", Status: storage.StatusActive, Pairs: []string{"USD/UAH"}})
+	require.NoError(t, err)
+
+	validator, err := emailaddr.NewValidator(nil, nil)
+	require.NoError(t, err)
+	auth, err := apikey.NewAuthenticator(store, []apikey.StaticToken{
+		{Name: "viewer", Token: "read-token", Scope: apikey.ScopeRead},
+		{Name: "admin", Token: "write-token", Scope: apikey.ScopeWrite},
+	})
+	require.NoError(t, err)
+
+	router := NewRouter(Handlers{
+		CurrencyRate:       NewCurrencyRateHandler(&stubRateFetcher{}, log),
+		CurrencyRateStream: NewCurrencyRateStreamHandler(stubRateSubscriber{}, time.Minute, log),
+		CurrencyRateWS:     NewCurrencyRateWSHandler(NewCurrencyRateHub(stubRateSubscriber{}, log), 1, time.Second, nil, log),
+		Subscription:       NewSubscriptionHandler(store, validator, nil, false, log),
+
+		Admin:      NewAdminHandler(store, store, store, bulk.NewImporter(store, validator), log),
+		AdminRead:  middleware.RequireScope(auth, apikey.ScopeRead, log),
+		AdminWrite: middleware.RequireScope(auth, apikey.ScopeWrite, log),
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	c := newContract(t)
+	do := func(method, path, token, contentType, body string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+APIPrefix+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if contentType == "" && body != "" {
+			contentType = "application/json"
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	subscriber := fmt.Sprintf("/admin/subscribers/%d", subscription.ID)
+	csv := "email,pairs\nnew@example.com,EUR/UAH;USD/UAH\nnope,USD/UAH\nNew@example.com,\nuser@example.com,\n"
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		template    string
+		token       string
+		contentType string
+		body        string
+		status      int
+	}{
+		{name: "list without a key", method: http.MethodGet, path: "/admin/subscribers?search=example", template: "/admin/subscribers", status: http.StatusUnauthorized},
+		{name: "list", method: http.MethodGet, path: "/admin/subscribers?search=example&status=active", template: "/admin/subscribers", token: "read-token", status: http.StatusOK},
+		{name: "list with invalid paging", method: http.MethodGet, path: "/admin/subscribers?limit=0", template: "/admin/subscribers", token: "read-token", status: http.StatusBadRequest},
+		{name: "get", method: http.MethodGet, path: subscriber, template: "/admin/subscribers/{id}", token: "read-token", status: http.StatusOK},
+		{name: "get unknown", method: http.MethodGet, path: "/admin/subscribers/1000", template: "/admin/subscribers/{id}", token: "read-token", status: http.StatusNotFound},
+		{name: "update with read scope", method: http.MethodPatch, path: subscriber, template: "/admin/subscribers/{id}", token: "read-token", body: `{"status":"unsubscribed"}`, status: http.StatusForbidden},
+		{name: "update", method: http.MethodPatch, path: subscriber, template: "/admin/subscribers/{id}", token: "write-token", body: `{"status":"unsubscribed","pairs":["eur/uah"]}`, status: http.StatusOK},
+		{name: "update with invalid fields", method: http.MethodPatch, path: subscriber, template: "/admin/subscribers/{id}", token: "write-token", body: `{"status":"gone","pairs":[]}`, status: http.StatusBadRequest},
+		{name: "create api key", method: http.MethodPost, path: "/admin/api-keys", template: "/admin/api-keys", token: "write-token", body: `{"name":"support","scope":"read"}`, status: http.StatusCreated},
+		{name: "list api keys", method: http.MethodGet, path: "/admin/api-keys", template: "/admin/api-keys", token: "write-token", status: http.StatusOK},
+		{name: "audit log", method: http.MethodGet, path: "/admin/audit", template: "/admin/audit", token: "read-token", status: http.StatusOK},
+		{name: "delete", method: http.MethodDelete, path: subscriber, template: "/admin/subscribers/{id}", token: "write-token", status: http.StatusNoContent},
+		{name: "delete again", method: http.MethodDelete, path: subscriber, template: "/admin/subscribers/{id}", token: "write-token", status: http.StatusNotFound},
+		{name: "import dry run", method: http.MethodPost, path: "/admin/subscribers/import?dry_run=true", template: "/admin/subscribers/import", token: "write-token", contentType: "text/csv", body: csv, status: http.StatusOK},
+		{name: "import", method: http.MethodPost, path: "/admin/subscribers/import", template: "/admin/subscribers/import", token: "write-token", contentType: "text/csv", body: csv, status: http.StatusOK},
+		{name: "import without email column", method: http.MethodPost, path: "/admin/subscribers/import", template: "/admin/subscribers/import", token: "write-token", contentType: "text/csv", body: "address\n", status: http.StatusBadRequest},
+		{name: "export csv", method: http.MethodGet, path: "/admin/subscribers/export", template: "/admin/subscribers/export", token: "read-token", status: http.StatusOK},
+		{name: "export json", method: http.MethodGet, path: "/admin/subscribers/export?format=json&status=active", template: "/admin/subscribers/export", token: "read-token", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := do(tt.method, tt.path, tt.token, tt.contentType, tt.body)
+			defer resp.Body.Close()
+
+			require.Equal(t, tt.status, resp.StatusCode)
+			c.validate(t, tt.method, tt.template, resp)
+		})
+	}
+
+	imported, err := store.GetActiveSubscriptionsAfter(context.Background(), 0, 10)
+	require.NoError(t, err)
+	require.Len(t, imported, 2)
+	require.Equal(t, []string{"EUR/UAH", "USD/UAH"}, imported[0].Pairs)
+
+	entries, total, err := store.ListAuditEntries(context.Background(), 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, 4, total)
+	require.Equal(t, "subscriber.import", entries[0].Action)
+	require.Equal(t, "subscriber.delete", entries[1].Action)
+	require.Equal(t, "admin", entries[1].Actor)
+	require.NotContains(t, entries[1].Details, "@", "the audit log does not keep addresses")
+
+	keys, err := store.ListAPIKeys(context.Background())
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	resp := do(http.MethodDelete, fmt.Sprintf("/admin/api-keys/%d", keys[0].ID), "write-token", "", "")
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	created := do(http.MethodPost, "/subscribe", "", "", `{"email":"created@example.com"}`)
+	created.Body.Close()
+	require.Equal(t, http.StatusCreated, created.StatusCode)
+	location := strings.TrimPrefix(created.Header.Get("Location"), APIPrefix)
+	found := do(http.MethodGet, location, "read-token", "", "")
+	defer found.Body.Close()
+	require.Equal(t, http.StatusOK, found.StatusCode, "the Location of a new subscription resolves")
+	var body subscriptionResponse
+	require.NoError(t, json.NewDecoder(found.Body).Decode(&body))
+	require.Equal(t, "created@example.com", body.Email)
+}
+
+type recordingMailSender struct {
+	mu       sync.Mutex
+	messages []*mail.Msg
+}
+
+func (m *recordingMailSender) DialAndSendWithContext(_ context.Context, messages ...*mail.Msg) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, messages...)
+	return nil
+}
+
+// wait returns the messages once n have been sent
+func (m *recordingMailSender) wait(t *testing.T, n int) []*mail.Msg {
+	t.Helper()
+	var messages []*mail.Msg
+	require.Eventually(t, func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		messages = slices.Clone(m.messages)
+		return len(messages) >= n
+	}, 5*time.Second, 10*time.Millisecond)
+	return messages
+}
+
+var privacyLinkPattern = regexp.MustCompile(`https?://\S+`)
+
+func TestPrivacyHandlersConformToOpenAPI(t *testing.T) {
+	log := slog.New(handler.NewNoOpHandler())
+	store := memory.New()
+	_, err := store.SaveSubscription(context.Background(), storage.Subscription{Email: "user@example.com", Status: storage.StatusActive, Pairs: []string{"USD/UAH"}})
+	require.NoError(t, err)
+
+	validator, err := emailaddr.NewValidator(nil, nil)
+	require.NoError(t, err)
+	mailer := &recordingMailSender{}
+	service := privacy.NewService(store, mailer, privacy.Options{
+		Secret:  []byte("secret"),
+		LinkTTL: time.Hour,
+		LinkURL: func(action string) string { return "https://example.com" + APIPrefix + "/privacy/" + action },
+		From:    "noreply@example.com",
+	}, log)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go service.Run(ctx)
+
+	router := NewRouter(Handlers{
+		CurrencyRate:       NewCurrencyRateHandler(&stubRateFetcher{}, log),
+		CurrencyRateStream: NewCurrencyRateStreamHandler(stubRateSubscriber{}, time.Minute, log),
+		CurrencyRateWS:     NewCurrencyRateWSHandler(NewCurrencyRateHub(stubRateSubscriber{}, log), 1, time.Second, nil, log),
+		Subscription:       NewSubscriptionHandler(store, validator, nil, false, log),
+		Privacy:            NewPrivacyHandler(service, validator, log),
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	c := newContract(t)
+	do := func(method, path, query, contentType, body string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+APIPrefix+path+"?"+query, strings.NewReader(body))
+		require.NoError(t, err)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	// requestToken asks for a link and returns the token it carries, the n-th link sent
+	requestToken := func(action string, n int) string {
+		resp := do(http.MethodPost, "/privacy/requests", "", "application/json", `{"email":"User@example.com","action":"`+action+`"}`)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusAccepted, resp.StatusCode)
+		c.validate(t, http.MethodPost, "/privacy/requests", resp)
+
+		messages := mailer.wait(t, n)
+		parts := messages[n-1].GetParts()
+		body, err := parts[0].GetContent()
+		require.NoError(t, err)
+		link, err := url.Parse(privacyLinkPattern.FindString(string(body)))
+		require.NoError(t, err)
+		return link.Query().Get("token")
+	}
+
+	exportToken := url.QueryEscape(requestToken(privacy.ActionExport, 1))
+	eraseToken := requestToken(privacy.ActionErase, 2)
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		query       string
+		contentType string
+		body        string
+		status      int
+	}{
+		{name: "request for unknown address", method: http.MethodPost, path: "/privacy/requests", contentType: "application/json", body: `{"email":"nobody@example.com","action":"export"}`, status: http.StatusAccepted},
+		{name: "request with invalid fields", method: http.MethodPost, path: "/privacy/requests", contentType: "application/json", body: `{"email":"nope","action":"delete"}`, status: http.StatusBadRequest},
+		{name: "request with unsupported body", method: http.MethodPost, path: "/privacy/requests", contentType: "text/plain", body: "user@example.com", status: http.StatusUnsupportedMediaType},
+		{name: "export", method: http.MethodGet, path: "/privacy/export", query: "token=" + exportToken, status: http.StatusOK},
+		{name: "export with erase token", method: http.MethodGet, path: "/privacy/export", query: "token=" + url.QueryEscape(eraseToken), status: http.StatusForbidden},
+		{name: "confirm erase", method: http.MethodGet, path: "/privacy/erase", query: "token=" + url.QueryEscape(eraseToken), status: http.StatusOK},
+		{name: "erase with export token", method: http.MethodPost, path: "/privacy/erase", contentType: "application/x-www-form-urlencoded", body: "token=" + exportToken, status: http.StatusForbidden},
+		{name: "erase", method: http.MethodPost, path: "/privacy/erase", contentType: "application/x-www-form-urlencoded", body: url.Values{"token": {eraseToken}}.Encode(), status: http.StatusOK},
+		{name: "erase again", method: http.MethodPost, path: "/privacy/erase", contentType: "application/x-www-form-urlencoded", body: url.Values{"token": {eraseToken}}.Encode(), status: http.StatusForbidden},
+		{name: "export after erasure", method: http.MethodGet, path: "/privacy/export", query: "token=" + exportToken, status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := do(tt.method, tt.path, tt.query, tt.contentType, tt.body)
+			defer resp.Body.Close()
+
+			require.Equal(t, tt.status, resp.StatusCode)
+			if tt.path != "/privacy/requests" {
+				require.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+			}
+			c.validate(t, tt.method, tt.path, resp)
+		})
+	}
+	// links are mailed in order, so the unknown address has been handled once a third is sent
+	_, err = store.SaveSubscription(context.Background(), storage.Subscription{Email: "other@example.com", Status: storage.StatusActive, Pairs: []string{"USD/UAH"}})
+	require.NoError(t, err)
+	resp := do(http.MethodPost, "/privacy/requests", "", "application/json", `{"email":"other@example.com","action":"export"}`)
+	resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	require.Len(t, mailer.wait(t, 3), 3, "no link must be sent to an unknown address")
+}
+
+func TestLegacyRoutesAreAliases(t *testing.T) {
+	log := slog.New(handler.NewNoOpHandler())
+	router := NewRouter(Handlers{
+		CurrencyRate:       NewCurrencyRateHandler(&stubRateFetcher{}, log),
+		CurrencyRateStream: NewCurrencyRateStreamHandler(stubRateSubscriber{}, time.Minute, log),
+		CurrencyRateWS:     NewCurrencyRateWSHandler(NewCurrencyRateHub(stubRateSubscriber{}, log), 1, time.Second, nil, log),
+		Subscription:       NewSubscriptionHandler(&stubSubscriptionSaver{emails: map[string]struct{}{}}, nil, nil, false, log),
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rate", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -1,14 +1,20 @@
 package handler
 
 import (
+	"context"
 	"currency-rates-notifier/internal/api/monobank"
+	"currency-rates-notifier/internal/lib/currency"
 	"currency-rates-notifier/internal/lib/httputil"
+	"currency-rates-notifier/internal/lib/logger"
+	"errors"
 	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 )
 
 type CurrencyRateFetcher interface {
-	FetchUSDToUAHCurrencyRate() (monobank.CurrencyRate, error)
+	FetchCurrencyRate(ctx context.Context, pair currency.Pair) (monobank.CurrencyRate, error)
 }
 
 type CurrencyRateHandler struct {
@@ -20,15 +26,57 @@ func NewCurrencyRateHandler(fetcher CurrencyRateFetcher, log *slog.Logger) *Curr
 	return &CurrencyRateHandler{fetcher: fetcher, log: log}
 }
 
+// GetCurrencyRate returns the rate of the pair given in the "pair" query parameter, USD/UAH by default
 func (h *CurrencyRateHandler) GetCurrencyRate(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context(), h.log)
 
-	rate, err := h.fetcher.FetchUSDToUAHCurrencyRate()
+	pair := monobank.USDToUAH
+	if value := r.URL.Query().Get("pair"); value != "" {
+		var err error
+		pair, err = currency.ParsePair(value)
+		if err != nil {
+			writeProblem(log, w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	rate, err := h.fetcher.FetchCurrencyRate(r.Context(), pair)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeFetchError(log, w, r, err)
+		return
 	}
 
-	if err := httputil.WriteJSON(w, rate); err != nil {
-		h.log.Error("failed to write a currencyRate", "error", err)
+	if err := httputil.WriteJSON(w, http.StatusOK, rate); err != nil {
+		log.Error("failed to write a currencyRate", "error", err)
 		return
 	}
 }
+
+func writeFetchError(log *slog.Logger, w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		rateLimited *monobank.RateLimitedError
+		unavailable *monobank.UpstreamUnavailableError
+		decodeErr   *monobank.DecodeError
+	)
+
+	switch {
+	case errors.Is(err, monobank.RateNotFound):
+		writeProblem(log, w, r, http.StatusNotFound, err.Error())
+	case errors.As(err, &rateLimited):
+		if rateLimited.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rateLimited.RetryAfter.Seconds()))))
+		}
+		writeProblem(log, w, r, http.StatusServiceUnavailable, "currency rate provider is rate limiting requests")
+	case errors.As(err, &unavailable):
+		log.Error("currency rate provider is unavailable", "error", err)
+		writeProblem(log, w, r, http.StatusServiceUnavailable, "currency rate provider is unavailable")
+	case errors.As(err, &decodeErr):
+		log.Error("currency rate provider returned an invalid response", "error", err)
+		writeProblem(log, w, r, http.StatusBadGateway, "currency rate provider returned an invalid response")
+	case errors.Is(err, context.Canceled):
+		log.Debug("currency rate request cancelled", "error", err)
+	default:
+		log.Error("failed to fetch currency rate", "error", err)
+		writeProblem(log, w, r, http.StatusInternalServerError, "failed to fetch currency rate")
+	}
+}
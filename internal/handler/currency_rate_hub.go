@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"currency-rates-notifier/internal/job"
+	"currency-rates-notifier/internal/lib/currency"
+	"github.com/coder/websocket"
+	"log/slog"
+	"sync"
+)
+
+type CurrencyRateSource interface {
+	CurrencyRateSubscriber
+	Latest() []job.CurrencyRateEvent
+}
+
+// CurrencyRateHub holds a single subscription to the shared rate poller and fans
+// updates out to WebSocket clients according to the pairs each of them subscribed to.
+type CurrencyRateHub struct {
+	source CurrencyRateSource
+	log    *slog.Logger
+
+	mu      sync.RWMutex
+	clients map[*wsClient]struct{}
+}
+
+func NewCurrencyRateHub(source CurrencyRateSource, log *slog.Logger) *CurrencyRateHub {
+	return &CurrencyRateHub{source: source, log: log, clients: make(map[*wsClient]struct{})}
+}
+
+// Run forwards poller events to clients until ctx is cancelled, then disconnects them.
+// If the poller drops the hub for falling behind, it resubscribes from the last event it has seen.
+func (h *CurrencyRateHub) Run(ctx context.Context) {
+	defer h.closeClients()
+
+	var lastID uint64
+	for ctx.Err() == nil {
+		backlog, events, unsubscribe := h.source.Subscribe(lastID)
+		for _, event := range backlog {
+			h.broadcast(event)
+			lastID = event.ID
+		}
+
+		lastID = h.forward(ctx, events, lastID)
+		unsubscribe()
+	}
+}
+
+func (h *CurrencyRateHub) forward(ctx context.Context, events <-chan job.CurrencyRateEvent, lastID uint64) uint64 {
+	for {
+		select {
+		case <-ctx.Done():
+			return lastID
+		case event, ok := <-events:
+			if !ok {
+				return lastID
+			}
+			h.broadcast(event)
+			lastID = event.ID
+		}
+	}
+}
+
+func (h *CurrencyRateHub) broadcast(event job.CurrencyRateEvent) {
+	pair := event.Rate.Pair()
+	message := wsMessage{Type: wsMessageUpdate, Update: &wsRateUpdate{ID: event.ID, Pair: pair.String(), Rate: event.Rate}}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.clients {
+		if client.isSubscribed(pair) {
+			client.send(message)
+		}
+	}
+}
+
+// snapshot returns the latest rates of the given pairs.
+func (h *CurrencyRateHub) snapshot(pairs []currency.Pair) []wsRateUpdate {
+	wanted := make(map[currency.Pair]struct{}, len(pairs))
+	for _, pair := range pairs {
+		wanted[pair] = struct{}{}
+	}
+
+	rates := make([]wsRateUpdate, 0, len(pairs))
+	for _, event := range h.source.Latest() {
+		pair := event.Rate.Pair()
+		if _, ok := wanted[pair]; ok {
+			rates = append(rates, wsRateUpdate{ID: event.ID, Pair: pair.String(), Rate: event.Rate})
+		}
+	}
+
+	return rates
+}
+
+func (h *CurrencyRateHub) closeClients() {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.clients {
+		client.close(websocket.StatusGoingAway, "server shutting down")
+	}
+}
+
+func (h *CurrencyRateHub) register(client *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.clients[client] = struct{}{}
+}
+
+func (h *CurrencyRateHub) unregister(client *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.clients, client)
+}
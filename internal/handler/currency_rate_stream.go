@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"currency-rates-notifier/internal/job"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultHeartbeatInterval replaces intervals that are not positive, which time.NewTicker rejects
+const defaultHeartbeatInterval = 15 * time.Second
+
+type CurrencyRateSubscriber interface {
+	Subscribe(lastEventID uint64) ([]job.CurrencyRateEvent, <-chan job.CurrencyRateEvent, func())
+}
+
+type CurrencyRateStreamHandler struct {
+	subscriber        CurrencyRateSubscriber
+	heartbeatInterval time.Duration
+	log               *slog.Logger
+}
+
+func NewCurrencyRateStreamHandler(subscriber CurrencyRateSubscriber, heartbeatInterval time.Duration, log *slog.Logger) *CurrencyRateStreamHandler {
+	if heartbeatInterval <= 0 {
+		heartbeatInterval = defaultHeartbeatInterval
+	}
+	return &CurrencyRateStreamHandler{subscriber: subscriber, heartbeatInterval: heartbeatInterval, log: log}
+}
+
+// StreamCurrencyRates pushes rate changes as Server-Sent Events. Clients resume after
+// a reconnect by sending the Last-Event-ID header (or lastEventId query parameter).
+func (h *CurrencyRateStreamHandler) StreamCurrencyRates(w http.ResponseWriter, r *http.Request) {
+	lastEventID, err := parseLastEventID(r)
+	if err != nil {
+		writeProblem(h.log, w, r, http.StatusBadRequest, "invalid Last-Event-ID")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	backlog, events, unsubscribe := h.subscriber.Subscribe(lastEventID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range backlog {
+		if err := writeRateEvent(w, event); err != nil {
+			h.log.Debug("failed to write currency rate event", "error", err)
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		h.log.Error("streaming is not supported", "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(h.heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := writeRateEvent(w, event); err != nil {
+				h.log.Debug("failed to write currency rate event", "error", err)
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				h.log.Debug("failed to write heartbeat", "error", err)
+				return
+			}
+		}
+
+		if err := rc.Flush(); err != nil {
+			h.log.Debug("failed to flush stream", "error", err)
+			return
+		}
+	}
+}
+
+func parseLastEventID(r *http.Request) (uint64, error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("lastEventId")
+	}
+	if value == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseUint(value, 10, 64)
+}
+
+func writeRateEvent(w http.ResponseWriter, event job.CurrencyRateEvent) error {
+	data, err := json.Marshal(event.Rate)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: rate\ndata: %s\n\n", event.ID, data)
+	return err
+}
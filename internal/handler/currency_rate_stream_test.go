@@ -0,0 +1,21 @@
+package handler
+
+import (
+	"currency-rates-notifier/internal/lib/logger/handler"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCurrencyRateStreamDefaultsHeartbeatInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		h := NewCurrencyRateStreamHandler(stubRateSubscriber{}, interval, slog.New(handler.NewNoOpHandler()))
+		require.Equal(t, defaultHeartbeatInterval, h.heartbeatInterval)
+
+		w := httptest.NewRecorder()
+		require.NotPanics(t, func() { h.StreamCurrencyRates(w, httptest.NewRequest("GET", "/rates/stream", nil)) })
+		require.Contains(t, w.Body.String(), "id: 1")
+	}
+}
@@ -0,0 +1,234 @@
+package handler
+
+import (
+	"context"
+	"currency-rates-notifier/internal/api/monobank"
+	"currency-rates-notifier/internal/lib/currency"
+	"errors"
+	"fmt"
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	wsRequestSubscribe   = "subscribe"
+	wsRequestUnsubscribe = "unsubscribe"
+
+	wsMessageSnapshot = "snapshot"
+	wsMessageUpdate   = "update"
+	wsMessageError    = "error"
+
+	wsReadLimit = 4096
+)
+
+type wsRequest struct {
+	Type  string   `json:"type"`
+	Pairs []string `json:"pairs"`
+}
+
+type wsRateUpdate struct {
+	ID   uint64                `json:"id"`
+	Pair string                `json:"pair"`
+	Rate monobank.CurrencyRate `json:"rate"`
+}
+
+type wsMessage struct {
+	Type   string         `json:"type"`
+	Rates  []wsRateUpdate `json:"rates,omitempty"`
+	Update *wsRateUpdate  `json:"update,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+type CurrencyRateWSHandler struct {
+	hub            *CurrencyRateHub
+	sendBufferSize int
+	writeTimeout   time.Duration
+	originPatterns []string
+	log            *slog.Logger
+}
+
+func NewCurrencyRateWSHandler(hub *CurrencyRateHub, sendBufferSize int, writeTimeout time.Duration, originPatterns []string, log *slog.Logger) *CurrencyRateWSHandler {
+	return &CurrencyRateWSHandler{hub: hub, sendBufferSize: sendBufferSize, writeTimeout: writeTimeout, originPatterns: originPatterns, log: log}
+}
+
+// ServeWS upgrades the connection and lets the client manage per-pair subscriptions:
+//
+//	-> {"type":"subscribe","pairs":["USD/UAH","EUR/UAH"]}
+//	<- {"type":"snapshot","rates":[{"id":1,"pair":"USD/UAH","rate":{...}}]}
+//	<- {"type":"update","update":{"id":7,"pair":"USD/UAH","rate":{...}}}
+//	-> {"type":"unsubscribe","pairs":["EUR/UAH"]}
+//	<- {"type":"error","error":"..."}
+//
+// Clients that do not drain their updates fast enough are disconnected.
+func (h *CurrencyRateWSHandler) ServeWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: h.originPatterns})
+	if err != nil {
+		h.log.Debug("failed to accept websocket connection", "error", err)
+		return
+	}
+	conn.SetReadLimit(wsReadLimit)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	client := &wsClient{
+		conn:     conn,
+		messages: make(chan wsMessage, h.sendBufferSize),
+		pairs:    make(map[currency.Pair]struct{}),
+		cancel:   cancel,
+	}
+	h.hub.register(client)
+	defer h.hub.unregister(client)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h.writeLoop(ctx, client)
+	}()
+
+	err = h.readLoop(ctx, client)
+	cancel()
+	wg.Wait()
+
+	switch {
+	case client.isClosed():
+		h.log.Debug("websocket connection closed by server")
+	case websocket.CloseStatus(err) == websocket.StatusNormalClosure, websocket.CloseStatus(err) == websocket.StatusGoingAway:
+		_ = conn.Close(websocket.StatusNormalClosure, "")
+	default:
+		h.log.Debug("websocket connection closed", "error", err)
+		_ = conn.Close(websocket.StatusInternalError, "")
+	}
+}
+
+func (h *CurrencyRateWSHandler) readLoop(ctx context.Context, client *wsClient) error {
+	for {
+		var req wsRequest
+		if err := wsjson.Read(ctx, client.conn, &req); err != nil {
+			return err
+		}
+
+		if req.Type != wsRequestSubscribe && req.Type != wsRequestUnsubscribe {
+			client.send(wsMessage{Type: wsMessageError, Error: fmt.Sprintf("unknown message type: %q", req.Type)})
+			continue
+		}
+
+		pairs, err := parsePairs(req.Pairs)
+		if err != nil {
+			client.send(wsMessage{Type: wsMessageError, Error: err.Error()})
+			continue
+		}
+
+		if req.Type == wsRequestSubscribe {
+			client.subscribe(pairs)
+			client.send(wsMessage{Type: wsMessageSnapshot, Rates: h.hub.snapshot(pairs)})
+		} else {
+			client.unsubscribe(pairs)
+		}
+	}
+}
+
+func (h *CurrencyRateWSHandler) writeLoop(ctx context.Context, client *wsClient) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message := <-client.messages:
+			writeCtx, cancel := context.WithTimeout(ctx, h.writeTimeout)
+			err := wsjson.Write(writeCtx, client.conn, message)
+			cancel()
+			if err != nil {
+				if !errors.Is(err, context.Canceled) {
+					h.log.Debug("failed to write websocket message", "error", err)
+				}
+				client.cancel()
+				return
+			}
+		}
+	}
+}
+
+func parsePairs(values []string) ([]currency.Pair, error) {
+	if len(values) == 0 {
+		return nil, errors.New("at least one pair is required")
+	}
+
+	pairs := make([]currency.Pair, 0, len(values))
+	for _, value := range values {
+		pair, err := currency.ParsePair(value)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair)
+	}
+
+	return pairs, nil
+}
+
+type wsClient struct {
+	conn     *websocket.Conn
+	messages chan wsMessage
+	cancel   context.CancelFunc
+
+	mu     sync.RWMutex
+	pairs  map[currency.Pair]struct{}
+	closed bool
+}
+
+// send never blocks: a client whose buffer is full is disconnected.
+func (c *wsClient) send(message wsMessage) {
+	select {
+	case c.messages <- message:
+	default:
+		c.close(websocket.StatusPolicyViolation, "client too slow")
+	}
+}
+
+// close starts a server-initiated close handshake without waiting for it to complete.
+func (c *wsClient) close(code websocket.StatusCode, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		go c.conn.Close(code, reason)
+	}
+}
+
+func (c *wsClient) subscribe(pairs []currency.Pair) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, pair := range pairs {
+		c.pairs[pair] = struct{}{}
+	}
+}
+
+func (c *wsClient) unsubscribe(pairs []currency.Pair) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, pair := range pairs {
+		delete(c.pairs, pair)
+	}
+}
+
+func (c *wsClient) isSubscribed(pair currency.Pair) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, ok := c.pairs[pair]
+	return ok
+}
+
+func (c *wsClient) isClosed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.closed
+}
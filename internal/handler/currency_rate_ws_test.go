@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"context"
+	"currency-rates-notifier/internal/api/monobank"
+	"currency-rates-notifier/internal/job"
+	"currency-rates-notifier/internal/lib/currency"
+	"currency-rates-notifier/internal/lib/logger/handler"
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const currencyEUR = 978
+
+type fakeRateSource struct {
+	events chan job.CurrencyRateEvent
+	latest []job.CurrencyRateEvent
+}
+
+func (s *fakeRateSource) Subscribe(uint64) ([]job.CurrencyRateEvent, <-chan job.CurrencyRateEvent, func()) {
+	return nil, s.events, func() {}
+}
+
+func (s *fakeRateSource) Latest() []job.CurrencyRateEvent {
+	return s.latest
+}
+
+func rateEvent(id uint64, base int32) job.CurrencyRateEvent {
+	return job.CurrencyRateEvent{ID: id, Rate: monobank.CurrencyRate{CurrencyCodeA: base, CurrencyCodeB: monobank.CurrencyUAH, Date: int64(id)}}
+}
+
+func dialWS(t *testing.T, ctx context.Context, server *httptest.Server) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.CloseNow() })
+	return conn
+}
+
+func writeWS(t *testing.T, ctx context.Context, conn *websocket.Conn, req wsRequest) {
+	t.Helper()
+	require.NoError(t, wsjson.Write(ctx, conn, req))
+}
+
+func readWS(t *testing.T, ctx context.Context, conn *websocket.Conn) wsMessage {
+	t.Helper()
+	var message wsMessage
+	require.NoError(t, wsjson.Read(ctx, conn, &message))
+	return message
+}
+
+func TestCurrencyRateHubBroadcastsSubscribedPairs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	source := &fakeRateSource{events: make(chan job.CurrencyRateEvent), latest: []job.CurrencyRateEvent{rateEvent(1, monobank.CurrencyUSD), rateEvent(2, currencyEUR)}}
+	log := slog.New(handler.NewNoOpHandler())
+	hub := NewCurrencyRateHub(source, log)
+	go hub.Run(ctx)
+	server := httptest.NewServer(http.HandlerFunc(NewCurrencyRateWSHandler(hub, 8, time.Second, nil, log).ServeWS))
+	defer server.Close()
+	conn := dialWS(t, ctx, server)
+
+	writeWS(t, ctx, conn, wsRequest{Type: wsRequestSubscribe, Pairs: []string{"USD"}})
+	require.Equal(t, wsMessage{Type: wsMessageError, Error: `invalid currency pair: "USD"`}, readWS(t, ctx, conn))
+
+	writeWS(t, ctx, conn, wsRequest{Type: wsRequestSubscribe, Pairs: []string{"USD/UAH"}})
+	snapshot := readWS(t, ctx, conn)
+	require.Equal(t, wsMessageSnapshot, snapshot.Type)
+	require.Len(t, snapshot.Rates, 1)
+	require.Equal(t, "USD/UAH", snapshot.Rates[0].Pair)
+
+	source.events <- rateEvent(3, currencyEUR)
+	source.events <- rateEvent(4, monobank.CurrencyUSD)
+	update := readWS(t, ctx, conn)
+	require.Equal(t, wsMessageUpdate, update.Type)
+	require.Equal(t, uint64(4), update.Update.ID, "updates of other pairs are not sent")
+
+	writeWS(t, ctx, conn, wsRequest{Type: wsRequestUnsubscribe, Pairs: []string{"USD/UAH"}})
+	writeWS(t, ctx, conn, wsRequest{Type: wsRequestSubscribe, Pairs: []string{"EUR/UAH"}})
+	require.Equal(t, wsMessageSnapshot, readWS(t, ctx, conn).Type)
+
+	source.events <- rateEvent(5, monobank.CurrencyUSD)
+	source.events <- rateEvent(6, currencyEUR)
+	update = readWS(t, ctx, conn)
+	require.Equal(t, uint64(6), update.Update.ID, "updates of unsubscribed pairs are not sent")
+	require.Equal(t, "EUR/UAH", update.Update.Pair)
+}
+
+func TestCurrencyRateHubDropsSlowClients(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		require.NoError(t, err)
+		conns <- conn
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	conn := dialWS(t, ctx, server)
+
+	hub := NewCurrencyRateHub(&fakeRateSource{}, slog.New(handler.NewNoOpHandler()))
+	// nothing drains the buffer of this client, as if its connection stalled
+	client := &wsClient{conn: <-conns, messages: make(chan wsMessage, 1), pairs: make(map[currency.Pair]struct{}), cancel: func() {}}
+	client.subscribe([]currency.Pair{monobank.USDToUAH})
+	hub.register(client)
+
+	hub.broadcast(rateEvent(1, monobank.CurrencyUSD))
+	require.False(t, client.isClosed())
+	hub.broadcast(rateEvent(2, monobank.CurrencyUSD))
+	require.True(t, client.isClosed(), "a client with a full buffer is disconnected")
+
+	_, _, err := conn.Read(ctx)
+	require.Equal(t, websocket.StatusPolicyViolation, websocket.CloseStatus(err))
+}
@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"context"
+	"currency-rates-notifier/internal/lib/httputil"
+	"currency-rates-notifier/internal/lib/logger"
+	"currency-rates-notifier/internal/privacy"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const maxEraseFormSize = 4 << 10
+
+type PrivacyService interface {
+	RequestLink(email, action string) error
+	CheckToken(ctx context.Context, token, action string) error
+	Export(ctx context.Context, token string) (privacy.Data, error)
+	Erase(ctx context.Context, token string) error
+}
+
+// PrivacyHandler serves data subject requests: a subscriber asks for a link, which is
+// mailed to them, and uses it to download or erase their data
+type PrivacyHandler struct {
+	service    PrivacyService
+	normalizer EmailNormalizer
+	log        *slog.Logger
+}
+
+func NewPrivacyHandler(service PrivacyService, normalizer EmailNormalizer, log *slog.Logger) *PrivacyHandler {
+	return &PrivacyHandler{service: service, normalizer: normalizer, log: log}
+}
+
+type privacyRequest struct {
+	Email  string `json:"email"`
+	Action string `json:"action"`
+}
+
+type deliveryResponse struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type privacyExportResponse struct {
+	Subscription subscriptionResponse `json:"subscription"`
+	Deliveries   []deliveryResponse   `json:"deliveries"`
+	ExportedAt   time.Time            `json:"exported_at"`
+}
+
+// RequestLink answers 202 whether or not the address is subscribed
+func (h *PrivacyHandler) RequestLink(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context(), h.log)
+
+	var req privacyRequest
+	if status, err := decodeAdminRequest(w, r, &req); err != nil {
+		writeProblem(log, w, r, status, err.Error())
+		return
+	}
+
+	var fieldErrors []httputil.FieldError
+	email, err := h.normalizer.Normalize(r.Context(), req.Email)
+	if err != nil {
+		fieldErrors = append(fieldErrors, httputil.FieldError{Field: "email", Message: err.Error()})
+	}
+	if !privacy.ValidAction(req.Action) {
+		fieldErrors = append(fieldErrors, httputil.FieldError{Field: "action", Message: fmt.Sprintf("action must be %q or %q", privacy.ActionExport, privacy.ActionErase)})
+	}
+	if len(fieldErrors) > 0 {
+		if err := httputil.ValidationError(w, r, fieldErrors); err != nil {
+			log.Error("failed to write a problem", "error", err)
+		}
+		return
+	}
+
+	// the link is mailed later, a full queue is the only failure and does not depend on
+	// the address
+	if err := h.service.RequestLink(email, req.Action); err != nil {
+		log.Warn("failed to queue privacy link", "action", req.Action, "error", err)
+		writeProblem(log, w, r, http.StatusServiceUnavailable, "too many requests are waiting, try again later")
+		return
+	}
+
+	if err := httputil.WriteJSON(w, http.StatusAccepted, privacyRequest{Email: email, Action: req.Action}); err != nil {
+		log.Error("failed to write a privacy request", "error", err)
+	}
+}
+
+func (h *PrivacyHandler) Export(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context(), h.log)
+	setPrivateHeaders(w)
+
+	data, err := h.service.Export(r.Context(), r.URL.Query().Get("token"))
+	if err != nil {
+		h.writeTokenError(w, r, err, "failed to export data")
+		return
+	}
+
+	resp := privacyExportResponse{
+		Subscription: newSubscriptionResponse(data.Subscription),
+		Deliveries:   make([]deliveryResponse, 0, len(data.Deliveries)),
+		ExportedAt:   time.Now().UTC().Truncate(time.Second),
+	}
+	for _, delivery := range data.Deliveries {
+		resp.Deliveries = append(resp.Deliveries, deliveryResponse{Status: delivery.Status, Error: delivery.Error, CreatedAt: delivery.CreatedAt})
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="subscription-data.json"`)
+	if err := httputil.WriteJSON(w, http.StatusOK, resp); err != nil {
+		log.Error("failed to write exported data", "error", err)
+	}
+}
+
+var eraseConfirmation = template.Must(template.New("erase").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Erase your data</title></head>
+<body>
+<p>Erase your currency rate subscription and all data about it? This cannot be undone.</p>
+<form method="post">
+<input type="hidden" name="token" value="{{.}}">
+<button type="submit">Erase my data</button>
+</form>
+</body>
+</html>
+`))
+
+const erasedPage = `<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Data erased</title></head>
+<body><p>Your subscription and all data about it have been erased.</p></body>
+</html>
+`
+
+// ConfirmErase shows a form that erases on submission. The link itself does not erase
+// anything, as mail scanners follow links.
+func (h *PrivacyHandler) ConfirmErase(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context(), h.log)
+	setPrivateHeaders(w)
+
+	token := r.URL.Query().Get("token")
+	if err := h.service.CheckToken(r.Context(), token, privacy.ActionErase); err != nil {
+		h.writeTokenError(w, r, err, "failed to check link")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := eraseConfirmation.Execute(w, token); err != nil {
+		log.Error("failed to write erase confirmation", "error", err)
+	}
+}
+
+func (h *PrivacyHandler) Erase(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context(), h.log)
+	setPrivateHeaders(w)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxEraseFormSize)
+	if err := r.ParseForm(); err != nil {
+		writeProblem(log, w, r, http.StatusBadRequest, "failed to parse form")
+		return
+	}
+
+	if err := h.service.Erase(r.Context(), r.PostForm.Get("token")); err != nil {
+		h.writeTokenError(w, r, err, "failed to erase data")
+		return
+	}
+	log.Info("subscriber erased their data")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := w.Write([]byte(erasedPage)); err != nil {
+		log.Error("failed to write erase result", "error", err)
+	}
+}
+
+func (h *PrivacyHandler) writeTokenError(w http.ResponseWriter, r *http.Request, err error, detail string) {
+	log := logger.FromContext(r.Context(), h.log)
+
+	if errors.Is(err, privacy.InvalidToken) {
+		writeProblem(log, w, r, http.StatusForbidden, err.Error())
+		return
+	}
+	log.Error(detail, "error", err)
+	writeProblem(log, w, r, http.StatusInternalServerError, detail)
+}
+
+// setPrivateHeaders keeps personal data out of caches and the token out of Referer headers
+func setPrivateHeaders(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+}
@@ -0,0 +1,13 @@
+package handler
+
+import (
+	"currency-rates-notifier/internal/lib/httputil"
+	"log/slog"
+	"net/http"
+)
+
+func writeProblem(log *slog.Logger, w http.ResponseWriter, r *http.Request, status int, detail string) {
+	if err := httputil.Error(w, r, status, detail); err != nil {
+		log.Error("failed to write a problem", "error", err)
+	}
+}
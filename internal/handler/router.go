@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"currency-rates-notifier/internal/middleware"
+	"currency-rates-notifier/internal/openapi"
+	"net/http"
+)
+
+const APIPrefix = "/api/v1"
+
+type Handlers struct {
+	CurrencyRate       *CurrencyRateHandler
+	CurrencyRateStream *CurrencyRateStreamHandler
+	CurrencyRateWS     *CurrencyRateWSHandler
+	Subscription       *SubscriptionHandler
+	// Challenge is only set when subscriptions require a proof of work
+	Challenge *ChallengeHandler
+	// Privacy routes are registered when Privacy is set
+	Privacy *PrivacyHandler
+	// Bounce is only set when a bounce webhook token is configured
+	Bounce *BounceHandler
+
+	// SubscribeMiddlewares wrap the endpoints that take an email address from anyone,
+	// subscribe and privacy link requests, e.g. with rate limiting
+	SubscribeMiddlewares []middleware.Middleware
+
+	// Admin routes are registered when Admin is set. AdminRead and AdminWrite
+	// authorise the routes that need the respective scope.
+	Admin      *AdminHandler
+	AdminRead  middleware.Middleware
+	AdminWrite middleware.Middleware
+}
+
+type route struct {
+	method  string
+	path    string
+	handler http.Handler
+	legacy  bool
+}
+
+// NewRouter registers every route under APIPrefix. Routes that existed before
+// versioning are also served at their original unprefixed paths.
+func NewRouter(h Handlers) *http.ServeMux {
+	subscribe := middleware.Chain(http.HandlerFunc(h.Subscription.Subscribe), h.SubscribeMiddlewares...)
+
+	routes := []route{
+		{method: http.MethodGet, path: "/rate", handler: http.HandlerFunc(h.CurrencyRate.GetCurrencyRate), legacy: true},
+		{method: http.MethodGet, path: "/rates/stream", handler: http.HandlerFunc(h.CurrencyRateStream.StreamCurrencyRates), legacy: true},
+		{method: http.MethodGet, path: "/ws", handler: http.HandlerFunc(h.CurrencyRateWS.ServeWS), legacy: true},
+		{method: http.MethodPost, path: "/subscribe", handler: subscribe, legacy: true},
+		{method: http.MethodGet, path: "/openapi.json", handler: http.HandlerFunc(openapi.ServeSpec)},
+	}
+	if h.Challenge != nil {
+		routes = append(routes, route{method: http.MethodGet, path: "/subscribe/challenge", handler: http.HandlerFunc(h.Challenge.GetChallenge)})
+	}
+	if h.Privacy != nil {
+		routes = append(routes,
+			route{method: http.MethodPost, path: "/privacy/requests", handler: middleware.Chain(http.HandlerFunc(h.Privacy.RequestLink), h.SubscribeMiddlewares...)},
+			route{method: http.MethodGet, path: "/privacy/export", handler: http.HandlerFunc(h.Privacy.Export)},
+			route{method: http.MethodGet, path: "/privacy/erase", handler: http.HandlerFunc(h.Privacy.ConfirmErase)},
+			route{method: http.MethodPost, path: "/privacy/erase", handler: http.HandlerFunc(h.Privacy.Erase)},
+		)
+	}
+	if h.Bounce != nil {
+		routes = append(routes, route{method: http.MethodPost, path: "/bounces/webhook", handler: http.HandlerFunc(h.Bounce.Webhook)})
+	}
+	if h.Admin != nil {
+		read := func(f http.HandlerFunc) http.Handler { return middleware.Chain(f, h.AdminRead) }
+		write := func(f http.HandlerFunc) http.Handler { return middleware.Chain(f, h.AdminWrite) }
+
+		routes = append(routes,
+			route{method: http.MethodGet, path: "/admin/subscribers", handler: read(h.Admin.ListSubscribers)},
+			route{method: http.MethodGet, path: "/admin/subscribers/export", handler: read(h.Admin.ExportSubscribers)},
+			route{method: http.MethodPost, path: "/admin/subscribers/import", handler: write(h.Admin.ImportSubscribers)},
+			route{method: http.MethodGet, path: "/admin/subscribers/{id}", handler: read(h.Admin.GetSubscriber)},
+			route{method: http.MethodPatch, path: "/admin/subscribers/{id}", handler: write(h.Admin.UpdateSubscriber)},
+			route{method: http.MethodDelete, path: "/admin/subscribers/{id}", handler: write(h.Admin.DeleteSubscriber)},
+			route{method: http.MethodGet, path: "/admin/audit", handler: read(h.Admin.ListAuditEntries)},
+			route{method: http.MethodGet, path: "/admin/api-keys", handler: write(h.Admin.ListAPIKeys)},
+			route{method: http.MethodPost, path: "/admin/api-keys", handler: write(h.Admin.CreateAPIKey)},
+			route{method: http.MethodDelete, path: "/admin/api-keys/{id}", handler: write(h.Admin.DeleteAPIKey)},
+		)
+	}
+
+	router := http.NewServeMux()
+	for _, route := range routes {
+		router.Handle(route.method+" "+APIPrefix+route.path, route.handler)
+		if route.legacy {
+			router.Handle(route.method+" "+route.path, route.handler)
+		}
+	}
+
+	return router
+}
@@ -1,39 +1,217 @@
 package handler
 
 import (
+	"context"
+	"currency-rates-notifier/internal/api/monobank"
+	"currency-rates-notifier/internal/lib/currency"
+	"currency-rates-notifier/internal/lib/httputil"
+	"currency-rates-notifier/internal/lib/logger"
+	"currency-rates-notifier/internal/lib/verifier"
 	"currency-rates-notifier/internal/storage"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
+	"mime"
 	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
-type EmailSaver interface {
-	SaveEmail(email string) error
+const maxSubscribeBodySize = 1 << 20
+
+type SubscriptionSaver interface {
+	SaveSubscription(ctx context.Context, subscription storage.Subscription) (storage.Subscription, error)
+}
+
+type EmailNormalizer interface {
+	Normalize(ctx context.Context, email string) (string, error)
+}
+
+type RequestVerifier interface {
+	Verify(ctx context.Context, r *http.Request) error
 }
 
 type SubscriptionHandler struct {
-	saver EmailSaver
-	log   *slog.Logger
+	saver           SubscriptionSaver
+	normalizer      EmailNormalizer
+	verifier        RequestVerifier
+	uniformResponse atomic.Bool
+	log             *slog.Logger
+}
+
+// NewSubscriptionHandler creates a handler that skips verification when verifier is nil.
+// With uniformResponse, new and already subscribed addresses get the same 202 reply,
+// so the endpoint cannot be used to find out who is subscribed.
+func NewSubscriptionHandler(saver SubscriptionSaver, normalizer EmailNormalizer, verifier RequestVerifier, uniformResponse bool, log *slog.Logger) *SubscriptionHandler {
+	h := &SubscriptionHandler{saver: saver, normalizer: normalizer, verifier: verifier, log: log}
+	h.uniformResponse.Store(uniformResponse)
+	return h
+}
+
+func (h *SubscriptionHandler) SetUniformResponse(uniformResponse bool) {
+	h.uniformResponse.Store(uniformResponse)
+}
+
+type subscribeRequest struct {
+	Email string   `json:"email"`
+	Pairs []string `json:"pairs"`
+}
+
+type subscriptionResponse struct {
+	ID        int64     `json:"id"`
+	Email     string    `json:"email"`
+	Status    string    `json:"status"`
+	Pairs     []string  `json:"pairs"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
-func NewSubscriptionHandler(saver EmailSaver, log *slog.Logger) *SubscriptionHandler {
-	return &SubscriptionHandler{saver: saver, log: log}
+type subscriptionAcceptedResponse struct {
+	Email string   `json:"email"`
+	Pairs []string `json:"pairs"`
 }
 
+func newSubscriptionResponse(subscription storage.Subscription) subscriptionResponse {
+	return subscriptionResponse{
+		ID:        subscription.ID,
+		Email:     subscription.Email,
+		Status:    subscription.Status,
+		Pairs:     subscription.Pairs,
+		CreatedAt: subscription.CreatedAt,
+	}
+}
+
+// Subscribe accepts JSON as well as urlencoded or multipart form bodies. Form clients
+// pass several pairs by repeating the "pairs" field.
 func (h *SubscriptionHandler) Subscribe(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context(), h.log)
 
-	err := r.ParseForm()
+	if h.verifier != nil {
+		if err := h.verifier.Verify(r.Context(), r); err != nil {
+			if errors.Is(err, verifier.Failed) {
+				writeProblem(log, w, r, http.StatusForbidden, err.Error())
+				return
+			}
+			log.Error("failed to verify request", "error", err)
+			writeProblem(log, w, r, http.StatusServiceUnavailable, "verification is temporarily unavailable")
+			return
+		}
+	}
+
+	req, status, err := decodeSubscribeRequest(w, r)
 	if err != nil {
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		writeProblem(log, w, r, status, err.Error())
 		return
 	}
 
-	email := r.FormValue("email")
+	subscription, fieldErrors := h.validate(r.Context(), req)
+	if len(fieldErrors) > 0 {
+		if err := httputil.ValidationError(w, r, fieldErrors); err != nil {
+			log.Error("failed to write a problem", "error", err)
+		}
+		return
+	}
 
-	err = h.saver.SaveEmail(email)
+	saved, err := h.saver.SaveSubscription(r.Context(), subscription)
+	if h.uniformResponse.Load() && (err == nil || errors.Is(err, storage.EmailExists)) {
+		accepted := subscriptionAcceptedResponse{Email: subscription.Email, Pairs: subscription.Pairs}
+		if err := httputil.WriteJSON(w, http.StatusAccepted, accepted); err != nil {
+			log.Error("failed to write a subscription", "error", err)
+		}
+		return
+	}
 	if errors.Is(err, storage.EmailExists) {
-		w.WriteHeader(http.StatusConflict)
-
+		writeProblem(log, w, r, http.StatusConflict, "email is already subscribed")
+		return
+	}
+	if err != nil {
+		log.Error("failed to save subscription", "error", err)
+		writeProblem(log, w, r, http.StatusInternalServerError, "failed to save subscription")
 		return
 	}
+
+	// the subscription is served by the admin API only, as anyone could read addresses by id
+	w.Header().Set("Location", fmt.Sprintf("%s/admin/subscribers/%d", APIPrefix, saved.ID))
+	if err := httputil.WriteJSON(w, http.StatusCreated, newSubscriptionResponse(saved)); err != nil {
+		log.Error("failed to write a subscription", "error", err)
+	}
+}
+
+func decodeSubscribeRequest(w http.ResponseWriter, r *http.Request) (subscribeRequest, int, error) {
+	var req subscribeRequest
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxSubscribeBodySize)
+
+	// clients of the first version of the API send the address as a form value without a
+	// Content-Type, or in the query
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		req.Email = r.FormValue("email")
+		req.Pairs = r.Form["pairs"]
+		return req, 0, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return req, http.StatusUnsupportedMediaType, errors.New("invalid Content-Type")
+	}
+
+	switch mediaType {
+	case "application/json":
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			return req, http.StatusBadRequest, fmt.Errorf("failed to parse JSON body: %w", err)
+		}
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		if err := r.ParseMultipartForm(maxSubscribeBodySize); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			return req, http.StatusBadRequest, errors.New("failed to parse form")
+		}
+		req.Email = r.FormValue("email")
+		req.Pairs = r.Form["pairs"]
+	default:
+		return req, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported Content-Type %q", mediaType)
+	}
+
+	return req, 0, nil
+}
+
+func (h *SubscriptionHandler) validate(ctx context.Context, req subscribeRequest) (storage.Subscription, []httputil.FieldError) {
+	var fieldErrors []httputil.FieldError
+
+	email, err := h.normalizer.Normalize(ctx, req.Email)
+	if err != nil {
+		fieldErrors = append(fieldErrors, httputil.FieldError{Field: "email", Message: err.Error()})
+	}
+
+	pairs := []string{monobank.USDToUAH.String()}
+	if len(req.Pairs) > 0 {
+		var pairErrors []httputil.FieldError
+		pairs, pairErrors = normalizePairs(req.Pairs)
+		fieldErrors = append(fieldErrors, pairErrors...)
+	}
+
+	return storage.Subscription{Email: email, Status: storage.StatusActive, Pairs: pairs}, fieldErrors
+}
+
+// normalizePairs parses pairs into their canonical form, dropping duplicates
+func normalizePairs(values []string) ([]string, []httputil.FieldError) {
+	var fieldErrors []httputil.FieldError
+
+	pairs := make([]string, 0, len(values))
+	seen := make(map[string]struct{}, len(values))
+	for i, value := range values {
+		pair, err := currency.ParsePair(strings.TrimSpace(value))
+		if err != nil {
+			fieldErrors = append(fieldErrors, httputil.FieldError{Field: fmt.Sprintf("pairs[%d]", i), Message: err.Error()})
+			continue
+		}
+		if _, ok := seen[pair.String()]; !ok {
+			seen[pair.String()] = struct{}{}
+			pairs = append(pairs, pair.String())
+		}
+	}
+
+	return pairs, fieldErrors
 }
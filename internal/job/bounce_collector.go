@@ -0,0 +1,74 @@
+package job
+
+import (
+	"context"
+	"currency-rates-notifier/internal/bounce"
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+)
+
+type BounceProcessor interface {
+	Process(ctx context.Context, events []bounce.Event) (bounce.Summary, error)
+}
+
+// BounceCollector periodically reads the bounce mailbox and processes the reports in it
+type BounceCollector struct {
+	mailbox   bounce.Mailbox
+	processor BounceProcessor
+	interval  time.Duration
+	log       *slog.Logger
+}
+
+func NewBounceCollector(mailbox bounce.Mailbox, processor BounceProcessor, interval time.Duration, log *slog.Logger) *BounceCollector {
+	return &BounceCollector{mailbox: mailbox, processor: processor, interval: interval, log: log}
+}
+
+// Run collects until ctx is cancelled
+func (c *BounceCollector) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	c.Collect(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Collect(ctx)
+		}
+	}
+}
+
+// Collect processes the new messages of the mailbox. Messages that are not reports or
+// cannot be parsed are logged and marked processed, they would fail again. A storage
+// failure leaves the message for the next run.
+func (c *BounceCollector) Collect(ctx context.Context) {
+	var summary bounce.Summary
+	var ignored int
+
+	err := c.mailbox.Drain(ctx, func(message io.Reader) error {
+		events, err := bounce.ParseMessage(message)
+		if errors.Is(err, bounce.NotReport) {
+			ignored++
+			return nil
+		}
+		if err != nil {
+			c.log.Warn("failed to parse bounce report", "error", err)
+			ignored++
+			return nil
+		}
+
+		processed, err := c.processor.Process(ctx, events)
+		summary.Add(processed)
+		return err
+	})
+	if err != nil {
+		c.log.Error("failed to collect bounces", "error", err)
+	}
+
+	if summary != (bounce.Summary{}) || ignored > 0 {
+		c.log.Info("Bounces collected.", "hard", summary.Hard, "soft", summary.Soft, "complaints", summary.Complaints, "suppressed", summary.Suppressed, "ignored", ignored)
+	}
+}
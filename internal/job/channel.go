@@ -0,0 +1,93 @@
+package job
+
+import (
+	"context"
+	"currency-rates-notifier/internal/api/monobank"
+	"currency-rates-notifier/internal/api/slack"
+	"currency-rates-notifier/internal/api/teams"
+	"strconv"
+)
+
+// Notification is what CurrencyRateNotifier renders once per run and hands to every channel.
+type Notification struct {
+	Subject string
+	Text    string
+	Rate    monobank.CurrencyRate
+}
+
+// Channel delivers a notification to a destination other than subscriber emails.
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, notification Notification) error
+}
+
+type SlackPoster interface {
+	PostMessage(ctx context.Context, message slack.Message) error
+}
+
+type SlackChannel struct {
+	poster SlackPoster
+}
+
+func NewSlackChannel(poster SlackPoster) *SlackChannel {
+	return &SlackChannel{poster: poster}
+}
+
+func (c *SlackChannel) Name() string {
+	return "slack"
+}
+
+// Send renders the notification as Block Kit
+func (c *SlackChannel) Send(ctx context.Context, notification Notification) error {
+	rate := notification.Rate
+
+	return c.poster.PostMessage(ctx, slack.Message{
+		Text: notification.Text,
+		Blocks: []slack.Block{
+			{Type: "header", Text: &slack.Text{Type: "plain_text", Text: notification.Subject}},
+			{Type: "section", Text: &slack.Text{Type: "mrkdwn", Text: notification.Text}},
+			{Type: "section", Fields: []slack.Text{
+				{Type: "mrkdwn", Text: "*Pair*\n" + rate.Pair().String()},
+				{Type: "mrkdwn", Text: "*Sell*\n" + formatRate(rate.RateSell)},
+				{Type: "mrkdwn", Text: "*Buy*\n" + formatRate(rate.RateBuy)},
+			}},
+			{Type: "context", Elements: []slack.Text{{Type: "mrkdwn", Text: "Updated " + rate.FormattedDate}}},
+		},
+	})
+}
+
+type TeamsPoster interface {
+	PostMessage(ctx context.Context, message teams.Message) error
+}
+
+type TeamsChannel struct {
+	poster TeamsPoster
+}
+
+func NewTeamsChannel(poster TeamsPoster) *TeamsChannel {
+	return &TeamsChannel{poster: poster}
+}
+
+func (c *TeamsChannel) Name() string {
+	return "teams"
+}
+
+// Send renders the notification as an Adaptive Card
+func (c *TeamsChannel) Send(ctx context.Context, notification Notification) error {
+	rate := notification.Rate
+
+	return c.poster.PostMessage(ctx, teams.NewCardMessage(
+		teams.Element{Type: "TextBlock", Text: notification.Subject, Weight: "Bolder", Size: "Medium", Wrap: true},
+		teams.Element{Type: "TextBlock", Text: notification.Text, Wrap: true},
+		teams.Element{Type: "FactSet", Facts: []teams.Fact{
+			{Title: "Pair", Value: rate.Pair().String()},
+			{Title: "Sell", Value: formatRate(rate.RateSell)},
+			{Title: "Buy", Value: formatRate(rate.RateBuy)},
+			{Title: "Updated", Value: rate.FormattedDate},
+		}},
+	))
+}
+
+func formatRate(rate float64) string {
+	return strconv.FormatFloat(rate, 'f', -1, 64)
+}
@@ -1,87 +1,375 @@
 package job
 
 import (
+	"context"
 	"currency-rates-notifier/internal/api/monobank"
 	"currency-rates-notifier/internal/config"
+	"currency-rates-notifier/internal/storage"
+	"errors"
 	"fmt"
 	"github.com/wneessen/go-mail"
+	"iter"
 	"log/slog"
 	"math/rand"
+	"strings"
+	"sync/atomic"
 	"text/template"
 	"time"
 )
 
-type CurrencyRateFetcher interface {
-	//todo: return generic currency rate
-	FetchUSDToUAHCurrencyRate() (monobank.CurrencyRate, error)
+// sendBatchSize is both the number of subscriptions read from storage at a time and the
+// number of messages handed to the SMTP client at once, which bounds the memory a mailing
+// takes regardless of the number of subscribers
+const sendBatchSize = 200
+
+type SubscriptionFinder interface {
+	GetActiveSubscriptionsAfter(ctx context.Context, afterID int64, limit int) ([]storage.Subscription, error)
+}
+
+type SuppressionChecker interface {
+	SuppressedEmails(ctx context.Context, emails []string) (map[string]struct{}, error)
 }
 
-type EmailFinder interface {
-	GetAllEmails() ([]string, error)
+type DeliveryRecorder interface {
+	SaveDeliveries(ctx context.Context, deliveries []storage.Delivery) error
+}
+
+// MailSender is implemented by *mail.Client
+type MailSender interface {
+	DialWithContext(ctx context.Context) error
+	Send(messages ...*mail.Msg) error
+	Close() error
 }
 
 type CurrencyRateNotifier struct {
-	fetcher     CurrencyRateFetcher
-	finder      EmailFinder
-	emailClient *mail.Client //todo: create abstraction
-	log         *slog.Logger
-	cfg         config.Email
+	fetcher      CurrencyRatesFetcher
+	finder       SubscriptionFinder
+	suppressions SuppressionChecker
+	deliveries   DeliveryRecorder
+	settings     atomic.Pointer[NotifierSettings]
+	log          *slog.Logger
+	batchSize    int
+}
+
+// NotifierSettings can be replaced while the notifier runs, a mailing in progress
+// finishes with the settings it started with
+type NotifierSettings struct {
+	Email       config.Email
+	EmailClient MailSender
+	Channels    []Channel
+}
+
+func NewCurrencyRateNotifier(fetcher CurrencyRatesFetcher, finder SubscriptionFinder, suppressions SuppressionChecker, deliveries DeliveryRecorder, emailClient MailSender, channels []Channel, log *slog.Logger, cfg config.Email) *CurrencyRateNotifier {
+	n := &CurrencyRateNotifier{fetcher: fetcher, finder: finder, suppressions: suppressions, deliveries: deliveries, log: log, batchSize: sendBatchSize}
+	n.Reconfigure(NotifierSettings{Email: cfg, EmailClient: emailClient, Channels: channels})
+	return n
+}
+
+func (n *CurrencyRateNotifier) Reconfigure(settings NotifierSettings) {
+	n.settings.Store(&settings)
 }
 
-func NewCurrencyRateNotifier(fetcher CurrencyRateFetcher, finder EmailFinder, emailClient *mail.Client, log *slog.Logger, cfg config.Email) *CurrencyRateNotifier {
-	return &CurrencyRateNotifier{fetcher: fetcher, finder: finder, emailClient: emailClient, log: log, cfg: cfg}
+// Notify fetches currency rates once and delivers them to subscribers and all channels.
+// Channels receive the USD/UAH rate. Nothing is sent if rates or the template are
+// unavailable. Cancelling ctx aborts an in-flight delivery. The error tells what could
+// not be delivered, the deliveries that could have been made anyway.
+func (n *CurrencyRateNotifier) Notify(ctx context.Context) error {
+	settings := n.settings.Load()
+	rates, textTpl, err := n.prepare(ctx, settings)
+	if err != nil {
+		return err
+	}
+
+	return errors.Join(
+		n.sendEmailToSubscribers(ctx, settings, rates, textTpl, storage.ActiveSubscriptions(ctx, n.finder, n.batchSize)),
+		n.sendToChannels(ctx, settings, rates, textTpl),
+	)
 }
 
-func (n *CurrencyRateNotifier) SendEmailToSubscribers() {
-	rate, err := n.fetcher.FetchUSDToUAHCurrencyRate()
+// NotifySubscribers delivers the current rates to the given subscriptions only, e.g. to
+// retry failed deliveries. Channels are not notified.
+func (n *CurrencyRateNotifier) NotifySubscribers(ctx context.Context, subscriptions []storage.Subscription) error {
+	settings := n.settings.Load()
+	rates, textTpl, err := n.prepare(ctx, settings)
 	if err != nil {
-		n.log.Error("failed fetch currency rate", "error", err)
+		return err
 	}
 
-	emails, err := n.finder.GetAllEmails()
+	return n.sendEmailToSubscribers(ctx, settings, rates, textTpl, func(yield func(storage.Subscription, error) bool) {
+		for _, subscription := range subscriptions {
+			if !yield(subscription, nil) {
+				return
+			}
+		}
+	})
+}
+
+// prepare fetches the rates by pair and parses the template
+func (n *CurrencyRateNotifier) prepare(ctx context.Context, settings *NotifierSettings) (map[string]monobank.CurrencyRate, *template.Template, error) {
+	fetched, err := n.fetcher.FetchCurrencyRates(ctx)
 	if err != nil {
-		n.log.Error("failed to get emails", "error", err)
+		return nil, nil, fmt.Errorf("fetch currency rates: %w", err)
 	}
 
-	textTpl, err := template.New("texttpl").Parse(n.cfg.MessageTemplate)
+	rates := make(map[string]monobank.CurrencyRate, len(fetched))
+	for _, rate := range fetched {
+		rates[rate.Pair().String()] = rate
+	}
+
+	textTpl, err := template.New("texttpl").Parse(settings.Email.MessageTemplate)
 	if err != nil {
-		n.log.Error("failed to parse text template", "error", err)
-		return
+		return nil, nil, fmt.Errorf("parse text template: %w", err)
 	}
 
-	var messages []*mail.Msg
-	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return rates, textTpl, nil
+}
+
+func (n *CurrencyRateNotifier) sendToChannels(ctx context.Context, settings *NotifierSettings, rates map[string]monobank.CurrencyRate, textTpl *template.Template) error {
+	if len(settings.Channels) == 0 {
+		return nil
+	}
+
+	rate, ok := rates[monobank.USDToUAH.String()]
+	if !ok {
+		return fmt.Errorf("find currency rate %s for channels", monobank.USDToUAH)
+	}
 
-	for _, email := range emails {
-		randNum := random.Int31()
-		message := mail.NewMsg()
-		if err := message.EnvelopeFrom(fmt.Sprintf(n.cfg.EnvelopeFrom, randNum)); err != nil {
-			n.log.Error("failed to set ENVELOPE FROM address", "error", err)
+	var text strings.Builder
+	if err := textTpl.Execute(&text, rate); err != nil {
+		return fmt.Errorf("render text template for channels: %w", err)
+	}
+
+	notification := Notification{Subject: settings.Email.Subject, Text: text.String(), Rate: rate}
+	var failed int
+	for _, channel := range settings.Channels {
+		if err := channel.Send(ctx, notification); err != nil {
+			n.log.Error("failed to deliver notification", "channel", channel.Name(), "error", err)
+			failed++
 			continue
 		}
-		if err := message.From(n.cfg.From); err != nil {
-			n.log.Error("failed to set formatted FROM address", "error", err)
-			continue
+		n.log.Info("Notification successfully delivered.", "channel", channel.Name())
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d channels failed", failed, len(settings.Channels))
+	}
+	return nil
+}
+
+// sendEmailToSubscribers streams subscriptions, usually the active ones from storage, and
+// sends them in batches over one SMTP connection, so only a batch of messages is held in
+// memory. Suppressed addresses are skipped. The outcome of every message is written to the
+// delivery log. The error counts the messages that were not delivered.
+func (n *CurrencyRateNotifier) sendEmailToSubscribers(ctx context.Context, settings *NotifierSettings, rates map[string]monobank.CurrencyRate, textTpl *template.Template, subscriptions iter.Seq2[storage.Subscription, error]) error {
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	// one body per combination of pairs, there are few of them compared to subscribers
+	bodies := make(map[string]string)
+
+	var (
+		batch      = make([]storage.Subscription, 0, n.batchSize)
+		messages   = make([]*mail.Msg, 0, n.batchSize)
+		recipients = make([]int64, 0, n.batchSize)
+		connected  bool
+		sent       int
+		failed     int
+		skipped    int
+	)
+	flush := func() {
+		if len(batch) == 0 {
+			return
 		}
-		if err := message.AddTo(email); err != nil {
-			n.log.Error("failed to set formatted TO address", "error", err)
-			continue
+		defer func() {
+			batch, messages, recipients = batch[:0], messages[:0], recipients[:0]
+		}()
+
+		suppressed, err := n.suppressedEmails(ctx, batch)
+		if err != nil {
+			n.log.Error("failed to check suppressed addresses", "error", err)
+			errs := make([]error, len(batch))
+			for i, subscription := range batch {
+				recipients = append(recipients, subscription.ID)
+				errs[i] = fmt.Errorf("check suppressed addresses: %w", err)
+			}
+			n.recordDeliveries(ctx, recipients, errs)
+			failed += len(batch)
+			return
 		}
-		message.SetMessageID()
-		message.SetDate()
-		message.SetBulk()
-		message.Subject(n.cfg.Subject)
-		if err := message.SetBodyTextTemplate(textTpl, rate); err != nil {
-			n.log.Error("failed to add text template to mail body", "error", err)
-			continue
+		for _, subscription := range batch {
+			if _, ok := suppressed[subscription.Email]; ok {
+				skipped++
+				continue
+			}
+
+			key := strings.Join(subscription.Pairs, ",")
+			body, ok := bodies[key]
+			if !ok {
+				body = n.renderBody(textTpl, rates, subscription.Pairs)
+				bodies[key] = body
+			}
+			if body == "" {
+				continue
+			}
+
+			message, err := newMessage(settings.Email, random, subscription.Email, body)
+			if err != nil {
+				n.log.Error("failed to create message", "error", err)
+				failed++
+				continue
+			}
+			messages = append(messages, message)
+			recipients = append(recipients, subscription.ID)
+		}
+		if len(messages) == 0 {
+			return
 		}
 
-		messages = append(messages, message)
+		errs := messageErrors(messages, sendBatch(ctx, settings.EmailClient, messages, &connected))
+		for i, err := range errs {
+			if err != nil {
+				n.log.Error("failed to deliver mail", "subscription_id", recipients[i], "error", err)
+				failed++
+			} else {
+				sent++
+			}
+		}
+		n.recordDeliveries(ctx, recipients, errs)
 	}
 
-	if err := n.emailClient.DialAndSend(messages...); err != nil {
-		n.log.Error("failed to deliver mail", "error", err)
-		return
+	var listErr error
+	for subscription, err := range subscriptions {
+		if err != nil {
+			listErr = fmt.Errorf("get subscriptions: %w", err)
+			break
+		}
+
+		batch = append(batch, subscription)
+		if len(batch) == n.batchSize {
+			flush()
+		}
 	}
-	n.log.Info("Bulk mailing successfully delivered.")
+	flush()
+
+	if connected {
+		if err := settings.EmailClient.Close(); err != nil {
+			n.log.Error("failed to close the mail connection", "error", err)
+		}
+	}
+
+	if skipped > 0 {
+		n.log.Info("Skipped suppressed addresses.", "skipped", skipped)
+	}
+	switch {
+	case listErr != nil:
+		return fmt.Errorf("%w, %d messages sent and %d failed before", listErr, sent, failed)
+	case sent == 0 && failed == 0:
+		n.log.Info("No subscribers to notify.")
+	case failed > 0:
+		return fmt.Errorf("%d of %d messages failed", failed, sent+failed)
+	default:
+		n.log.Info("Bulk mailing successfully delivered.", "sent", sent)
+	}
+	return nil
+}
+
+func (n *CurrencyRateNotifier) suppressedEmails(ctx context.Context, subscriptions []storage.Subscription) (map[string]struct{}, error) {
+	emails := make([]string, len(subscriptions))
+	for i, subscription := range subscriptions {
+		emails[i] = subscription.Email
+	}
+	return n.suppressions.SuppressedEmails(ctx, emails)
+}
+
+func sendBatch(ctx context.Context, emailClient MailSender, messages []*mail.Msg, connected *bool) error {
+	if !*connected {
+		if err := emailClient.DialWithContext(ctx); err != nil {
+			return fmt.Errorf("connect to the mail server: %w", err)
+		}
+		*connected = true
+	}
+	if err := emailClient.Send(messages...); err != nil {
+		// the connection may be broken, the next batch reconnects
+		emailClient.Close()
+		*connected = false
+		return err
+	}
+	return nil
+}
+
+// messageErrors returns the outcome of every message of a batch. The SMTP client marks
+// the messages the server rejected, the others were delivered. If it marked none, the
+// batch failed as a whole, e.g. because the server could not be reached.
+func messageErrors(messages []*mail.Msg, sendErr error) []error {
+	errs := make([]error, len(messages))
+	if sendErr == nil {
+		return errs
+	}
+
+	var marked bool
+	for i, message := range messages {
+		if message.HasSendError() {
+			errs[i] = message.SendError()
+			marked = true
+		}
+	}
+	if !marked {
+		for i := range errs {
+			errs[i] = sendErr
+		}
+	}
+	return errs
+}
+
+func (n *CurrencyRateNotifier) recordDeliveries(ctx context.Context, subscriptionIDs []int64, errs []error) {
+	deliveries := make([]storage.Delivery, len(subscriptionIDs))
+	for i, id := range subscriptionIDs {
+		deliveries[i] = storage.Delivery{SubscriptionID: id, Status: storage.DeliverySent}
+		if errs[i] != nil {
+			deliveries[i].Status, deliveries[i].Error = storage.DeliveryFailed, errs[i].Error()
+		}
+	}
+
+	if err := n.deliveries.SaveDeliveries(ctx, deliveries); err != nil {
+		n.log.Error("failed to save delivery log", "error", err)
+	}
+}
+
+func newMessage(cfg config.Email, random *rand.Rand, to, body string) (*mail.Msg, error) {
+	message := mail.NewMsg()
+	if err := message.EnvelopeFrom(fmt.Sprintf(cfg.EnvelopeFrom, random.Int31())); err != nil {
+		return nil, fmt.Errorf("set ENVELOPE FROM address: %w", err)
+	}
+	if err := message.From(cfg.From); err != nil {
+		return nil, fmt.Errorf("set formatted FROM address: %w", err)
+	}
+	if err := message.AddTo(to); err != nil {
+		return nil, fmt.Errorf("set formatted TO address: %w", err)
+	}
+	message.SetMessageID()
+	message.SetDate()
+	message.SetBulk()
+	message.Subject(cfg.Subject)
+	message.SetBodyString(mail.TypeTextPlain, body)
+
+	return message, nil
+}
+
+// renderBody renders the template once per subscribed pair, one pair per line.
+// Pairs that Monobank no longer quotes are skipped.
+func (n *CurrencyRateNotifier) renderBody(textTpl *template.Template, rates map[string]monobank.CurrencyRate, pairs []string) string {
+	lines := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		rate, ok := rates[pair]
+		if !ok {
+			n.log.Warn("failed to find currency rate", "pair", pair)
+			continue
+		}
+
+		var line strings.Builder
+		if err := textTpl.Execute(&line, rate); err != nil {
+			n.log.Error("failed to render text template", "pair", pair, "error", err)
+			continue
+		}
+		lines = append(lines, line.String())
+	}
+
+	return strings.Join(lines, "\n")
 }
@@ -1,9 +1,234 @@
 package job
 
 import (
+	"bufio"
+	"context"
+	"currency-rates-notifier/internal/api/monobank"
+	"currency-rates-notifier/internal/config"
+	"currency-rates-notifier/internal/lib/logger/handler"
+	"currency-rates-notifier/internal/storage"
+	"currency-rates-notifier/internal/storage/memory"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"github.com/wneessen/go-mail"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 )
 
+type recordingMailSender struct {
+	dials   int
+	batches []int
+	to      []string
+	err     error
+}
+
+func (s *recordingMailSender) DialWithContext(_ context.Context) error {
+	s.dials++
+	return nil
+}
+
+func (s *recordingMailSender) Send(messages ...*mail.Msg) error {
+	s.batches = append(s.batches, len(messages))
+	for _, message := range messages {
+		s.to = append(s.to, message.GetToString()...)
+	}
+	return s.err
+}
+
+func (s *recordingMailSender) Close() error {
+	return nil
+}
+
+type failingSuppressionChecker struct{}
+
+func (failingSuppressionChecker) SuppressedEmails(context.Context, []string) (map[string]struct{}, error) {
+	return nil, errors.New("database is locked")
+}
+
+// startSMTPServer accepts mail for every recipient except the rejected ones
+func startSMTPServer(t *testing.T, rejected ...string) (string, int) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, rejected)
+		}
+	}()
+
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+	portNumber, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	return host, portNumber
+}
+
+func serveSMTP(conn net.Conn, rejected []string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+
+	reply("220 localhost ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			address := strings.ToLower(strings.TrimSpace(line[len("RCPT TO:"):]))
+			if slices.ContainsFunc(rejected, func(email string) bool { return strings.Contains(address, email) }) {
+				reply("550 5.1.1 mailbox unavailable")
+			} else {
+				reply("250 OK")
+			}
+		case command == "DATA":
+			reply("354 go ahead")
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+			}
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
 func TestSendEmailToSubscribers(t *testing.T) {
-	t.Skip("Not implemented")
+	ctx := context.Background()
+	store := memory.New()
+
+	var want []string
+	for n := range 25 {
+		subscription := storage.Subscription{Email: fmt.Sprintf("user%d@example.com", n), Status: storage.StatusActive, Pairs: []string{"USD/UAH"}}
+		switch n % 5 {
+		case 1:
+			subscription.Status = storage.StatusUnsubscribed
+		case 2:
+			subscription.Pairs = []string{"PLN/UAH"}
+		case 3:
+			require.NoError(t, store.SuppressEmail(ctx, subscription.Email, storage.SuppressionBounced))
+		default:
+			want = append(want, fmt.Sprintf("<%s>", subscription.Email))
+		}
+		_, err := store.SaveSubscription(ctx, subscription)
+		require.NoError(t, err)
+	}
+
+	fetcher := &stubRatesFetcher{rates: []monobank.CurrencyRate{{CurrencyCodeA: monobank.CurrencyUSD, CurrencyCodeB: monobank.CurrencyUAH, RateSell: 41.5, RateBuy: 41}}}
+	sender := &recordingMailSender{}
+	cfg := config.Email{EnvelopeFrom: "noreply+%d@test.com", From: "rates@test.com", Subject: "rates", MessageTemplate: "{{.RateSell}}"}
+	notifier := NewCurrencyRateNotifier(fetcher, store, store, store, sender, nil, slog.New(handler.NewNoOpHandler()), cfg)
+	notifier.batchSize = 4
+
+	require.NoError(t, notifier.Notify(ctx))
+
+	require.Equal(t, 1, sender.dials, "batches share a connection")
+	require.Equal(t, []int{2, 2, 2, 2, 2}, sender.batches, "every batch of 4 active subscriptions has 2 to mail")
+	require.Equal(t, want, sender.to, "unsubscribed and suppressed addresses and pairs without a rate are skipped")
+
+	first, err := store.GetSubscriptionByEmail(ctx, "user0@example.com")
+	require.NoError(t, err)
+	deliveries, err := store.ListDeliveries(ctx, first.ID)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	require.Equal(t, storage.DeliverySent, deliveries[0].Status)
+
+	retrySender := &recordingMailSender{}
+	notifier.Reconfigure(NotifierSettings{Email: cfg, EmailClient: retrySender})
+	require.NoError(t, notifier.NotifySubscribers(ctx, []storage.Subscription{first}))
+	require.Equal(t, []string{"<user0@example.com>"}, retrySender.to, "only the given subscriptions are mailed")
+	require.Equal(t, 1, sender.dials, "the replaced sender is not used")
+	deliveries, err = store.ListDeliveries(ctx, first.ID)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+}
+
+func TestNotifyReportsFailures(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	for _, email := range []string{"a@example.com", "b@example.com"} {
+		_, err := store.SaveSubscription(ctx, storage.Subscription{Email: email, Status: storage.StatusActive, Pairs: []string{"USD/UAH"}})
+		require.NoError(t, err)
+	}
+
+	fetcher := &stubRatesFetcher{rates: []monobank.CurrencyRate{{CurrencyCodeA: monobank.CurrencyUSD, CurrencyCodeB: monobank.CurrencyUAH, RateSell: 41.5, RateBuy: 41}}}
+	sender := &recordingMailSender{err: errors.New("mailbox unavailable")}
+	cfg := config.Email{EnvelopeFrom: "noreply+%d@test.com", From: "rates@test.com", Subject: "rates", MessageTemplate: "{{.RateSell}}"}
+	notifier := NewCurrencyRateNotifier(fetcher, store, store, store, sender, nil, slog.New(handler.NewNoOpHandler()), cfg)
+
+	require.ErrorContains(t, notifier.Notify(ctx), "2 of 2 messages failed")
+
+	first, err := store.GetSubscriptionByEmail(ctx, "a@example.com")
+	require.NoError(t, err)
+	deliveries, err := store.ListDeliveries(ctx, first.ID)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	require.Equal(t, storage.DeliveryFailed, deliveries[0].Status)
+	require.Equal(t, "mailbox unavailable", deliveries[0].Error, "a batch that fails as a whole fails for everyone in it")
+
+	notifier = NewCurrencyRateNotifier(fetcher, store, failingSuppressionChecker{}, store, &recordingMailSender{}, nil, slog.New(handler.NewNoOpHandler()), cfg)
+	require.ErrorContains(t, notifier.Notify(ctx), "2 of 2 messages failed")
+	deliveries, err = store.ListDeliveries(ctx, first.ID)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	require.Contains(t, deliveries[1].Error, "database is locked", "addresses that could not be checked are recorded as failed")
+
+	cfg.MessageTemplate = "{{.RateSell"
+	notifier.Reconfigure(NotifierSettings{Email: cfg, EmailClient: &recordingMailSender{}})
+	require.ErrorContains(t, notifier.NotifySubscribers(ctx, nil), "parse text template")
+}
+
+func TestNotifyRecordsRejectedRecipients(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	for _, email := range []string{"a@example.com", "rejected@example.com", "c@example.com"} {
+		_, err := store.SaveSubscription(ctx, storage.Subscription{Email: email, Status: storage.StatusActive, Pairs: []string{"USD/UAH"}})
+		require.NoError(t, err)
+	}
+
+	host, port := startSMTPServer(t, "rejected@example.com")
+	client, err := mail.NewClient(host, mail.WithPort(port), mail.WithTLSPolicy(mail.NoTLS))
+	require.NoError(t, err)
+
+	fetcher := &stubRatesFetcher{rates: []monobank.CurrencyRate{{CurrencyCodeA: monobank.CurrencyUSD, CurrencyCodeB: monobank.CurrencyUAH, RateSell: 41.5, RateBuy: 41}}}
+	cfg := config.Email{EnvelopeFrom: "noreply+%d@test.com", From: "rates@test.com", Subject: "rates", MessageTemplate: "{{.RateSell}}"}
+	notifier := NewCurrencyRateNotifier(fetcher, store, store, store, client, nil, slog.New(handler.NewNoOpHandler()), cfg)
+
+	require.ErrorContains(t, notifier.Notify(ctx), "1 of 3 messages failed")
+
+	for email, want := range map[string]string{"a@example.com": storage.DeliverySent, "rejected@example.com": storage.DeliveryFailed, "c@example.com": storage.DeliverySent} {
+		subscription, err := store.GetSubscriptionByEmail(ctx, email)
+		require.NoError(t, err)
+		deliveries, err := store.ListDeliveries(ctx, subscription.ID)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1)
+		require.Equal(t, want, deliveries[0].Status, email)
+		if want == storage.DeliveryFailed {
+			require.Contains(t, deliveries[0].Error, "mailbox unavailable")
+		}
+	}
 }
@@ -0,0 +1,212 @@
+package job
+
+import (
+	"context"
+	"currency-rates-notifier/internal/api/monobank"
+	"currency-rates-notifier/internal/lib/currency"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+type CurrencyRatesFetcher interface {
+	FetchCurrencyRates(ctx context.Context) ([]monobank.CurrencyRate, error)
+}
+
+// CurrencyRateEvent is a change of a single currency pair observed by CurrencyRatePoller.
+// IDs are assigned sequentially from the time the poller was created, so that an ID of
+// an earlier process is never mistaken for one of the current process.
+type CurrencyRateEvent struct {
+	ID   uint64
+	Rate monobank.CurrencyRate
+}
+
+const (
+	subscriberBufferSize = 64
+	// defaultPollInterval replaces intervals that are not positive, which time.NewTicker rejects
+	defaultPollInterval = time.Minute
+)
+
+// CurrencyRatePoller periodically fetches all currency rates and fans out changed ones
+// to subscribers, so that streaming clients share a single upstream request.
+type CurrencyRatePoller struct {
+	fetcher     CurrencyRatesFetcher
+	interval    time.Duration
+	historySize int
+	log         *slog.Logger
+
+	// epoch is the creation time in milliseconds, event IDs up to it belong to an earlier process
+	epoch uint64
+
+	mu          sync.Mutex
+	lastID      uint64
+	latest      map[currency.Pair]CurrencyRateEvent
+	history     []CurrencyRateEvent
+	subscribers map[chan CurrencyRateEvent]struct{}
+}
+
+func NewCurrencyRatePoller(fetcher CurrencyRatesFetcher, interval time.Duration, historySize int, log *slog.Logger) *CurrencyRatePoller {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	epoch := uint64(time.Now().UnixMilli())
+
+	return &CurrencyRatePoller{
+		fetcher:     fetcher,
+		interval:    interval,
+		historySize: historySize,
+		log:         log,
+		epoch:       epoch,
+		lastID:      epoch,
+		latest:      make(map[currency.Pair]CurrencyRateEvent),
+		subscribers: make(map[chan CurrencyRateEvent]struct{}),
+	}
+}
+
+// Run polls until ctx is cancelled, then closes all subscriber channels.
+func (p *CurrencyRatePoller) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	p.poll(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			p.closeSubscribers()
+			return
+		case <-ticker.C:
+			p.poll(ctx)
+		}
+	}
+}
+
+func (p *CurrencyRatePoller) poll(ctx context.Context) {
+	rates, err := p.fetcher.FetchCurrencyRates(ctx)
+	if err != nil {
+		p.log.Error("failed to poll currency rates", "error", err)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	changed := 0
+	for _, rate := range rates {
+		pair := rate.Pair()
+		if prev, ok := p.latest[pair]; ok && sameRate(prev.Rate, rate) {
+			continue
+		}
+
+		p.lastID++
+		event := CurrencyRateEvent{ID: p.lastID, Rate: rate}
+		p.latest[pair] = event
+		p.appendHistory(event)
+		p.publish(event)
+		changed++
+	}
+
+	p.log.Debug("polled currency rates", "total", len(rates), "changed", changed)
+}
+
+func sameRate(a, b monobank.CurrencyRate) bool {
+	return a.Date == b.Date && a.RateSell == b.RateSell && a.RateBuy == b.RateBuy && a.RateCross == b.RateCross
+}
+
+func (p *CurrencyRatePoller) appendHistory(event CurrencyRateEvent) {
+	p.history = append(p.history, event)
+	if len(p.history) > p.historySize {
+		p.history = p.history[len(p.history)-p.historySize:]
+	}
+}
+
+// publish must be called with p.mu held. Subscribers that cannot keep up are dropped
+// rather than blocking the poller; they are expected to reconnect and resume.
+func (p *CurrencyRatePoller) publish(event CurrencyRateEvent) {
+	for ch := range p.subscribers {
+		select {
+		case ch <- event:
+		default:
+			p.log.Warn("dropping slow currency rate subscriber")
+			delete(p.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns events the caller has missed since lastEventID and a channel of
+// subsequent events. When lastEventID is zero, issued by an earlier process or no longer
+// covered by the retained history, the backlog is a snapshot of the latest rate of every pair.
+// The returned function must be called to release the subscription.
+func (p *CurrencyRatePoller) Subscribe(lastEventID uint64) ([]CurrencyRateEvent, <-chan CurrencyRateEvent, func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var backlog []CurrencyRateEvent
+	if p.canResume(lastEventID) {
+		for _, event := range p.history {
+			if event.ID > lastEventID {
+				backlog = append(backlog, event)
+			}
+		}
+	} else {
+		backlog = p.snapshot()
+	}
+
+	ch := make(chan CurrencyRateEvent, subscriberBufferSize)
+	p.subscribers[ch] = struct{}{}
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if _, ok := p.subscribers[ch]; ok {
+				delete(p.subscribers, ch)
+				close(ch)
+			}
+		})
+	}
+
+	return backlog, ch, unsubscribe
+}
+
+// Latest returns the latest known rate of every pair ordered by event ID.
+func (p *CurrencyRatePoller) Latest() []CurrencyRateEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.snapshot()
+}
+
+func (p *CurrencyRatePoller) canResume(lastEventID uint64) bool {
+	if lastEventID <= p.epoch || lastEventID > p.lastID {
+		return false
+	}
+	if len(p.history) == 0 {
+		return lastEventID == p.lastID
+	}
+
+	return lastEventID >= p.history[0].ID-1
+}
+
+func (p *CurrencyRatePoller) snapshot() []CurrencyRateEvent {
+	events := make([]CurrencyRateEvent, 0, len(p.latest))
+	for _, event := range p.latest {
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+
+	return events
+}
+
+func (p *CurrencyRatePoller) closeSubscribers() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for ch := range p.subscribers {
+		delete(p.subscribers, ch)
+		close(ch)
+	}
+}
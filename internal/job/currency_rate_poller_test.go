@@ -0,0 +1,100 @@
+package job
+
+import (
+	"context"
+	"currency-rates-notifier/internal/api/monobank"
+	"currency-rates-notifier/internal/lib/logger/handler"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+type stubRatesFetcher struct {
+	rates []monobank.CurrencyRate
+}
+
+func (f *stubRatesFetcher) FetchCurrencyRates(_ context.Context) ([]monobank.CurrencyRate, error) {
+	return f.rates, nil
+}
+
+func TestCurrencyRatePollerPublishesOnlyChanges(t *testing.T) {
+	usd := monobank.CurrencyRate{CurrencyCodeA: monobank.CurrencyUSD, CurrencyCodeB: monobank.CurrencyUAH, Date: 1, RateSell: 41.5, RateBuy: 41.0}
+	eur := monobank.CurrencyRate{CurrencyCodeA: 978, CurrencyCodeB: monobank.CurrencyUAH, Date: 1, RateSell: 45.5, RateBuy: 45.0}
+	fetcher := &stubRatesFetcher{rates: []monobank.CurrencyRate{usd, eur}}
+	poller := NewCurrencyRatePoller(fetcher, time.Minute, 10, slog.New(handler.NewNoOpHandler()))
+
+	poller.poll(context.Background())
+	backlog, events, unsubscribe := poller.Subscribe(0)
+	defer unsubscribe()
+	require.Len(t, backlog, 2)
+
+	poller.poll(context.Background())
+	require.Empty(t, events)
+
+	usd.Date, usd.RateSell = 2, 41.6
+	fetcher.rates = []monobank.CurrencyRate{usd, eur}
+	poller.poll(context.Background())
+
+	event := <-events
+	require.Equal(t, poller.epoch+3, event.ID)
+	require.Equal(t, usd, event.Rate)
+}
+
+func TestCurrencyRatePollerResumesFromLastEventID(t *testing.T) {
+	rate := monobank.CurrencyRate{CurrencyCodeA: monobank.CurrencyUSD, CurrencyCodeB: monobank.CurrencyUAH}
+	fetcher := &stubRatesFetcher{}
+	poller := NewCurrencyRatePoller(fetcher, time.Minute, 2, slog.New(handler.NewNoOpHandler()))
+
+	for date := int64(1); date <= 4; date++ {
+		rate.Date = date
+		fetcher.rates = []monobank.CurrencyRate{rate}
+		poller.poll(context.Background())
+	}
+
+	backlog, _, unsubscribe := poller.Subscribe(poller.epoch + 2)
+	unsubscribe()
+	require.Len(t, backlog, 2)
+	require.Equal(t, poller.epoch+3, backlog[0].ID)
+	require.Equal(t, poller.epoch+4, backlog[1].ID)
+
+	backlog, _, unsubscribe = poller.Subscribe(poller.epoch + 1)
+	unsubscribe()
+	require.Len(t, backlog, 1, "history no longer covers the requested ID, expected a snapshot")
+	require.Equal(t, poller.epoch+4, backlog[0].ID)
+}
+
+func TestCurrencyRatePollerSendsSnapshotForIDsOfEarlierProcess(t *testing.T) {
+	rate := monobank.CurrencyRate{CurrencyCodeA: monobank.CurrencyUSD, CurrencyCodeB: monobank.CurrencyUAH}
+	fetcher := &stubRatesFetcher{}
+	previous := NewCurrencyRatePoller(fetcher, time.Minute, 10, slog.New(handler.NewNoOpHandler()))
+	for date := int64(1); date <= 3; date++ {
+		rate.Date = date
+		fetcher.rates = []monobank.CurrencyRate{rate}
+		previous.poll(context.Background())
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	poller := NewCurrencyRatePoller(fetcher, time.Minute, 10, slog.New(handler.NewNoOpHandler()))
+	for date := int64(4); date <= 6; date++ {
+		rate.Date = date
+		fetcher.rates = []monobank.CurrencyRate{rate}
+		poller.poll(context.Background())
+	}
+
+	backlog, _, unsubscribe := poller.Subscribe(previous.epoch + 2)
+	unsubscribe()
+	require.Len(t, backlog, 1, "the ID was issued before a restart, expected a snapshot")
+	require.Equal(t, poller.epoch+3, backlog[0].ID)
+}
+
+func TestCurrencyRatePollerDefaultsInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		poller := NewCurrencyRatePoller(&stubRatesFetcher{}, interval, 10, slog.New(handler.NewNoOpHandler()))
+		require.Equal(t, defaultPollInterval, poller.interval)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		require.NotPanics(t, func() { poller.Run(ctx) })
+	}
+}
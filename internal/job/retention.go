@@ -0,0 +1,58 @@
+package job
+
+import (
+	"context"
+	"currency-rates-notifier/internal/config"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+type RecordPruner interface {
+	PruneDeliveries(ctx context.Context, before time.Time) (int64, error)
+	PruneBounces(ctx context.Context, before time.Time) (int64, error)
+}
+
+// RetentionPruner deletes records older than their retention period
+type RetentionPruner struct {
+	pruner RecordPruner
+	cfg    atomic.Pointer[config.Retention]
+	log    *slog.Logger
+}
+
+func NewRetentionPruner(pruner RecordPruner, cfg config.Retention, log *slog.Logger) *RetentionPruner {
+	p := &RetentionPruner{pruner: pruner, log: log}
+	p.Reconfigure(cfg)
+	return p
+}
+
+// Reconfigure replaces the retention periods from the next run on
+func (p *RetentionPruner) Reconfigure(cfg config.Retention) {
+	p.cfg.Store(&cfg)
+}
+
+// Prune applies every retention period that is set
+func (p *RetentionPruner) Prune(ctx context.Context) {
+	now := time.Now()
+	cfg := p.cfg.Load()
+
+	policies := []struct {
+		name   string
+		period time.Duration
+		prune  func(ctx context.Context, before time.Time) (int64, error)
+	}{
+		{"deliveries", cfg.Deliveries, p.pruner.PruneDeliveries},
+		{"bounces", cfg.Bounces, p.pruner.PruneBounces},
+	}
+	for _, policy := range policies {
+		if policy.period <= 0 {
+			continue
+		}
+		pruned, err := policy.prune(ctx, now.Add(-policy.period))
+		if err != nil {
+			p.log.Error("failed to prune records", "records", policy.name, "error", err)
+			continue
+		}
+		p.log.Info("Records pruned.", "records", policy.name, "pruned", pruned, "retention", policy.period.String())
+	}
+}
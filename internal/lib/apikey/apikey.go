@@ -0,0 +1,110 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"currency-rates-notifier/internal/storage"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+const (
+	// ScopeRead allows viewing subscribers and the audit log
+	ScopeRead = "read"
+	// ScopeWrite allows everything ScopeRead does plus changes and key management
+	ScopeWrite = "write"
+
+	keyPrefix = "crn_"
+)
+
+var (
+	Invalid = errors.New("invalid api key")
+)
+
+// Principal is the authenticated caller of the admin API
+type Principal struct {
+	Name  string
+	Scope string
+}
+
+// Allows reports whether the principal's scope covers the required one
+func (p Principal) Allows(scope string) bool {
+	return p.Scope == scope || p.Scope == ScopeWrite
+}
+
+func ValidScope(scope string) bool {
+	return scope == ScopeRead || scope == ScopeWrite
+}
+
+// Generate returns a new random key and the hash to store in its place
+func Generate() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	key := keyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, Hash(key), nil
+}
+
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type KeyFinder interface {
+	GetAPIKeyByHash(ctx context.Context, hash string) (storage.APIKey, error)
+}
+
+// StaticToken is a bearer token configured outside the database, e.g. to bootstrap the first keys
+type StaticToken struct {
+	Name  string
+	Token string
+	Scope string
+}
+
+// Authenticator accepts static tokens and keys stored by their hash. Keys are looked up
+// by hash, so comparing them does not leak timing information about the secret.
+type Authenticator struct {
+	finder KeyFinder
+	tokens map[string]Principal
+}
+
+func NewAuthenticator(finder KeyFinder, tokens []StaticToken) (*Authenticator, error) {
+	byHash := make(map[string]Principal, len(tokens))
+	for _, token := range tokens {
+		if token.Token == "" {
+			return nil, fmt.Errorf("token %q is empty", token.Name)
+		}
+		if !ValidScope(token.Scope) {
+			return nil, fmt.Errorf("token %q has unknown scope %q", token.Name, token.Scope)
+		}
+		byHash[Hash(token.Token)] = Principal{Name: token.Name, Scope: token.Scope}
+	}
+
+	return &Authenticator{finder: finder, tokens: byHash}, nil
+}
+
+func (a *Authenticator) Authenticate(ctx context.Context, key string) (Principal, error) {
+	if key == "" {
+		return Principal{}, Invalid
+	}
+
+	hash := Hash(key)
+	if principal, ok := a.tokens[hash]; ok {
+		return principal, nil
+	}
+
+	stored, err := a.finder.GetAPIKeyByHash(ctx, hash)
+	if errors.Is(err, storage.APIKeyNotFound) {
+		return Principal{}, Invalid
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+
+	return Principal{Name: stored.Name, Scope: stored.Scope}, nil
+}
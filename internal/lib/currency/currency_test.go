@@ -0,0 +1,42 @@
+package currency
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParsePair(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Pair
+		wantErr string
+	}{
+		{input: "USD/UAH", want: Pair{Base: 840, Quote: 980}},
+		{input: "eur/uah", want: Pair{Base: 978, Quote: 980}},
+		{input: " 840 / 980 ", want: Pair{Base: 840, Quote: 980}},
+		{input: "USD/981", want: Pair{Base: 840, Quote: 981}},
+		{input: "XXX/UAH", wantErr: `unknown currency code: "XXX"`},
+		{input: "USD/1000", wantErr: `unknown currency code: "1000"`},
+		{input: "USD/0", wantErr: `unknown currency code: "0"`},
+		{input: "USD/", wantErr: `unknown currency code: ""`},
+		{input: "USDUAH", wantErr: `invalid currency pair: "USDUAH"`},
+		{input: "", wantErr: `invalid currency pair: ""`},
+		{input: "USD/UAH/EUR", wantErr: `unknown currency code: "UAH/EUR"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			pair, err := ParsePair(tt.input)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, pair)
+		})
+	}
+}
+
+func TestPairString(t *testing.T) {
+	require.Equal(t, "USD/UAH", Pair{Base: 840, Quote: 980}.String())
+	require.Equal(t, "999/UAH", Pair{Base: 999, Quote: 980}.String(), "unknown codes stay numeric")
+}
@@ -0,0 +1,174 @@
+package emailaddr
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/net/idna"
+	"net"
+	"net/mail"
+	"os"
+	"strings"
+)
+
+var (
+	Invalid          = errors.New("invalid email address")
+	DisposableDomain = errors.New("disposable email domains are not allowed")
+	NoMailServer     = errors.New("email domain does not accept mail")
+)
+
+const (
+	maxLocalPartLength = 64
+	maxAddressLength   = 254
+)
+
+// MXResolver is satisfied by *net.Resolver
+type MXResolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+type Validator struct {
+	resolver       MXResolver
+	blockedDomains map[string]struct{}
+}
+
+// NewValidator creates a validator that rejects addresses in blockedDomains and their
+// subdomains. MX lookup is skipped when resolver is nil.
+func NewValidator(resolver MXResolver, blockedDomains []string) (*Validator, error) {
+	blocked := make(map[string]struct{}, len(blockedDomains))
+	for _, domain := range blockedDomains {
+		ascii, err := normalizeDomain(domain)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blocked domain %q: %w", domain, err)
+		}
+		blocked[ascii] = struct{}{}
+	}
+
+	return &Validator{resolver: resolver, blockedDomains: blocked}, nil
+}
+
+// Normalize parses a bare RFC 5322 address and returns it with the domain converted
+// to lower-case punycode. The local part is preserved as typed.
+func (v *Validator) Normalize(ctx context.Context, raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", fmt.Errorf("%w: address is empty", Invalid)
+	}
+
+	addr, err := mail.ParseAddress(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", Invalid, err)
+	}
+	if addr.Name != "" {
+		return "", fmt.Errorf("%w: display names are not allowed", Invalid)
+	}
+
+	at := strings.LastIndex(addr.Address, "@")
+	local, domain := addr.Address[:at], addr.Address[at+1:]
+	if len(local) > maxLocalPartLength {
+		return "", fmt.Errorf("%w: local part is too long", Invalid)
+	}
+
+	domain, err = normalizeDomain(domain)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", Invalid, err)
+	}
+
+	normalized := local + "@" + domain
+	if len(normalized) > maxAddressLength {
+		return "", fmt.Errorf("%w: address is too long", Invalid)
+	}
+
+	if v.isBlocked(domain) {
+		return "", DisposableDomain
+	}
+
+	if v.resolver != nil {
+		if err := v.checkMailServer(ctx, domain); err != nil {
+			return "", err
+		}
+	}
+
+	return normalized, nil
+}
+
+func normalizeDomain(domain string) (string, error) {
+	if strings.HasPrefix(domain, "[") {
+		return "", errors.New("domain literals are not allowed")
+	}
+
+	ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(domain, "."))
+	if err != nil {
+		return "", err
+	}
+	if !strings.Contains(ascii, ".") {
+		return "", errors.New("domain must be fully qualified")
+	}
+
+	return strings.ToLower(ascii), nil
+}
+
+func (v *Validator) isBlocked(domain string) bool {
+	for {
+		if _, ok := v.blockedDomains[domain]; ok {
+			return true
+		}
+
+		_, parent, found := strings.Cut(domain, ".")
+		if !found {
+			return false
+		}
+		domain = parent
+	}
+}
+
+// checkMailServer falls back to the implicit MX (an A/AAAA record) as RFC 5321 allows.
+// Lookup failures other than "not found" do not reject the address.
+func (v *Validator) checkMailServer(ctx context.Context, domain string) error {
+	records, err := v.resolver.LookupMX(ctx, domain)
+	if err == nil {
+		if len(records) == 1 && records[0].Host == "." {
+			return NoMailServer
+		}
+		if len(records) > 0 {
+			return nil
+		}
+	} else if !isNotFound(err) {
+		return nil
+	}
+
+	_, err = v.resolver.LookupHost(ctx, domain)
+	if err != nil && isNotFound(err) {
+		return NoMailServer
+	}
+
+	return nil
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// LoadBlocklist reads one domain per line, ignoring blank lines and "#" comments
+func LoadBlocklist(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var domains []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains = append(domains, line)
+	}
+
+	return domains, scanner.Err()
+}
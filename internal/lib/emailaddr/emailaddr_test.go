@@ -0,0 +1,85 @@
+package emailaddr
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+)
+
+type stubResolver struct {
+	mx    map[string][]*net.MX
+	hosts map[string][]string
+}
+
+func (r stubResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	if records, ok := r.mx[name]; ok {
+		return records, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r stubResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestNormalize(t *testing.T) {
+	validator, err := NewValidator(nil, []string{"mailinator.com"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		err   error
+	}{
+		{name: "lower-cases domain", input: " Foo.Bar@Example.COM ", want: "Foo.Bar@example.com"},
+		{name: "converts IDN domain to punycode", input: "user@Bücher.example", want: "user@xn--bcher-kva.example"},
+		{name: "accepts angle brackets", input: "<user@example.com>", want: "user@example.com"},
+		{name: "rejects empty", input: "", err: Invalid},
+		{name: "rejects missing domain", input: "user@", err: Invalid},
+		{name: "rejects unqualified domain", input: "user@localhost", err: Invalid},
+		{name: "rejects display name", input: "User <user@example.com>", err: Invalid},
+		{name: "rejects blocked domain", input: "user@mailinator.com", err: DisposableDomain},
+		{name: "rejects blocked subdomain", input: "user@eu.Mailinator.com", err: DisposableDomain},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validator.Normalize(context.Background(), tt.input)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNormalizeChecksMailServer(t *testing.T) {
+	resolver := stubResolver{
+		mx: map[string][]*net.MX{
+			"example.com":  {{Host: "mx.example.com.", Pref: 10}},
+			"null.example": {{Host: "."}},
+		},
+		hosts: map[string][]string{"implicit.example": {"192.0.2.1"}},
+	}
+	validator, err := NewValidator(resolver, nil)
+	require.NoError(t, err)
+
+	_, err = validator.Normalize(context.Background(), "user@example.com")
+	require.NoError(t, err)
+
+	_, err = validator.Normalize(context.Background(), "user@implicit.example")
+	require.NoError(t, err)
+
+	_, err = validator.Normalize(context.Background(), "user@null.example")
+	require.ErrorIs(t, err, NoMailServer)
+
+	_, err = validator.Normalize(context.Background(), "user@missing.example")
+	require.ErrorIs(t, err, NoMailServer)
+}
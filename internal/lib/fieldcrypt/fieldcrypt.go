@@ -0,0 +1,164 @@
+// Package fieldcrypt encrypts single database values with AES-256-GCM and derives
+// blind indexes, keyed HMACs that allow uniqueness checks and exact lookups of
+// encrypted values.
+package fieldcrypt
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// prefix marks encrypted values, which look like enc:<key id>:<base64 nonce and ciphertext>
+	prefix = "enc:"
+
+	keySize      = 32
+	minIndexSize = 32
+)
+
+var (
+	UnknownKey = errors.New("unknown encryption key")
+	Malformed  = errors.New("malformed encrypted value")
+)
+
+// Keyring encrypts with the current key and decrypts with any of its keys, so values
+// written before a rotation stay readable until they are re-encrypted.
+type Keyring struct {
+	current  string
+	aeads    map[string]cipher.AEAD
+	indexKey []byte
+}
+
+// NewKeyring takes 32 byte AES keys by id, the id of the key new values are encrypted
+// with, and the HMAC key of the blind index
+func NewKeyring(current string, keys map[string][]byte, indexKey []byte) (*Keyring, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("%w: the current key %q is not among the keys", UnknownKey, current)
+	}
+	if len(indexKey) < minIndexSize {
+		return nil, fmt.Errorf("the index key must be at least %d bytes", minIndexSize)
+	}
+
+	aeads := make(map[string]cipher.AEAD, len(keys))
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("key id %q must be non-empty and must not contain ':'", id)
+		}
+		if len(key) != keySize {
+			return nil, fmt.Errorf("key %q must be %d bytes, got %d", id, keySize, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		aeads[id] = aead
+	}
+
+	return &Keyring{current: current, aeads: aeads, indexKey: indexKey}, nil
+}
+
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	aead := k.aeads[k.current]
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(k.current))
+
+	return prefix + k.current + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns values without the encryption prefix as they are, as those were
+// stored before encryption was enabled
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	if !ok {
+		return "", Malformed
+	}
+	aead, ok := k.aeads[id]
+	if !ok {
+		return "", fmt.Errorf("%w %q", UnknownKey, id)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", Malformed
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", Malformed, err)
+	}
+
+	return string(plaintext), nil
+}
+
+// IsCurrent reports whether value is encrypted with the current key
+func (k *Keyring) IsCurrent(value string) bool {
+	return strings.HasPrefix(value, prefix+k.current+":")
+}
+
+// Index returns the blind index of an email address. Addresses differing only in case
+// get the same index, matching the case-insensitive uniqueness of plaintext addresses.
+func (k *Keyring) Index(email string) string {
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(strings.ToLower(email)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// DecodeKey decodes a standard base64 key as printed by "openssl rand -base64 32"
+func DecodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("decode key: %w", err)
+	}
+	return key, nil
+}
+
+// LoadKeys reads base64 keys from a file with one id=key pair per line. Empty lines
+// and lines starting with # are skipped.
+func LoadKeys(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	keys := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, key, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected id=key", path, n)
+		}
+		keys[strings.TrimSpace(id)] = strings.TrimSpace(key)
+	}
+
+	return keys, scanner.Err()
+}
@@ -0,0 +1,58 @@
+package fieldcrypt
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, keySize)
+}
+
+func TestKeyringRotation(t *testing.T) {
+	old, err := NewKeyring("k1", map[string][]byte{"k1": testKey(1)}, testKey(9))
+	require.NoError(t, err)
+	rotated, err := NewKeyring("k2", map[string][]byte{"k1": testKey(1), "k2": testKey(2)}, testKey(9))
+	require.NoError(t, err)
+
+	encrypted, err := old.Encrypt("user@example.com")
+	require.NoError(t, err)
+	require.False(t, strings.Contains(encrypted, "user@example.com"))
+	require.True(t, old.IsCurrent(encrypted))
+	require.False(t, rotated.IsCurrent(encrypted))
+
+	again, err := old.Encrypt("user@example.com")
+	require.NoError(t, err)
+	require.NotEqual(t, encrypted, again, "encryption is not deterministic")
+
+	decrypted, err := rotated.Decrypt(encrypted)
+	require.NoError(t, err)
+	require.Equal(t, "user@example.com", decrypted)
+
+	reencrypted, err := rotated.Encrypt(decrypted)
+	require.NoError(t, err)
+	_, err = old.Decrypt(reencrypted)
+	require.ErrorIs(t, err, UnknownKey)
+
+	plaintext, err := old.Decrypt("legacy@example.com")
+	require.NoError(t, err)
+	require.Equal(t, "legacy@example.com", plaintext)
+
+	_, err = old.Decrypt(encrypted[:len(encrypted)-4])
+	require.ErrorIs(t, err, Malformed)
+
+	require.Equal(t, old.Index("User@Example.com"), rotated.Index("user@example.com"), "the index does not depend on the encryption keys")
+}
+
+func TestNewKeyringValidatesKeys(t *testing.T) {
+	_, err := NewKeyring("k2", map[string][]byte{"k1": testKey(1)}, testKey(9))
+	require.ErrorIs(t, err, UnknownKey)
+
+	_, err = NewKeyring("k1", map[string][]byte{"k1": testKey(1)[:16]}, testKey(9))
+	require.Error(t, err)
+
+	_, err = NewKeyring("k1", map[string][]byte{"k1": testKey(1)}, testKey(9)[:8])
+	require.Error(t, err)
+}
@@ -0,0 +1,49 @@
+package httputil
+
+import (
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	resolver, err := NewClientIPResolver([]string{"10.0.0.0/8", "192.168.1.1"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{name: "direct client", remoteAddr: "203.0.113.5:1234", want: "203.0.113.5"},
+		{name: "spoofed header from an untrusted peer", remoteAddr: "203.0.113.5:1234", forwarded: []string{"198.51.100.7"}, want: "203.0.113.5"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.1:80", forwarded: []string{"198.51.100.7"}, want: "198.51.100.7"},
+		{name: "spoofed entry in front of the proxy's", remoteAddr: "10.0.0.1:80", forwarded: []string{"1.2.3.4, 198.51.100.7"}, want: "198.51.100.7"},
+		{name: "chained trusted proxies", remoteAddr: "192.168.1.1:80", forwarded: []string{"198.51.100.7, 10.0.0.2"}, want: "198.51.100.7"},
+		{name: "chained proxies over several headers", remoteAddr: "192.168.1.1:80", forwarded: []string{"198.51.100.7", "10.0.0.2"}, want: "198.51.100.7"},
+		{name: "only trusted proxies", remoteAddr: "10.0.0.1:80", forwarded: []string{"10.0.0.2"}, want: "10.0.0.2"},
+		{name: "malformed entry stops the walk", remoteAddr: "10.0.0.1:80", forwarded: []string{"garbage, 10.0.0.3"}, want: "10.0.0.3"},
+		{name: "malformed header", remoteAddr: "10.0.0.1:80", forwarded: []string{"nope"}, want: "10.0.0.1"},
+		{name: "no header from a trusted proxy", remoteAddr: "10.0.0.1:80", want: "10.0.0.1"},
+		{name: "ipv4-mapped proxy address", remoteAddr: "[::ffff:10.0.0.1]:80", forwarded: []string{"198.51.100.7"}, want: "198.51.100.7"},
+		{name: "peer without port", remoteAddr: "203.0.113.5", want: "203.0.113.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			require.Equal(t, tt.want, resolver.ClientIP(r))
+		})
+	}
+}
+
+func TestNewClientIPResolverRejectsInvalidProxies(t *testing.T) {
+	for _, proxy := range []string{"10.0.0.0/33", "proxy.local", ""} {
+		_, err := NewClientIPResolver([]string{proxy})
+		require.Error(t, err, proxy)
+	}
+}
@@ -2,11 +2,23 @@ package httputil
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 )
 
-func WriteJSON(w http.ResponseWriter, v interface{}) error {
+// WriteJSON encodes v before touching w, so that an encoding failure can still be
+// reported with a 500 instead of a partially written body.
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) error {
+	return writeJSON(w, status, "application/json; charset=utf-8", v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, contentType string, v interface{}) error {
 	buf := &bytes.Buffer{}
 	enc := json.NewEncoder(buf)
 
@@ -15,12 +27,56 @@ func WriteJSON(w http.ResponseWriter, v interface{}) error {
 		return err
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
 
 	if _, err := w.Write(buf.Bytes()); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
 		return err
 	}
 
 	return nil
 }
+
+// PostJSON posts v encoded as JSON to endpoint, e.g. an incoming webhook, and treats any
+// status other than 2xx as a failure, including up to 512 bytes of the response in the error
+func PostJSON(ctx context.Context, client *http.Client, endpoint string, v interface{}, log *slog.Logger) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", withoutURL(err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", withoutURL(err))
+	}
+
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Error("failed to close body", "error", err)
+		}
+	}(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		reason, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status code: %d: %s", resp.StatusCode, reason)
+	}
+
+	return nil
+}
+
+// withoutURL drops the URL from the errors of the HTTP client, the URL of a webhook is
+// its secret and must not end up in logs
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
@@ -0,0 +1,26 @@
+package httputil
+
+import (
+	"context"
+	"currency-rates-notifier/internal/lib/logger/handler"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+)
+
+func TestPostJSONKeepsTheURLOutOfErrors(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	endpoint := server.URL + "/services/T000/B000/secret-token"
+	server.Close()
+
+	err := PostJSON(context.Background(), http.DefaultClient, endpoint, map[string]string{"text": "hi"}, slog.New(handler.NewNoOpHandler()))
+	require.ErrorIs(t, err, syscall.ECONNREFUSED)
+	require.NotContains(t, err.Error(), "secret-token")
+
+	err = PostJSON(context.Background(), http.DefaultClient, "http://hooks.example.com:x/secret-token", nil, slog.New(handler.NewNoOpHandler()))
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "secret-token")
+}
@@ -0,0 +1,54 @@
+package httputil
+
+import (
+	"net/http"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object. Errors is an extension member
+// listing validation failures of individual request fields.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// NewProblem returns a problem without a specific type, titled after the status code
+func NewProblem(status int, detail string) Problem {
+	return Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+func WriteProblem(w http.ResponseWriter, problem Problem) error {
+	return writeJSON(w, problem.Status, ProblemContentType, problem)
+}
+
+// ValidationError replies with 400 listing every invalid field
+func ValidationError(w http.ResponseWriter, r *http.Request, errors []FieldError) error {
+	problem := NewProblem(http.StatusBadRequest, "request validation failed")
+	problem.Instance = r.URL.Path
+	problem.Errors = errors
+
+	return WriteProblem(w, problem)
+}
+
+// Error replies with a problem for the given status, like http.Error does with plain text
+func Error(w http.ResponseWriter, r *http.Request, status int, detail string) error {
+	problem := NewProblem(status, detail)
+	problem.Instance = r.URL.Path
+
+	return WriteProblem(w, problem)
+}
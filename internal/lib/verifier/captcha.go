@@ -0,0 +1,96 @@
+package verifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	TurnstileVerifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+	HCaptchaVerifyURL  = "https://api.hcaptcha.com/siteverify"
+
+	// CaptchaTokenHeader carries the token produced by the captcha widget
+	CaptchaTokenHeader = "X-Captcha-Token"
+)
+
+type ClientIPResolver interface {
+	ClientIP(r *http.Request) string
+}
+
+// Captcha verifies widget tokens with a siteverify endpoint. Cloudflare Turnstile and
+// hCaptcha share the same protocol and only differ in the endpoint URL.
+type Captcha struct {
+	verifyURL  string
+	secret     string
+	resolver   ClientIPResolver
+	httpClient *http.Client
+	log        *slog.Logger
+}
+
+func NewCaptcha(verifyURL, secret string, resolver ClientIPResolver, log *slog.Logger) *Captcha {
+	return &Captcha{
+		verifyURL:  verifyURL,
+		secret:     secret,
+		resolver:   resolver,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		log:        log,
+	}
+}
+
+type siteVerifyResponse struct {
+	Success    bool     `json:"success"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+func (c *Captcha) Verify(ctx context.Context, r *http.Request) error {
+	token := r.Header.Get(CaptchaTokenHeader)
+	if token == "" {
+		return fmt.Errorf("%w: missing %s header", Failed, CaptchaTokenHeader)
+	}
+
+	form := url.Values{
+		"secret":   {c.secret},
+		"response": {token},
+		"remoteip": {c.resolver.ClientIP(r)},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			c.log.Error("failed to close body", "error", err)
+		}
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var result siteVerifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode JSON response: %w", err)
+	}
+
+	if !result.Success {
+		return fmt.Errorf("%w: %s", Failed, strings.Join(result.ErrorCodes, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package verifier
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProofOfWorkHeader carries "<challenge>:<nonce>"
+const ProofOfWorkHeader = "X-PoW-Solution"
+
+// Challenge is issued to a client, which must find a nonce such that
+// SHA-256("<challenge>:<nonce>") starts with Difficulty zero bits.
+type Challenge struct {
+	Challenge  string    `json:"challenge"`
+	Difficulty int       `json:"difficulty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// ProofOfWork issues stateless HMAC-signed challenges and remembers solved ones
+// until they expire, so that each solution can only be used once.
+type ProofOfWork struct {
+	key        []byte
+	difficulty int
+	ttl        time.Duration
+
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+func NewProofOfWork(key []byte, difficulty int, ttl time.Duration) *ProofOfWork {
+	return &ProofOfWork{key: key, difficulty: difficulty, ttl: ttl, used: make(map[string]time.Time)}
+}
+
+func (p *ProofOfWork) NewChallenge() (Challenge, error) {
+	payload := make([]byte, 8+16)
+	expiresAt := time.Now().Add(p.ttl).Truncate(time.Second)
+	binary.BigEndian.PutUint64(payload, uint64(expiresAt.Unix()))
+	if _, err := rand.Read(payload[8:]); err != nil {
+		return Challenge{}, fmt.Errorf("failed to generate challenge: %w", err)
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	challenge := encoded + "." + base64.RawURLEncoding.EncodeToString(p.sign(encoded))
+
+	return Challenge{Challenge: challenge, Difficulty: p.difficulty, ExpiresAt: expiresAt}, nil
+}
+
+func (p *ProofOfWork) Verify(_ context.Context, r *http.Request) error {
+	solution := r.Header.Get(ProofOfWorkHeader)
+	challenge, nonce, ok := strings.Cut(solution, ":")
+	if !ok || nonce == "" {
+		return fmt.Errorf("%w: missing or malformed %s header", Failed, ProofOfWorkHeader)
+	}
+
+	expiresAt, err := p.parseChallenge(challenge)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if now.After(expiresAt) {
+		return fmt.Errorf("%w: challenge expired", Failed)
+	}
+
+	sum := sha256.Sum256([]byte(solution))
+	if leadingZeroBits(sum[:]) < p.difficulty {
+		return fmt.Errorf("%w: insufficient proof of work", Failed)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for used, expiry := range p.used {
+		if now.After(expiry) {
+			delete(p.used, used)
+		}
+	}
+	if _, ok := p.used[challenge]; ok {
+		return fmt.Errorf("%w: challenge already used", Failed)
+	}
+	p.used[challenge] = expiresAt
+
+	return nil
+}
+
+func (p *ProofOfWork) parseChallenge(challenge string) (time.Time, error) {
+	encoded, signature, ok := strings.Cut(challenge, ".")
+	if !ok {
+		return time.Time{}, fmt.Errorf("%w: malformed challenge", Failed)
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, p.sign(encoded)) {
+		return time.Time{}, fmt.Errorf("%w: invalid challenge signature", Failed)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(payload) < 8 {
+		return time.Time{}, fmt.Errorf("%w: malformed challenge", Failed)
+	}
+
+	return time.Unix(int64(binary.BigEndian.Uint64(payload)), 0), nil
+}
+
+func (p *ProofOfWork) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func leadingZeroBits(b []byte) int {
+	n := 0
+	for _, v := range b {
+		if v != 0 {
+			return n + bits.LeadingZeros8(v)
+		}
+		n += 8
+	}
+	return n
+}
@@ -0,0 +1,71 @@
+package verifier
+
+import (
+	"context"
+	"crypto/sha256"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// solve returns the first solution that meets difficulty, or with enough false the first one that misses it
+func solve(challenge string, difficulty int, enough bool) string {
+	for nonce := 0; ; nonce++ {
+		solution := challenge + ":" + strconv.Itoa(nonce)
+		sum := sha256.Sum256([]byte(solution))
+		if (leadingZeroBits(sum[:]) >= difficulty) == enough {
+			return solution
+		}
+	}
+}
+
+func TestProofOfWorkVerify(t *testing.T) {
+	const difficulty = 8
+	pow := NewProofOfWork([]byte("key"), difficulty, time.Minute)
+	challenge, err := pow.NewChallenge()
+	require.NoError(t, err)
+	require.Equal(t, difficulty, challenge.Difficulty)
+
+	expired, err := NewProofOfWork([]byte("key"), difficulty, -time.Minute).NewChallenge()
+	require.NoError(t, err)
+	foreign, err := NewProofOfWork([]byte("other key"), difficulty, time.Minute).NewChallenge()
+	require.NoError(t, err)
+	tampered := challenge.Challenge[:len(challenge.Challenge)-1] + "A"
+	if tampered == challenge.Challenge {
+		tampered = challenge.Challenge[:len(challenge.Challenge)-1] + "B"
+	}
+
+	tests := []struct {
+		name     string
+		solution string
+		wantErr  string
+	}{
+		{name: "missing header", wantErr: "missing or malformed"},
+		{name: "no nonce", solution: challenge.Challenge + ":", wantErr: "missing or malformed"},
+		{name: "unsigned challenge", solution: "abc:1", wantErr: "malformed challenge"},
+		{name: "tampered signature", solution: solve(tampered, 0, true), wantErr: "invalid challenge signature"},
+		{name: "signed with another key", solution: solve(foreign.Challenge, 0, true), wantErr: "invalid challenge signature"},
+		{name: "expired challenge", solution: solve(expired.Challenge, difficulty, true), wantErr: "challenge expired"},
+		{name: "low difficulty", solution: solve(challenge.Challenge, difficulty, false), wantErr: "insufficient proof of work"},
+		{name: "solved", solution: solve(challenge.Challenge, difficulty, true)},
+		{name: "reused challenge", solution: solve(challenge.Challenge, difficulty, true), wantErr: "challenge already used"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", nil)
+			if tt.solution != "" {
+				r.Header.Set(ProofOfWorkHeader, tt.solution)
+			}
+
+			err := pow.Verify(context.Background(), r)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, Failed)
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package verifier
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+var (
+	Failed = errors.New("verification failed")
+)
+
+// Verifier proves that a request was made by a human or at least at some cost to
+// the client, e.g. a captcha or a proof of work. Errors wrapping Failed are the
+// client's fault; any other error means the verification could not be performed.
+type Verifier interface {
+	Verify(ctx context.Context, r *http.Request) error
+}
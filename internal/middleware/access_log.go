@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"currency-rates-notifier/internal/lib/logger"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// AccessLog logs every request once it completes. Long-lived streams are logged when they end.
+func AccessLog(log *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := recorderFor(w)
+
+			next.ServeHTTP(rec, r)
+
+			logger.FromContext(r.Context(), log).Info("request completed",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", rec.status,
+				"bytes", rec.bytes,
+				"duration", time.Since(start),
+				"remote_addr", r.RemoteAddr,
+				"user_agent", r.UserAgent(),
+			)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"context"
+	"currency-rates-notifier/internal/lib/apikey"
+	"currency-rates-notifier/internal/lib/httputil"
+	"currency-rates-notifier/internal/lib/logger"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type Authenticator interface {
+	Authenticate(ctx context.Context, key string) (apikey.Principal, error)
+}
+
+type principalKey struct{}
+
+// RequireScope lets through requests bearing a key whose scope covers the given one.
+// Requests without a valid key get 401, those with an insufficient scope get 403.
+func RequireScope(auth Authenticator, scope string, log *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := logger.FromContext(r.Context(), log)
+
+			principal, err := auth.Authenticate(r.Context(), bearerToken(r))
+			if err != nil {
+				status, detail := http.StatusUnauthorized, "a valid API key is required"
+				if errors.Is(err, apikey.Invalid) {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				} else {
+					log.Error("failed to authenticate", "error", err)
+					status, detail = http.StatusInternalServerError, "failed to authenticate"
+				}
+				if err := httputil.Error(w, r, status, detail); err != nil {
+					log.Error("failed to write a problem", "error", err)
+				}
+				return
+			}
+
+			if !principal.Allows(scope) {
+				log.Warn("insufficient scope", "principal", principal.Name, "scope", principal.Scope, "required", scope)
+				if err := httputil.Error(w, r, http.StatusForbidden, "the API key does not have the "+scope+" scope"); err != nil {
+					log.Error("failed to write a problem", "error", err)
+				}
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), principalKey{}, principal)
+			ctx = logger.WithLogger(ctx, log.With("principal", principal.Name))
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// PrincipalFromContext returns the caller authenticated by RequireScope
+func PrincipalFromContext(ctx context.Context) (apikey.Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(apikey.Principal)
+	return principal, ok
+}
+
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"context"
+	"currency-rates-notifier/internal/lib/apikey"
+	"currency-rates-notifier/internal/lib/logger"
+	"currency-rates-notifier/internal/lib/logger/handler"
+	"currency-rates-notifier/internal/storage"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChainRecoversPanicsWithRequestID(t *testing.T) {
+	log := slog.New(handler.NewNoOpHandler())
+	var requestLog *slog.Logger
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestLog = logger.FromContext(r.Context(), nil)
+		panic("boom")
+	}), RequestID(log), AccessLog(log), Recover(log))
+
+	req := httptest.NewRequest(http.MethodGet, "/rate", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+	require.Equal(t, "abc-123", rec.Header().Get(RequestIDHeader))
+	require.NotNil(t, requestLog)
+}
+
+func TestRequestIDReplacesInvalidHeader(t *testing.T) {
+	var id string
+	h := RequestID(slog.New(handler.NewNoOpHandler()))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id = RequestIDFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/rate", nil)
+	req.Header.Set(RequestIDHeader, "has spaces\n")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	require.Len(t, id, 32)
+	require.Equal(t, id, rec.Header().Get(RequestIDHeader))
+}
+
+type stubKeyFinder map[string]storage.APIKey
+
+func (f stubKeyFinder) GetAPIKeyByHash(_ context.Context, hash string) (storage.APIKey, error) {
+	key, ok := f[hash]
+	if !ok {
+		return storage.APIKey{}, storage.APIKeyNotFound
+	}
+	return key, nil
+}
+
+func TestRequireScopeAcceptsStoredKeys(t *testing.T) {
+	key, hash, err := apikey.Generate()
+	require.NoError(t, err)
+	auth, err := apikey.NewAuthenticator(stubKeyFinder{hash: {Name: "support", Scope: apikey.ScopeRead}}, nil)
+	require.NoError(t, err)
+
+	var principal apikey.Principal
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = PrincipalFromContext(r.Context())
+	})
+	log := slog.New(handler.NewNoOpHandler())
+
+	tests := []struct {
+		scope  string
+		header string
+		status int
+	}{
+		{scope: apikey.ScopeRead, header: "Bearer " + key, status: http.StatusOK},
+		{scope: apikey.ScopeWrite, header: "Bearer " + key, status: http.StatusForbidden},
+		{scope: apikey.ScopeRead, header: "Bearer " + key + "x", status: http.StatusUnauthorized},
+		{scope: apikey.ScopeRead, header: key, status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/admin/subscribers", nil)
+		req.Header.Set("Authorization", tt.header)
+		rec := httptest.NewRecorder()
+		RequireScope(auth, tt.scope, log)(next).ServeHTTP(rec, req)
+
+		require.Equal(t, tt.status, rec.Code, "%s with %q", tt.scope, tt.header)
+	}
+	require.Equal(t, "support", principal.Name)
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"currency-rates-notifier/internal/lib/httputil"
+	"currency-rates-notifier/internal/lib/logger"
+	"golang.org/x/time/rate"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const limiterIdleTimeout = 10 * time.Minute
+
+type ClientIPResolver interface {
+	ClientIP(r *http.Request) string
+}
+
+// RateLimiter keeps a token bucket per client IP. Buckets of clients that have been
+// idle for a while are evicted lazily.
+type RateLimiter struct {
+	limit    rate.Limit
+	burst    int
+	resolver ClientIPResolver
+
+	mu        sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func NewRateLimiter(perMinute float64, burst int, resolver ClientIPResolver) *RateLimiter {
+	return &RateLimiter{
+		limit:     rate.Limit(perMinute / 60),
+		burst:     burst,
+		resolver:  resolver,
+		clients:   make(map[string]*clientLimiter),
+		lastSweep: time.Now(),
+	}
+}
+
+// SetLimit changes the rate and burst of every client, including the buckets in use
+func (l *RateLimiter) SetLimit(perMinute float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit, l.burst = rate.Limit(perMinute/60), burst
+	now := time.Now()
+	for _, client := range l.clients {
+		client.limiter.SetLimitAt(now, l.limit)
+		client.limiter.SetBurstAt(now, l.burst)
+	}
+}
+
+func (l *RateLimiter) reserve(ip string, now time.Time) *rate.Reservation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > limiterIdleTimeout {
+		for key, client := range l.clients {
+			if now.Sub(client.lastSeen) > limiterIdleTimeout {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	client, ok := l.clients[ip]
+	if !ok {
+		client = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[ip] = client
+	}
+	client.lastSeen = now
+
+	return client.limiter.ReserveN(now, 1)
+}
+
+// RateLimit replies 429 with Retry-After once a client exhausts its bucket
+func RateLimit(limiter *RateLimiter, log *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			now := time.Now()
+			ip := limiter.resolver.ClientIP(r)
+
+			reservation := limiter.reserve(ip, now)
+			if delay := reservation.DelayFrom(now); !reservation.OK() || delay > 0 {
+				reservation.CancelAt(now)
+				if !reservation.OK() {
+					delay = time.Minute
+				}
+
+				log := logger.FromContext(r.Context(), log)
+				log.Warn("rate limit exceeded", "client_ip", ip)
+
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+				if err := httputil.Error(w, r, http.StatusTooManyRequests, "too many requests"); err != nil {
+					log.Error("failed to write a problem", "error", err)
+				}
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
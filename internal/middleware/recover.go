@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"currency-rates-notifier/internal/lib/httputil"
+	"currency-rates-notifier/internal/lib/logger"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recover turns a panic into a 500 problem response, unless the handler has already
+// started writing. http.ErrAbortHandler is re-panicked so net/http can abort the connection.
+func Recover(log *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := recorderFor(w)
+
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+
+				logger.FromContext(r.Context(), log).Error("handler panicked", "panic", v, "stack", string(debug.Stack()))
+
+				if rec.wroteHeader {
+					return
+				}
+				if err := httputil.Error(rec, r, http.StatusInternalServerError, "internal server error"); err != nil {
+					log.Error("failed to write a problem", "error", err)
+				}
+			}()
+
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
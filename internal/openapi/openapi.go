@@ -0,0 +1,16 @@
+package openapi
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// Spec is the OpenAPI 3.1 document describing the public API
+//
+//go:embed openapi.json
+var Spec []byte
+
+func ServeSpec(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_, _ = w.Write(Spec)
+}
@@ -0,0 +1,264 @@
+// Package privacy lets subscribers download and erase the data held about them. Both
+// need a link mailed to the subscribed address, which proves the requester owns it.
+package privacy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"currency-rates-notifier/internal/storage"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/wneessen/go-mail"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ActionExport = "export"
+	ActionErase  = "erase"
+)
+
+// linkQueueSize is how many link requests may wait to be mailed
+const linkQueueSize = 100
+
+var (
+	InvalidToken = errors.New("the link is invalid or has expired")
+	QueueFull    = errors.New("too many link requests are waiting to be mailed")
+)
+
+type Store interface {
+	GetSubscription(ctx context.Context, id int64) (storage.Subscription, error)
+	GetSubscriptionByEmail(ctx context.Context, email string) (storage.Subscription, error)
+	ListDeliveries(ctx context.Context, subscriptionID int64) ([]storage.Delivery, error)
+	EraseSubscription(ctx context.Context, id int64) error
+}
+
+// MailSender is implemented by *mail.Client
+type MailSender interface {
+	DialAndSendWithContext(ctx context.Context, messages ...*mail.Msg) error
+}
+
+type Options struct {
+	// Secret signs the links
+	Secret []byte
+	// LinkTTL is how long a link stays valid
+	LinkTTL time.Duration
+	// LinkURL is the public URL of the endpoint a link of the given action points to
+	LinkURL func(action string) string
+	From    string
+}
+
+// Data is everything stored about a subscriber
+type Data struct {
+	Subscription storage.Subscription
+	Deliveries   []storage.Delivery
+}
+
+type Service struct {
+	store    Store
+	secret   []byte
+	settings atomic.Pointer[settings]
+	requests chan linkRequest
+	log      *slog.Logger
+}
+
+type linkRequest struct {
+	email  string
+	action string
+}
+
+type settings struct {
+	mailer MailSender
+	opts   Options
+}
+
+func NewService(store Store, mailer MailSender, opts Options, log *slog.Logger) *Service {
+	s := &Service{store: store, secret: opts.Secret, requests: make(chan linkRequest, linkQueueSize), log: log}
+	s.Reconfigure(mailer, opts)
+	return s
+}
+
+// Reconfigure replaces the mailer and the options except the secret, which keeps the
+// links already mailed valid
+func (s *Service) Reconfigure(mailer MailSender, opts Options) {
+	opts.Secret = s.secret
+	s.settings.Store(&settings{mailer: mailer, opts: opts})
+}
+
+func ValidAction(action string) bool {
+	return action == ActionExport || action == ActionErase
+}
+
+// RequestLink queues a link for the action to be mailed by Run. The address is looked up
+// only then, so neither the result nor the time taken discloses who is subscribed.
+func (s *Service) RequestLink(email, action string) error {
+	select {
+	case s.requests <- linkRequest{email: email, action: action}:
+		return nil
+	default:
+		return QueueFull
+	}
+}
+
+// Run mails the queued links until ctx is cancelled, links still queued then are dropped
+func (s *Service) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-s.requests:
+			if err := s.SendLink(ctx, req.email, req.action); err != nil {
+				s.log.Error("failed to send privacy link", "action", req.action, "error", err)
+			}
+		}
+	}
+}
+
+// SendLink mails a link for the action to the address. Nothing is sent to addresses that
+// are not subscribed, and the caller is not told.
+func (s *Service) SendLink(ctx context.Context, email, action string) error {
+	subscription, err := s.store.GetSubscriptionByEmail(ctx, email)
+	if errors.Is(err, storage.SubscriptionNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("find subscription: %w", err)
+	}
+
+	settings := s.settings.Load()
+	expiresAt := time.Now().Add(settings.opts.LinkTTL)
+	link := settings.opts.LinkURL(action) + "?token=" + url.QueryEscape(s.sign(action, subscription, expiresAt))
+
+	message := mail.NewMsg()
+	if err := message.From(settings.opts.From); err != nil {
+		return fmt.Errorf("set FROM address: %w", err)
+	}
+	if err := message.AddTo(subscription.Email); err != nil {
+		return fmt.Errorf("set TO address: %w", err)
+	}
+	message.SetMessageID()
+	message.SetDate()
+	message.Subject(subjects[action])
+	message.SetBodyString(mail.TypeTextPlain, fmt.Sprintf(bodies[action], link, expiresAt.UTC().Format(time.RFC1123)))
+
+	if err := settings.mailer.DialAndSendWithContext(ctx, message); err != nil {
+		return fmt.Errorf("send link: %w", err)
+	}
+
+	return nil
+}
+
+var subjects = map[string]string{
+	ActionExport: "Your currency rate subscription data",
+	ActionErase:  "Erase your currency rate subscription",
+}
+
+var bodies = map[string]string{
+	ActionExport: "Someone, hopefully you, asked for a copy of the data we hold about this address.\n\n" +
+		"Download it here: %s\n\nThe link expires at %s. If you did not ask for it, ignore this message.\n",
+	ActionErase: "Someone, hopefully you, asked us to erase the subscription of this address and all data about it.\n\n" +
+		"Confirm here: %s\n\nThe link expires at %s. If you did not ask for it, ignore this message.\n",
+}
+
+// Export returns the data of the subscriber the export link was sent to
+func (s *Service) Export(ctx context.Context, token string) (Data, error) {
+	subscription, err := s.verify(ctx, token, ActionExport)
+	if err != nil {
+		return Data{}, err
+	}
+
+	deliveries, err := s.store.ListDeliveries(ctx, subscription.ID)
+	if err != nil {
+		return Data{}, fmt.Errorf("list deliveries: %w", err)
+	}
+
+	return Data{Subscription: subscription, Deliveries: deliveries}, nil
+}
+
+// Erase erases the subscriber the erase link was sent to. Using the link again after the
+// erasure reports InvalidToken.
+func (s *Service) Erase(ctx context.Context, token string) error {
+	subscription, err := s.verify(ctx, token, ActionErase)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.EraseSubscription(ctx, subscription.ID); err != nil {
+		return fmt.Errorf("erase subscription: %w", err)
+	}
+
+	return nil
+}
+
+// CheckToken verifies a link without acting on it, e.g. before asking for confirmation
+func (s *Service) CheckToken(ctx context.Context, token, action string) error {
+	_, err := s.verify(ctx, token, action)
+	return err
+}
+
+// A token is the base64 of "<action>:<subscription id>:<address hash>:<expiry>" and its
+// HMAC. The hash ties the token to the address, as ids may be reused after an erasure,
+// without putting the address itself into URLs.
+func (s *Service) sign(action string, subscription storage.Subscription, expiresAt time.Time) string {
+	payload := fmt.Sprintf("%s:%d:%s:%d", action, subscription.ID, s.addressHash(subscription.Email), expiresAt.Unix())
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.mac(encoded))
+}
+
+func (s *Service) verify(ctx context.Context, token, action string) (storage.Subscription, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return storage.Subscription{}, InvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, s.mac(encoded)) {
+		return storage.Subscription{}, InvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return storage.Subscription{}, InvalidToken
+	}
+
+	fields := strings.Split(string(payload), ":")
+	if len(fields) != 4 || fields[0] != action {
+		return storage.Subscription{}, InvalidToken
+	}
+	id, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return storage.Subscription{}, InvalidToken
+	}
+	expiry, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil || time.Now().After(time.Unix(expiry, 0)) {
+		return storage.Subscription{}, InvalidToken
+	}
+
+	subscription, err := s.store.GetSubscription(ctx, id)
+	if errors.Is(err, storage.SubscriptionNotFound) {
+		return storage.Subscription{}, InvalidToken
+	}
+	if err != nil {
+		return storage.Subscription{}, fmt.Errorf("find subscription: %w", err)
+	}
+	if !hmac.Equal([]byte(s.addressHash(subscription.Email)), []byte(fields[2])) {
+		return storage.Subscription{}, InvalidToken
+	}
+
+	return subscription, nil
+}
+
+func (s *Service) mac(payload string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func (s *Service) addressHash(email string) string {
+	return hex.EncodeToString(s.mac("address:" + strings.ToLower(email)))[:16]
+}
@@ -0,0 +1,134 @@
+package privacy
+
+import (
+	"context"
+	"currency-rates-notifier/internal/storage"
+	"currency-rates-notifier/internal/storage/memory"
+	"github.com/stretchr/testify/require"
+	"github.com/wneessen/go-mail"
+	"io"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+type recordingMailSender struct {
+	messages []*mail.Msg
+}
+
+func (m *recordingMailSender) DialAndSendWithContext(_ context.Context, messages ...*mail.Msg) error {
+	m.messages = append(m.messages, messages...)
+	return nil
+}
+
+var linkPattern = regexp.MustCompile(`https://example\.com/privacy/\w+\?token=\S+`)
+
+// token returns the token of the link in the last mailed message
+func (m *recordingMailSender) token(t *testing.T) string {
+	t.Helper()
+
+	require.NotEmpty(t, m.messages)
+	parts := m.messages[len(m.messages)-1].GetParts()
+	require.Len(t, parts, 1)
+	body, err := parts[0].GetContent()
+	require.NoError(t, err)
+
+	link, err := url.Parse(linkPattern.FindString(string(body)))
+	require.NoError(t, err)
+	return link.Query().Get("token")
+}
+
+func newService(t *testing.T, ttl time.Duration) (*Service, *memory.Storage, *recordingMailSender, storage.Subscription) {
+	store := memory.New()
+	subscription, err := store.SaveSubscription(context.Background(), storage.Subscription{Email: "user@example.com", Status: storage.StatusActive, Pairs: []string{"USD/UAH"}})
+	require.NoError(t, err)
+	require.NoError(t, store.SaveDeliveries(context.Background(), []storage.Delivery{{SubscriptionID: subscription.ID, Status: storage.DeliverySent}}))
+
+	mailer := &recordingMailSender{}
+	service := NewService(store, mailer, Options{
+		Secret:  []byte("secret"),
+		LinkTTL: ttl,
+		LinkURL: func(action string) string { return "https://example.com/privacy/" + action },
+		From:    "noreply@example.com",
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	return service, store, mailer, subscription
+}
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	service, _, mailer, subscription := newService(t, time.Hour)
+
+	require.NoError(t, service.SendLink(ctx, "User@Example.com", ActionExport))
+	require.Len(t, mailer.messages, 1)
+	require.Equal(t, []string{"<user@example.com>"}, mailer.messages[0].GetToString())
+	token := mailer.token(t)
+
+	data, err := service.Export(ctx, token)
+	require.NoError(t, err)
+	require.Equal(t, subscription, data.Subscription)
+	require.Len(t, data.Deliveries, 1)
+
+	_, err = service.Export(ctx, token[:len(token)-1])
+	require.ErrorIs(t, err, InvalidToken)
+	require.ErrorIs(t, service.Erase(ctx, token), InvalidToken, "an export link must not erase")
+}
+
+func TestErase(t *testing.T) {
+	ctx := context.Background()
+	service, store, mailer, subscription := newService(t, time.Hour)
+
+	require.NoError(t, service.SendLink(ctx, "user@example.com", ActionErase))
+	token := mailer.token(t)
+	require.NoError(t, service.CheckToken(ctx, token, ActionErase))
+
+	require.NoError(t, service.Erase(ctx, token))
+	_, err := store.GetSubscription(ctx, subscription.ID)
+	require.ErrorIs(t, err, storage.SubscriptionNotFound)
+	require.ErrorIs(t, service.Erase(ctx, token), InvalidToken)
+
+	results, err := store.ImportSubscriptions(ctx, []storage.Subscription{{Email: "USER@example.com", Status: storage.StatusActive}}, false)
+	require.NoError(t, err)
+	require.ErrorIs(t, results[0], storage.EmailSuppressed, "an erased address must not be imported again")
+}
+
+func TestRequestLinkForUnknownAddress(t *testing.T) {
+	service, _, mailer, _ := newService(t, time.Hour)
+
+	require.NoError(t, service.SendLink(context.Background(), "nobody@example.com", ActionExport))
+	require.Empty(t, mailer.messages)
+}
+
+func TestRequestLinkIsQueued(t *testing.T) {
+	service, _, mailer, _ := newService(t, time.Hour)
+
+	require.NoError(t, service.RequestLink("nobody@example.com", ActionExport))
+	require.NoError(t, service.RequestLink("user@example.com", ActionExport))
+	require.Empty(t, mailer.messages, "nothing is looked up or sent before Run")
+	for range linkQueueSize - 2 {
+		require.NoError(t, service.RequestLink("user@example.com", ActionErase))
+	}
+	require.ErrorIs(t, service.RequestLink("user@example.com", ActionErase), QueueFull)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		service.Run(ctx)
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return len(service.requests) == 0 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+	require.Len(t, mailer.messages, linkQueueSize-1, "no link is sent to the unknown address")
+}
+
+func TestExpiredLink(t *testing.T) {
+	ctx := context.Background()
+	service, _, mailer, _ := newService(t, -time.Second)
+
+	require.NoError(t, service.SendLink(ctx, "user@example.com", ActionExport))
+	_, err := service.Export(ctx, mailer.token(t))
+	require.ErrorIs(t, err, InvalidToken)
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"currency-rates-notifier/internal/lib/fieldcrypt"
+	"database/sql"
+	"fmt"
+)
+
+const reencryptBatchSize = 500
+
+// SealEmail returns the value the SQL backends store for an address and its blind index,
+// which is NULL while encryption is disabled, i.e. keyring is nil
+func SealEmail(keyring *fieldcrypt.Keyring, email string) (string, sql.NullString, error) {
+	if keyring == nil {
+		return email, sql.NullString{}, nil
+	}
+
+	encrypted, err := keyring.Encrypt(email)
+	if err != nil {
+		return "", sql.NullString{}, fmt.Errorf("encrypt email: %w", err)
+	}
+	return encrypted, sql.NullString{String: keyring.Index(email), Valid: true}, nil
+}
+
+// OpenEmail returns the address of a stored value, EncryptionDisabled when it is
+// encrypted and keyring is nil
+func OpenEmail(keyring *fieldcrypt.Keyring, value string) (string, error) {
+	if keyring != nil {
+		return keyring.Decrypt(value)
+	}
+	if fieldcrypt.IsEncrypted(value) {
+		return "", EncryptionDisabled
+	}
+	return value, nil
+}
+
+// ReencryptQueries are the statements ReencryptSubscriptions runs, in the dialect of a backend
+type ReencryptQueries struct {
+	// Select returns id, email and email_hash of the subscriptions with an id greater than
+	// the first argument, ordered by id and limited to the second argument
+	Select string
+	// Update sets email and email_hash of the subscription with the id in the third argument
+	Update            string
+	IsUniqueViolation func(err error) bool
+}
+
+// ReencryptSubscriptions encrypts every address that is stored in plaintext or with
+// another than the current key, and recomputes blind indexes, e.g. after the index key
+// changed. It works through the table in batches, each in its own transaction, so it
+// can be interrupted and run again. It returns the number of updated subscriptions.
+func ReencryptSubscriptions(ctx context.Context, db *sql.DB, keyring *fieldcrypt.Keyring, queries ReencryptQueries) (int, error) {
+	if keyring == nil {
+		return 0, EncryptionDisabled
+	}
+
+	var (
+		afterID int64
+		updated int
+	)
+	for {
+		lastID, n, err := reencryptBatch(ctx, db, keyring, queries, afterID)
+		updated += n
+		if err != nil {
+			return updated, err
+		}
+		if lastID == afterID {
+			return updated, nil
+		}
+		afterID = lastID
+	}
+}
+
+func reencryptBatch(ctx context.Context, db *sql.DB, keyring *fieldcrypt.Keyring, queries ReencryptQueries, afterID int64) (int64, int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return afterID, 0, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, queries.Select, afterID, reencryptBatchSize)
+	if err != nil {
+		return afterID, 0, fmt.Errorf("execute query: %w", err)
+	}
+
+	type change struct {
+		id        int64
+		email     string
+		emailHash sql.NullString
+	}
+	var changes []change
+	lastID := afterID
+	for rows.Next() {
+		var (
+			id        int64
+			value     string
+			emailHash sql.NullString
+		)
+		if err := rows.Scan(&id, &value, &emailHash); err != nil {
+			rows.Close()
+			return afterID, 0, fmt.Errorf("scan row: %w", err)
+		}
+		lastID = id
+
+		email, err := keyring.Decrypt(value)
+		if err != nil {
+			rows.Close()
+			return afterID, 0, fmt.Errorf("subscription %d: %w", id, err)
+		}
+		if keyring.IsCurrent(value) && emailHash.String == keyring.Index(email) {
+			continue
+		}
+
+		sealed, hash, err := SealEmail(keyring, email)
+		if err != nil {
+			rows.Close()
+			return afterID, 0, err
+		}
+		changes = append(changes, change{id: id, email: sealed, emailHash: hash})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return afterID, 0, fmt.Errorf("rows iteration: %w", err)
+	}
+
+	for _, c := range changes {
+		_, err := tx.ExecContext(ctx, queries.Update, c.email, c.emailHash, c.id)
+		if queries.IsUniqueViolation(err) {
+			return afterID, 0, fmt.Errorf("subscription %d: the address is stored twice, remove one of the subscriptions: %w", c.id, err)
+		}
+		if err != nil {
+			return afterID, 0, fmt.Errorf("update subscription %d: %w", c.id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return afterID, 0, fmt.Errorf("commit: %w", err)
+	}
+
+	return lastID, len(changes), nil
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"context"
+	"iter"
+)
+
+type ActiveSubscriptionPager interface {
+	GetActiveSubscriptionsAfter(ctx context.Context, afterID int64, limit int) ([]Subscription, error)
+}
+
+// ActiveSubscriptions iterates over active subscriptions in id order, fetching them
+// pageSize at a time, so only one page is held in memory. Subscriptions added while
+// iterating are seen when their id is past the current page. Iteration stops after
+// the first error.
+func ActiveSubscriptions(ctx context.Context, pager ActiveSubscriptionPager, pageSize int) iter.Seq2[Subscription, error] {
+	return func(yield func(Subscription, error) bool) {
+		var afterID int64
+		for {
+			page, err := pager.GetActiveSubscriptionsAfter(ctx, afterID, pageSize)
+			if err != nil {
+				yield(Subscription{}, err)
+				return
+			}
+
+			for _, subscription := range page {
+				if !yield(subscription, nil) {
+					return
+				}
+			}
+
+			if len(page) < pageSize {
+				return
+			}
+			afterID = page[len(page)-1].ID
+		}
+	}
+}
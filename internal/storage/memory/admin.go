@@ -0,0 +1,81 @@
+package memory
+
+import (
+	"context"
+	"currency-rates-notifier/internal/storage"
+	"fmt"
+	"slices"
+	"time"
+)
+
+func (s *Storage) SaveAPIKey(_ context.Context, key storage.APIKey) (storage.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key.ID = s.nextID()
+	key.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	s.apiKeys = append(s.apiKeys, key)
+
+	return key, nil
+}
+
+func (s *Storage) GetAPIKeyByHash(_ context.Context, hash string) (storage.APIKey, error) {
+	const op = "storage.memory.GetAPIKeyByHash"
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, key := range s.apiKeys {
+		if key.Hash == hash {
+			return key, nil
+		}
+	}
+
+	return storage.APIKey{}, fmt.Errorf("%s: %w", op, storage.APIKeyNotFound)
+}
+
+func (s *Storage) ListAPIKeys(_ context.Context) ([]storage.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]storage.APIKey{}, s.apiKeys...), nil
+}
+
+func (s *Storage) DeleteAPIKey(_ context.Context, id int64) error {
+	const op = "storage.memory.DeleteAPIKey"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.apiKeys, func(key storage.APIKey) bool { return key.ID == id })
+	if i < 0 {
+		return fmt.Errorf("%s: %w", op, storage.APIKeyNotFound)
+	}
+	s.apiKeys = slices.Delete(s.apiKeys, i, i+1)
+
+	return nil
+}
+
+func (s *Storage) SaveAuditEntry(_ context.Context, entry storage.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.ID = s.nextID()
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	}
+	s.auditLog = append(s.auditLog, entry)
+
+	return nil
+}
+
+// ListAuditEntries returns a page of the audit log, newest first, and its total size
+func (s *Storage) ListAuditEntries(_ context.Context, limit, offset int) ([]storage.AuditEntry, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	newestFirst := slices.Clone(s.auditLog)
+	slices.Reverse(newestFirst)
+
+	return append([]storage.AuditEntry{}, page(newestFirst, limit, offset)...), len(s.auditLog), nil
+}
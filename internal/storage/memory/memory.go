@@ -0,0 +1,236 @@
+// Package memory keeps subscriptions, API keys and the audit log in process memory.
+// It is meant for tests and local runs, everything is lost when the process exits.
+package memory
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"currency-rates-notifier/internal/storage"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Storage struct {
+	mu sync.RWMutex
+
+	subscriptions []storage.Subscription
+	deliveries    []storage.Delivery
+	suppressed    map[string]string
+	hardBounces   map[string]bounce
+	bounceEvents  map[string]time.Time
+	apiKeys       []storage.APIKey
+	auditLog      []storage.AuditEntry
+	lastID        int64
+	hasher        storage.SuppressionHasher
+}
+
+type bounce struct {
+	count         int
+	lastBouncedAt time.Time
+}
+
+func New() *Storage {
+	// nothing outlives the process, so a key of its own is enough
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return &Storage{suppressed: make(map[string]string), hardBounces: make(map[string]bounce),
+		bounceEvents: make(map[string]time.Time), hasher: storage.NewSuppressionHasher(key)}
+}
+
+func (s *Storage) nextID() int64 {
+	s.lastID++
+	return s.lastID
+}
+
+func (s *Storage) SaveSubscription(_ context.Context, subscription storage.Subscription) (storage.Subscription, error) {
+	const op = "storage.memory.SaveSubscription"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.indexOfEmail(subscription.Email) >= 0 {
+		return storage.Subscription{}, fmt.Errorf("%s: %w", op, storage.EmailExists)
+	}
+
+	subscription.ID = s.nextID()
+	subscription.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	subscription.Pairs = slices.Clone(subscription.Pairs)
+	s.subscriptions = append(s.subscriptions, subscription)
+
+	return copySubscription(subscription), nil
+}
+
+// ImportSubscriptions stores the subscriptions that are not stored yet, see
+// sqlite.Storage.ImportSubscriptions. The whole batch is stored or nothing is.
+func (s *Storage) ImportSubscriptions(ctx context.Context, subscriptions []storage.Subscription, dryRun bool) ([]error, error) {
+	const op = "storage.memory.ImportSubscriptions"
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	results := make([]error, len(subscriptions))
+	seen := make(map[string]struct{}, len(subscriptions))
+	var imported []storage.Subscription
+	for i, subscription := range subscriptions {
+		if _, ok := s.suppressed[s.hasher.Hash(subscription.Email)]; ok {
+			results[i] = storage.EmailSuppressed
+			continue
+		}
+		key := strings.ToLower(subscription.Email)
+		if _, ok := seen[key]; ok || s.indexOfEmail(subscription.Email) >= 0 {
+			results[i] = storage.EmailExists
+			continue
+		}
+		seen[key] = struct{}{}
+
+		subscription.CreatedAt = createdAt
+		subscription.Pairs = slices.Clone(subscription.Pairs)
+		imported = append(imported, subscription)
+	}
+
+	if dryRun {
+		return results, nil
+	}
+	for _, subscription := range imported {
+		subscription.ID = s.nextID()
+		s.subscriptions = append(s.subscriptions, subscription)
+	}
+
+	return results, nil
+}
+
+func (s *Storage) GetActiveSubscriptionsAfter(_ context.Context, afterID int64, limit int) ([]storage.Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// subscriptions are kept in id order
+	start, _ := slices.BinarySearchFunc(s.subscriptions, afterID+1, func(subscription storage.Subscription, id int64) int {
+		return cmp.Compare(subscription.ID, id)
+	})
+
+	subscriptions := make([]storage.Subscription, 0, limit)
+	for _, subscription := range s.subscriptions[start:] {
+		if len(subscriptions) == limit {
+			break
+		}
+		if subscription.Status == storage.StatusActive {
+			subscriptions = append(subscriptions, copySubscription(subscription))
+		}
+	}
+
+	return subscriptions, nil
+}
+
+func (s *Storage) ListSubscriptions(_ context.Context, filter storage.SubscriptionFilter) ([]storage.Subscription, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	search := strings.ToLower(filter.Search)
+	var matching []storage.Subscription
+	for _, subscription := range s.subscriptions {
+		if filter.Status != "" && subscription.Status != filter.Status {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(subscription.Email), search) {
+			continue
+		}
+		matching = append(matching, subscription)
+	}
+
+	subscriptions := []storage.Subscription{}
+	for _, subscription := range page(matching, filter.Limit, filter.Offset) {
+		subscriptions = append(subscriptions, copySubscription(subscription))
+	}
+
+	return subscriptions, len(matching), nil
+}
+
+func (s *Storage) GetSubscription(_ context.Context, id int64) (storage.Subscription, error) {
+	const op = "storage.memory.GetSubscription"
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := s.indexOfSubscription(id)
+	if i < 0 {
+		return storage.Subscription{}, fmt.Errorf("%s: %w", op, storage.SubscriptionNotFound)
+	}
+
+	return copySubscription(s.subscriptions[i]), nil
+}
+
+func (s *Storage) UpdateSubscription(_ context.Context, subscription storage.Subscription) (storage.Subscription, error) {
+	const op = "storage.memory.UpdateSubscription"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOfSubscription(subscription.ID)
+	if i < 0 {
+		return storage.Subscription{}, fmt.Errorf("%s: %w", op, storage.SubscriptionNotFound)
+	}
+	s.subscriptions[i].Status = subscription.Status
+	s.subscriptions[i].Pairs = slices.Clone(subscription.Pairs)
+
+	return copySubscription(s.subscriptions[i]), nil
+}
+
+func (s *Storage) DeleteSubscription(_ context.Context, id int64) error {
+	const op = "storage.memory.DeleteSubscription"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOfSubscription(id)
+	if i < 0 {
+		return fmt.Errorf("%s: %w", op, storage.SubscriptionNotFound)
+	}
+	s.subscriptions = slices.Delete(s.subscriptions, i, i+1)
+	s.deliveries = slices.DeleteFunc(s.deliveries, func(delivery storage.Delivery) bool {
+		return delivery.SubscriptionID == id
+	})
+
+	return nil
+}
+
+func (s *Storage) Close() error {
+	return nil
+}
+
+func (s *Storage) indexOfEmail(email string) int {
+	return slices.IndexFunc(s.subscriptions, func(subscription storage.Subscription) bool {
+		return strings.EqualFold(subscription.Email, email)
+	})
+}
+
+func (s *Storage) indexOfSubscription(id int64) int {
+	return slices.IndexFunc(s.subscriptions, func(subscription storage.Subscription) bool {
+		return subscription.ID == id
+	})
+}
+
+func copySubscription(subscription storage.Subscription) storage.Subscription {
+	subscription.Pairs = slices.Clone(subscription.Pairs)
+	return subscription
+}
+
+// page returns the items a LIMIT/OFFSET query would, a limit below one means no limit
+func page[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return nil
+	}
+	items = items[offset:]
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
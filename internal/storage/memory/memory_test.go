@@ -0,0 +1,13 @@
+package memory
+
+import (
+	"currency-rates-notifier/internal/storage"
+	"currency-rates-notifier/internal/storage/storagetest"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		return New()
+	})
+}
@@ -0,0 +1,180 @@
+package memory
+
+import (
+	"context"
+	"currency-rates-notifier/internal/storage"
+	"fmt"
+	"slices"
+	"time"
+)
+
+func (s *Storage) SaveDeliveries(_ context.Context, deliveries []storage.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, delivery := range deliveries {
+		delivery.ID = s.nextID()
+		if delivery.CreatedAt.IsZero() {
+			delivery.CreatedAt = now
+		}
+		s.deliveries = append(s.deliveries, delivery)
+	}
+
+	return nil
+}
+
+func (s *Storage) ListDeliveries(_ context.Context, subscriptionID int64) ([]storage.Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deliveries := []storage.Delivery{}
+	for _, delivery := range s.deliveries {
+		if delivery.SubscriptionID == subscriptionID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	return deliveries, nil
+}
+
+func (s *Storage) FailedSubscriptionIDs(_ context.Context, since time.Time) ([]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	last := make(map[int64]storage.Delivery)
+	for _, delivery := range s.deliveries {
+		last[delivery.SubscriptionID] = delivery
+	}
+
+	ids := []int64{}
+	for id, delivery := range last {
+		if delivery.Status == storage.DeliveryFailed && !delivery.CreatedAt.Before(since) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+
+	return ids, nil
+}
+
+func (s *Storage) GetSubscriptionByEmail(_ context.Context, email string) (storage.Subscription, error) {
+	const op = "storage.memory.GetSubscriptionByEmail"
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := s.indexOfEmail(email)
+	if i < 0 {
+		return storage.Subscription{}, fmt.Errorf("%s: %w", op, storage.SubscriptionNotFound)
+	}
+
+	return copySubscription(s.subscriptions[i]), nil
+}
+
+func (s *Storage) EraseSubscription(_ context.Context, id int64) error {
+	const op = "storage.memory.EraseSubscription"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOfSubscription(id)
+	if i < 0 {
+		return fmt.Errorf("%s: %w", op, storage.SubscriptionNotFound)
+	}
+	hash := s.hasher.Hash(s.subscriptions[i].Email)
+	if _, ok := s.suppressed[hash]; !ok {
+		s.suppressed[hash] = storage.SuppressionErased
+	}
+	delete(s.hardBounces, hash)
+	s.subscriptions = slices.Delete(s.subscriptions, i, i+1)
+
+	s.deliveries = slices.DeleteFunc(s.deliveries, func(delivery storage.Delivery) bool {
+		return delivery.SubscriptionID == id
+	})
+	target := storage.SubscriberAuditTarget(id)
+	for n := range s.auditLog {
+		if s.auditLog[n].Target == target {
+			s.auditLog[n].Details = ""
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) SuppressEmail(_ context.Context, email, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := s.hasher.Hash(email)
+	if _, ok := s.suppressed[hash]; !ok {
+		s.suppressed[hash] = reason
+	}
+
+	return nil
+}
+
+func (s *Storage) SuppressedEmails(_ context.Context, emails []string) (map[string]struct{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	suppressed := make(map[string]struct{})
+	for _, email := range emails {
+		if _, ok := s.suppressed[s.hasher.Hash(email)]; ok {
+			suppressed[email] = struct{}{}
+		}
+	}
+
+	return suppressed, nil
+}
+
+func (s *Storage) RecordHardBounce(_ context.Context, email, eventID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := s.hasher.Hash(email)
+	b := s.hardBounces[hash]
+	if eventID != "" {
+		if _, ok := s.bounceEvents[eventID]; ok {
+			return b.count, nil
+		}
+		s.bounceEvents[eventID] = time.Now().UTC().Truncate(time.Second)
+	}
+	b.count++
+	b.lastBouncedAt = time.Now().UTC().Truncate(time.Second)
+	s.hardBounces[hash] = b
+
+	return b.count, nil
+}
+
+func (s *Storage) PruneDeliveries(_ context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.deliveries)
+	s.deliveries = slices.DeleteFunc(s.deliveries, func(delivery storage.Delivery) bool {
+		return delivery.CreatedAt.Before(before)
+	})
+
+	return int64(n - len(s.deliveries)), nil
+}
+
+func (s *Storage) PruneBounces(_ context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pruned int64
+	for hash, b := range s.hardBounces {
+		if b.lastBouncedAt.Before(before) {
+			delete(s.hardBounces, hash)
+			pruned++
+		}
+	}
+	for id, createdAt := range s.bounceEvents {
+		if createdAt.Before(before) {
+			delete(s.bounceEvents, id)
+		}
+	}
+
+	return pruned, nil
+}
@@ -0,0 +1,116 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)", name).Scan(&exists)
+	require.NoError(t, err)
+	return exists
+}
+
+func TestUpAndDown(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_create_a.up.sql":   {Data: []byte("CREATE TABLE a(id INTEGER);")},
+		"0001_create_a.down.sql": {Data: []byte("DROP TABLE a;")},
+		"0002_create_b.up.sql":   {Data: []byte("CREATE TABLE b(id INTEGER); INSERT INTO b VALUES (1);")},
+		"0002_create_b.down.sql": {Data: []byte("DROP TABLE b;")},
+		"README.md":              {Data: []byte("ignored")},
+	}
+	db := openDB(t)
+	ctx := context.Background()
+
+	m, err := New(db, fsys)
+	require.NoError(t, err)
+	require.Equal(t, 2, m.Latest())
+
+	applied, err := m.Up(ctx)
+	require.NoError(t, err)
+	require.Len(t, applied, 2)
+	require.True(t, tableExists(t, db, "b"))
+
+	applied, err = m.Up(ctx)
+	require.NoError(t, err)
+	require.Empty(t, applied)
+
+	reverted, err := m.Down(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, reverted, 1)
+	require.Equal(t, 2, reverted[0].Version)
+	require.False(t, tableExists(t, db, "b"))
+	require.True(t, tableExists(t, db, "a"))
+
+	version, err := m.Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, version)
+
+	statuses, err := m.Status(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, statuses[0].AppliedAt)
+	require.Nil(t, statuses[1].AppliedAt)
+}
+
+func TestFailedMigrationIsRolledBack(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_create_a.up.sql": {Data: []byte("CREATE TABLE a(id INTEGER);")},
+		"0002_broken.up.sql":   {Data: []byte("CREATE TABLE b(id INTEGER); INSERT INTO missing VALUES (1);")},
+	}
+	db := openDB(t)
+	ctx := context.Background()
+
+	m, err := New(db, fsys)
+	require.NoError(t, err)
+
+	applied, err := m.Up(ctx)
+	require.Error(t, err)
+	require.Len(t, applied, 1)
+	require.False(t, tableExists(t, db, "b"))
+
+	version, err := m.Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, version)
+}
+
+func TestUnknownAppliedVersion(t *testing.T) {
+	db := openDB(t)
+	ctx := context.Background()
+
+	newer, err := New(db, fstest.MapFS{
+		"0001_a.up.sql": {Data: []byte("SELECT 1;")},
+		"0002_b.up.sql": {Data: []byte("SELECT 1;")},
+	})
+	require.NoError(t, err)
+	_, err = newer.Up(ctx)
+	require.NoError(t, err)
+
+	older, err := New(db, fstest.MapFS{"0001_a.up.sql": {Data: []byte("SELECT 1;")}})
+	require.NoError(t, err)
+	_, err = older.Up(ctx)
+	require.ErrorIs(t, err, SchemaTooNew)
+}
+
+func TestLoadRejectsInvalidSets(t *testing.T) {
+	_, err := Load(fstest.MapFS{"0001_a.down.sql": {Data: []byte("SELECT 1;")}})
+	require.Error(t, err)
+
+	_, err = Load(fstest.MapFS{
+		"0001_a.up.sql": {Data: []byte("SELECT 1;")},
+		"0001_b.up.sql": {Data: []byte("SELECT 1;")},
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,26 @@
+package postgres
+
+import (
+	"context"
+	"currency-rates-notifier/internal/storage"
+	"fmt"
+)
+
+var reencryptQueries = storage.ReencryptQueries{
+	Select:            "SELECT id, email, email_hash FROM email WHERE id > $1 ORDER BY id LIMIT $2",
+	Update:            "UPDATE email SET email = $1, email_hash = $2 WHERE id = $3",
+	IsUniqueViolation: isUniqueViolation,
+}
+
+// ReencryptSubscriptions encrypts the addresses that are not encrypted with the current
+// key, see storage.ReencryptSubscriptions
+func (s *Storage) ReencryptSubscriptions(ctx context.Context) (int, error) {
+	const op = "storage.postgres.ReencryptSubscriptions"
+
+	updated, err := storage.ReencryptSubscriptions(ctx, s.db, s.keyring, reencryptQueries)
+	if err != nil {
+		return updated, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return updated, nil
+}
@@ -0,0 +1,27 @@
+CREATE TABLE email(
+    id BIGSERIAL PRIMARY KEY,
+    email TEXT NOT NULL UNIQUE,
+    status TEXT NOT NULL DEFAULT 'active',
+    pairs TEXT NOT NULL DEFAULT 'USD/UAH',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now());
+
+-- addresses are stored with a normalised domain, so the remaining case
+-- differences are in the local part, which most providers ignore
+CREATE UNIQUE INDEX email_email_lower_idx ON email(lower(email));
+CREATE INDEX email_status_idx ON email(status);
+
+CREATE TABLE api_key(
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL);
+
+CREATE TABLE audit_log(
+    id BIGSERIAL PRIMARY KEY,
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL,
+    target TEXT NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL);
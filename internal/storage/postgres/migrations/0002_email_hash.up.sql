@@ -0,0 +1,3 @@
+-- blind index of encrypted addresses, NULL while encryption is disabled
+ALTER TABLE email ADD COLUMN email_hash TEXT;
+CREATE UNIQUE INDEX email_email_hash_idx ON email(email_hash);
@@ -0,0 +1,2 @@
+DROP TABLE suppression;
+DROP TABLE delivery_log;
@@ -0,0 +1,5 @@
+-- hard bounces of addresses, identified by storage.SuppressionHash
+CREATE TABLE bounce(
+    email_hash TEXT PRIMARY KEY,
+    hard_bounces INTEGER NOT NULL,
+    last_bounced_at TIMESTAMPTZ NOT NULL);
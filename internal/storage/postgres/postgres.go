@@ -0,0 +1,460 @@
+package postgres
+
+import (
+	"context"
+	"currency-rates-notifier/internal/lib/fieldcrypt"
+	"currency-rates-notifier/internal/storage"
+	"currency-rates-notifier/internal/storage/migrate"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"io/fs"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	uniqueViolation = "23505"
+
+	// migrationLockID serialises migrations of replicas starting at the same time
+	migrationLockID = 7_240_391_558
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// insertSubscription inserts nothing when the address is stored in plaintext, see the
+// sqlite insertSubscription
+const insertSubscription = "INSERT INTO email(email, email_hash, status, pairs, created_at) SELECT $1::text, $2::text, $3::text, $4::text, $5::timestamptz " +
+	"WHERE NOT EXISTS(SELECT 1 FROM email WHERE email_hash IS NULL AND lower(email) = lower($6::text))"
+
+type Storage struct {
+	db       *sql.DB
+	migrator *migrate.Migrator
+	keyring  *fieldcrypt.Keyring
+	hasher   storage.SuppressionHasher
+
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+type options struct {
+	migrate        bool
+	keyring        *fieldcrypt.Keyring
+	suppressionKey []byte
+}
+
+type Option func(*options)
+
+// WithoutMigrations opens the database as is, leaving migrations to Migrator
+func WithoutMigrations() Option {
+	return func(o *options) {
+		o.migrate = false
+	}
+}
+
+// WithEncryption encrypts email addresses with the keyring, see sqlite.WithEncryption
+func WithEncryption(keyring *fieldcrypt.Keyring) Option {
+	return func(o *options) {
+		o.keyring = keyring
+	}
+}
+
+// WithSuppressionKey keys the hashes that identify suppressed and bouncing addresses. It
+// is required, and changing it forgets every suppression and bounce count.
+func WithSuppressionKey(key []byte) Option {
+	return func(o *options) {
+		o.suppressionKey = key
+	}
+}
+
+// New connects to the database given by a postgres:// URL or a key=value DSN and
+// applies pending migrations unless WithoutMigrations is given.
+func New(dsn string, opts ...Option) (*Storage, error) {
+	const op = "storage.postgres.New"
+
+	o := options{migrate: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if len(o.suppressionKey) == 0 {
+		return nil, fmt.Errorf("%s: a suppression key is required", op)
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	ctx := context.Background()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	fsys, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	migrator, err := migrate.New(db, fsys, migrate.WithNumberedParameters())
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s := &Storage{db: db, migrator: migrator, keyring: o.keyring, hasher: storage.NewSuppressionHasher(o.suppressionKey), stmts: make(map[string]*sql.Stmt)}
+	if o.migrate {
+		if err := s.migrateLocked(ctx); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *Storage) migrateLocked(ctx context.Context) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+
+	_, err = s.migrator.Up(ctx)
+	return err
+}
+
+func (s *Storage) Migrator() *migrate.Migrator {
+	return s.migrator
+}
+
+func (s *Storage) SaveSubscription(ctx context.Context, subscription storage.Subscription) (storage.Subscription, error) {
+	const op = "storage.postgres.SaveSubscription"
+
+	email, emailHash, err := storage.SealEmail(s.keyring, subscription.Email)
+	if err != nil {
+		return storage.Subscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+	subscription.CreatedAt = time.Now().UTC().Truncate(time.Second)
+
+	err = s.db.QueryRowContext(ctx, insertSubscription+" RETURNING id",
+		email, emailHash, subscription.Status, joinPairs(subscription.Pairs), subscription.CreatedAt, subscription.Email).Scan(&subscription.ID)
+	if err != nil {
+		if isUniqueViolation(err) || errors.Is(err, sql.ErrNoRows) {
+			return storage.Subscription{}, fmt.Errorf("%s: %w", op, storage.EmailExists)
+		}
+
+		return storage.Subscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return subscription, nil
+}
+
+// ImportSubscriptions inserts a batch of subscriptions in one transaction. The returned
+// slice holds storage.EmailExists for every subscription that was already stored,
+// storage.EmailSuppressed for suppressed addresses and nil for the others. With dryRun
+// the transaction is rolled back, so the result only tells what an import would do.
+func (s *Storage) ImportSubscriptions(ctx context.Context, subscriptions []storage.Subscription, dryRun bool) ([]error, error) {
+	const op = "storage.postgres.ImportSubscriptions"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: begin transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	// a failed statement aborts a PostgreSQL transaction, so conflicts are skipped instead
+	stmt, err := tx.PrepareContext(ctx, insertSubscription+" ON CONFLICT DO NOTHING")
+	if err != nil {
+		return nil, fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+	defer stmt.Close()
+	suppressedStmt, err := tx.PrepareContext(ctx, "SELECT EXISTS(SELECT 1 FROM suppression WHERE email_hash = $1)")
+	if err != nil {
+		return nil, fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+	defer suppressedStmt.Close()
+
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	results := make([]error, len(subscriptions))
+	for i, subscription := range subscriptions {
+		var isSuppressed bool
+		if err := suppressedStmt.QueryRowContext(ctx, s.hasher.Hash(subscription.Email)).Scan(&isSuppressed); err != nil {
+			return nil, fmt.Errorf("%s: check suppression: %w", op, err)
+		}
+		if isSuppressed {
+			results[i] = storage.EmailSuppressed
+			continue
+		}
+
+		email, emailHash, err := storage.SealEmail(s.keyring, subscription.Email)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		res, err := stmt.ExecContext(ctx, email, emailHash, subscription.Status, joinPairs(subscription.Pairs), createdAt, subscription.Email)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if affected, err := res.RowsAffected(); err != nil {
+			return nil, fmt.Errorf("%s: rows affected: %w", op, err)
+		} else if affected == 0 {
+			results[i] = storage.EmailExists
+		}
+	}
+
+	if dryRun {
+		return results, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%s: commit: %w", op, err)
+	}
+
+	return results, nil
+}
+
+// GetActiveSubscriptionsAfter returns up to limit active subscriptions with an id
+// greater than afterID, ordered by id
+func (s *Storage) GetActiveSubscriptionsAfter(ctx context.Context, afterID int64, limit int) ([]storage.Subscription, error) {
+	const op = "storage.postgres.GetActiveSubscriptionsAfter"
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id, email, status, pairs, created_at FROM email WHERE status = $1 AND id > $2 ORDER BY id LIMIT $3", storage.StatusActive, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: execute query: %w", op, err)
+	}
+	defer rows.Close()
+
+	subscriptions := make([]storage.Subscription, 0, limit)
+	for rows.Next() {
+		subscription, err := s.scanSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: scan row: %w", op, err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: rows iteration: %w", op, err)
+	}
+
+	return subscriptions, nil
+}
+
+// ListSubscriptions returns a page of subscriptions ordered by id and the number of
+// subscriptions matching the filter regardless of paging.
+func (s *Storage) ListSubscriptions(ctx context.Context, filter storage.SubscriptionFilter) ([]storage.Subscription, int, error) {
+	const op = "storage.postgres.ListSubscriptions"
+
+	where := " WHERE 1 = 1"
+	var args []any
+	if filter.Search != "" && s.keyring != nil {
+		// encrypted addresses only match as a whole
+		args = append(args, "%"+escapeLike(filter.Search)+"%", s.keyring.Index(filter.Search))
+		where += fmt.Sprintf(` AND (email ILIKE $%d OR email_hash = $%d)`, len(args)-1, len(args))
+	} else if filter.Search != "" {
+		args = append(args, "%"+escapeLike(filter.Search)+"%")
+		where += fmt.Sprintf(` AND email ILIKE $%d`, len(args))
+	}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		where += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, "SELECT count(*) FROM email"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("%s: count: %w", op, err)
+	}
+
+	// LIMIT NULL means no limit
+	var limit any
+	if filter.Limit > 0 {
+		limit = filter.Limit
+	}
+	query := fmt.Sprintf("SELECT id, email, status, pairs, created_at FROM email%s ORDER BY id LIMIT $%d OFFSET $%d", where, len(args)+1, len(args)+2)
+	rows, err := s.db.QueryContext(ctx, query, append(args, limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: execute query: %w", op, err)
+	}
+	defer rows.Close()
+
+	subscriptions := []storage.Subscription{}
+	for rows.Next() {
+		subscription, err := s.scanSubscription(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: scan row: %w", op, err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("%s: rows iteration: %w", op, err)
+	}
+
+	return subscriptions, total, nil
+}
+
+func (s *Storage) GetSubscription(ctx context.Context, id int64) (storage.Subscription, error) {
+	const op = "storage.postgres.GetSubscription"
+
+	row := s.db.QueryRowContext(ctx, "SELECT id, email, status, pairs, created_at FROM email WHERE id = $1", id)
+	subscription, err := s.scanSubscription(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Subscription{}, fmt.Errorf("%s: %w", op, storage.SubscriptionNotFound)
+	}
+	if err != nil {
+		return storage.Subscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return subscription, nil
+}
+
+// UpdateSubscription stores the status and pairs of the subscription with the given id
+func (s *Storage) UpdateSubscription(ctx context.Context, subscription storage.Subscription) (storage.Subscription, error) {
+	const op = "storage.postgres.UpdateSubscription"
+
+	row := s.db.QueryRowContext(ctx, "UPDATE email SET status = $1, pairs = $2 WHERE id = $3 RETURNING id, email, status, pairs, created_at",
+		subscription.Status, joinPairs(subscription.Pairs), subscription.ID)
+	updated, err := s.scanSubscription(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Subscription{}, fmt.Errorf("%s: %w", op, storage.SubscriptionNotFound)
+	}
+	if err != nil {
+		return storage.Subscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return updated, nil
+}
+
+// DeleteSubscription deletes a subscription together with its delivery log
+func (s *Storage) DeleteSubscription(ctx context.Context, id int64) error {
+	const op = "storage.postgres.DeleteSubscription"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: begin transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM delivery_log WHERE subscription_id = $1", id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM email WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := expectAffected(res, storage.SubscriptionNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: commit: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for query, stmt := range s.stmts {
+		stmt.Close()
+		delete(s.stmts, query)
+	}
+
+	return s.db.Close()
+}
+
+// prepare returns the statement for query, preparing it on first use. Statements are
+// kept until the storage is closed.
+func (s *Storage) prepare(ctx context.Context, query string) (*sql.Stmt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stmt, ok := s.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := s.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("prepare statement: %w", err)
+	}
+	s.stmts[query] = stmt
+
+	return stmt, nil
+}
+
+func (s *Storage) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	stmt, err := s.prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.ExecContext(ctx, args...)
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func (s *Storage) scanSubscription(row scanner) (storage.Subscription, error) {
+	var (
+		subscription storage.Subscription
+		pairs        string
+	)
+	if err := row.Scan(&subscription.ID, &subscription.Email, &subscription.Status, &pairs, &subscription.CreatedAt); err != nil {
+		return storage.Subscription{}, err
+	}
+	subscription.Pairs = splitPairs(pairs)
+	subscription.CreatedAt = subscription.CreatedAt.UTC()
+
+	email, err := storage.OpenEmail(s.keyring, subscription.Email)
+	if err != nil {
+		return storage.Subscription{}, fmt.Errorf("subscription %d: %w", subscription.ID, err)
+	}
+	subscription.Email = email
+
+	return subscription, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
+
+// expectAffected returns notFound when the statement did not change any row
+func expectAffected(res sql.Result, notFound error) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		return notFound
+	}
+
+	return nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+func joinPairs(pairs []string) string {
+	return strings.Join(pairs, ",")
+}
+
+func splitPairs(pairs string) []string {
+	if pairs == "" {
+		return nil
+	}
+	return strings.Split(pairs, ",")
+}
@@ -0,0 +1,158 @@
+package postgres
+
+import (
+	"context"
+	"currency-rates-notifier/internal/storage"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (s *Storage) SaveDeliveries(ctx context.Context, deliveries []storage.Delivery) error {
+	const op = "storage.postgres.SaveDeliveries"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: begin transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO delivery_log(subscription_id, status, error, created_at) VALUES($1, $2, $3, $4)")
+	if err != nil {
+		return fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+	defer stmt.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, delivery := range deliveries {
+		if delivery.CreatedAt.IsZero() {
+			delivery.CreatedAt = now
+		}
+		if _, err := stmt.ExecContext(ctx, delivery.SubscriptionID, delivery.Status, delivery.Error, delivery.CreatedAt); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: commit: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) ListDeliveries(ctx context.Context, subscriptionID int64) ([]storage.Delivery, error) {
+	const op = "storage.postgres.ListDeliveries"
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id, subscription_id, status, error, created_at FROM delivery_log WHERE subscription_id = $1 ORDER BY id", subscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: execute query: %w", op, err)
+	}
+	defer rows.Close()
+
+	deliveries := []storage.Delivery{}
+	for rows.Next() {
+		var delivery storage.Delivery
+		if err := rows.Scan(&delivery.ID, &delivery.SubscriptionID, &delivery.Status, &delivery.Error, &delivery.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: scan row: %w", op, err)
+		}
+		delivery.CreatedAt = delivery.CreatedAt.UTC()
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: rows iteration: %w", op, err)
+	}
+
+	return deliveries, nil
+}
+
+func (s *Storage) FailedSubscriptionIDs(ctx context.Context, since time.Time) ([]int64, error) {
+	const op = "storage.postgres.FailedSubscriptionIDs"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT d.subscription_id FROM delivery_log d
+		WHERE d.id = (SELECT MAX(id) FROM delivery_log WHERE subscription_id = d.subscription_id)
+		AND d.status = $1 AND d.created_at >= $2
+		ORDER BY d.subscription_id`, storage.DeliveryFailed, since.UTC().Truncate(time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("%s: execute query: %w", op, err)
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("%s: scan row: %w", op, err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: rows iteration: %w", op, err)
+	}
+
+	return ids, nil
+}
+
+// GetSubscriptionByEmail finds a subscription by its address regardless of case
+func (s *Storage) GetSubscriptionByEmail(ctx context.Context, email string) (storage.Subscription, error) {
+	const op = "storage.postgres.GetSubscriptionByEmail"
+
+	var row scanner
+	if s.keyring != nil {
+		// addresses stored before encryption was enabled have no blind index yet
+		row = s.db.QueryRowContext(ctx, "SELECT id, email, status, pairs, created_at FROM email WHERE email_hash = $1 OR lower(email) = lower($2)",
+			s.keyring.Index(email), email)
+	} else {
+		row = s.db.QueryRowContext(ctx, "SELECT id, email, status, pairs, created_at FROM email WHERE lower(email) = lower($1)", email)
+	}
+
+	subscription, err := s.scanSubscription(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Subscription{}, fmt.Errorf("%s: %w", op, storage.SubscriptionNotFound)
+	}
+	if err != nil {
+		return storage.Subscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return subscription, nil
+}
+
+func (s *Storage) EraseSubscription(ctx context.Context, id int64) error {
+	const op = "storage.postgres.EraseSubscription"
+
+	subscription, err := s.GetSubscription(ctx, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: begin transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{"DELETE FROM delivery_log WHERE subscription_id = $1", []any{id}},
+		{"DELETE FROM email WHERE id = $1", []any{id}},
+		{"DELETE FROM bounce WHERE email_hash = $1", []any{s.hasher.Hash(subscription.Email)}},
+		{"UPDATE audit_log SET details = '' WHERE target = $1", []any{storage.SubscriberAuditTarget(id)}},
+		{"INSERT INTO suppression(email_hash, reason, created_at) VALUES($1, $2, $3) ON CONFLICT DO NOTHING",
+			[]any{s.hasher.Hash(subscription.Email), storage.SuppressionErased, time.Now().UTC().Truncate(time.Second)}},
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement.query, statement.args...); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: commit: %w", op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// suppressionChunkSize bounds the parameters of a lookup
+const suppressionChunkSize = 500
+
+func (s *Storage) SuppressEmail(ctx context.Context, email, reason string) error {
+	const op = "storage.postgres.SuppressEmail"
+
+	_, err := s.db.ExecContext(ctx, "INSERT INTO suppression(email_hash, reason, created_at) VALUES($1, $2, $3) ON CONFLICT DO NOTHING",
+		s.hasher.Hash(email), reason, time.Now().UTC().Truncate(time.Second))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SuppressedEmails(ctx context.Context, emails []string) (map[string]struct{}, error) {
+	const op = "storage.postgres.SuppressedEmails"
+
+	suppressed := make(map[string]struct{})
+	for chunk := range slices.Chunk(emails, suppressionChunkSize) {
+		byHash := make(map[string][]string, len(chunk))
+		args := make([]any, 0, len(chunk))
+		placeholders := make([]string, 0, len(chunk))
+		for _, email := range chunk {
+			hash := s.hasher.Hash(email)
+			if _, ok := byHash[hash]; !ok {
+				args = append(args, hash)
+				placeholders = append(placeholders, "$"+strconv.Itoa(len(args)))
+			}
+			byHash[hash] = append(byHash[hash], email)
+		}
+
+		rows, err := s.db.QueryContext(ctx, "SELECT email_hash FROM suppression WHERE email_hash IN ("+strings.Join(placeholders, ", ")+")", args...)
+		if err != nil {
+			return nil, fmt.Errorf("%s: execute query: %w", op, err)
+		}
+		for rows.Next() {
+			var hash string
+			if err := rows.Scan(&hash); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("%s: scan row: %w", op, err)
+			}
+			for _, email := range byHash[hash] {
+				suppressed[email] = struct{}{}
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: rows iteration: %w", op, err)
+		}
+	}
+
+	return suppressed, nil
+}
+
+// RecordHardBounce counts a hard bounce, unless the event with eventID was counted before
+func (s *Storage) RecordHardBounce(ctx context.Context, email, eventID string) (int, error) {
+	const op = "storage.postgres.RecordHardBounce"
+
+	now := time.Now().UTC().Truncate(time.Second)
+	hash := s.hasher.Hash(email)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: begin transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if eventID != "" {
+		res, err := tx.ExecContext(ctx, "INSERT INTO bounce_event(id, created_at) VALUES($1, $2) ON CONFLICT DO NOTHING", eventID, now)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+		if affected, err := res.RowsAffected(); err != nil {
+			return 0, fmt.Errorf("%s: rows affected: %w", op, err)
+		} else if affected == 0 {
+			var count int
+			err := tx.QueryRowContext(ctx, "SELECT hard_bounces FROM bounce WHERE email_hash = $1", hash).Scan(&count)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return 0, fmt.Errorf("%s: %w", op, err)
+			}
+			return count, nil
+		}
+	}
+
+	var count int
+	err = tx.QueryRowContext(ctx, `INSERT INTO bounce(email_hash, hard_bounces, last_bounced_at) VALUES($1, 1, $2)
+		ON CONFLICT(email_hash) DO UPDATE SET hard_bounces = bounce.hard_bounces + 1, last_bounced_at = excluded.last_bounced_at
+		RETURNING hard_bounces`,
+		hash, now).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: commit: %w", op, err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,135 @@
+package sqlite
+
+import (
+	"context"
+	"currency-rates-notifier/internal/storage"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (s *Storage) SaveAPIKey(ctx context.Context, key storage.APIKey) (storage.APIKey, error) {
+	const op = "storage.sqlite.SaveAPIKey"
+
+	key.CreatedAt = time.Now().UTC().Truncate(time.Second)
+
+	res, err := s.exec(ctx, "INSERT INTO api_key(name, scope, key_hash, created_at) VALUES(?, ?, ?, ?)",
+		key.Name, key.Scope, key.Hash, key.CreatedAt)
+	if err != nil {
+		return storage.APIKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	key.ID, err = res.LastInsertId()
+	if err != nil {
+		return storage.APIKey{}, fmt.Errorf("%s: last insert id: %w", op, err)
+	}
+
+	return key, nil
+}
+
+func (s *Storage) GetAPIKeyByHash(ctx context.Context, hash string) (storage.APIKey, error) {
+	const op = "storage.sqlite.GetAPIKeyByHash"
+
+	var key storage.APIKey
+	err := s.queryRow(ctx, "SELECT id, name, scope, key_hash, created_at FROM api_key WHERE key_hash = ?", hash).
+		Scan(&key.ID, &key.Name, &key.Scope, &key.Hash, &key.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.APIKey{}, fmt.Errorf("%s: %w", op, storage.APIKeyNotFound)
+	}
+	if err != nil {
+		return storage.APIKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
+}
+
+func (s *Storage) ListAPIKeys(ctx context.Context) ([]storage.APIKey, error) {
+	const op = "storage.sqlite.ListAPIKeys"
+
+	rows, err := s.query(ctx, "SELECT id, name, scope, key_hash, created_at FROM api_key ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("%s: execute query: %w", op, err)
+	}
+	defer rows.Close()
+
+	keys := []storage.APIKey{}
+	for rows.Next() {
+		var key storage.APIKey
+		if err := rows.Scan(&key.ID, &key.Name, &key.Scope, &key.Hash, &key.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: scan row: %w", op, err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: rows iteration: %w", op, err)
+	}
+
+	return keys, nil
+}
+
+func (s *Storage) DeleteAPIKey(ctx context.Context, id int64) error {
+	const op = "storage.sqlite.DeleteAPIKey"
+
+	res, err := s.exec(ctx, "DELETE FROM api_key WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := expectAffected(res, storage.APIKeyNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveAuditEntry(ctx context.Context, entry storage.AuditEntry) error {
+	const op = "storage.sqlite.SaveAuditEntry"
+
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	}
+
+	_, err := s.exec(ctx, "INSERT INTO audit_log(actor, action, target, details, request_id, created_at) VALUES(?, ?, ?, ?, ?, ?)",
+		entry.Actor, entry.Action, entry.Target, entry.Details, entry.RequestID, entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ListAuditEntries returns a page of the audit log, newest first, and its total size
+func (s *Storage) ListAuditEntries(ctx context.Context, limit, offset int) ([]storage.AuditEntry, int, error) {
+	const op = "storage.sqlite.ListAuditEntries"
+
+	var total int
+	if err := s.queryRow(ctx, "SELECT count(*) FROM audit_log").Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("%s: count: %w", op, err)
+	}
+
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := s.query(ctx, "SELECT id, actor, action, target, details, request_id, created_at FROM audit_log ORDER BY id DESC LIMIT ? OFFSET ?",
+		limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: execute query: %w", op, err)
+	}
+	defer rows.Close()
+
+	entries := []storage.AuditEntry{}
+	for rows.Next() {
+		var entry storage.AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.Target, &entry.Details, &entry.RequestID, &entry.CreatedAt); err != nil {
+			return nil, 0, fmt.Errorf("%s: scan row: %w", op, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("%s: rows iteration: %w", op, err)
+	}
+
+	return entries, total, nil
+}
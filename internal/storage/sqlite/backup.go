@@ -0,0 +1,119 @@
+package sqlite
+
+import (
+	"context"
+	"currency-rates-notifier/internal/storage/migrate"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// Backup writes a consistent copy of the database to path while it is in use. The copy
+// is compacted and must not exist yet.
+func (s *Storage) Backup(ctx context.Context, path string) error {
+	const op = "storage.sqlite.Backup"
+
+	if _, err := s.db.ExecContext(ctx, "VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Restore replaces the database at storagePath with the backup at backupPath and returns
+// the schema version of the backup. The backup must pass an integrity check and must not
+// be newer than the migrations of this build, older backups are migrated when the
+// database is opened next. Nothing may use the database while it is restored.
+func Restore(ctx context.Context, backupPath, storagePath string) (int, error) {
+	const op = "storage.sqlite.Restore"
+
+	// the backup is checked on a copy next to the database, so the rename that replaces
+	// the database installs exactly what was checked and cannot cross file systems
+	restorePath := storagePath + ".restore"
+	// left over by an interrupted restore
+	if err := os.Remove(restorePath); err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := copyFile(backupPath, restorePath); err != nil {
+		return 0, fmt.Errorf("%s: copy backup: %w", op, err)
+	}
+	defer os.Remove(restorePath)
+
+	version, err := checkBackup(ctx, restorePath)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// a journal left by the replaced database would be applied to the restored one
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		if err := os.Remove(storagePath + suffix); err != nil && !os.IsNotExist(err) {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	if err := os.Rename(restorePath, storagePath); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return version, nil
+}
+
+func checkBackup(ctx context.Context, path string) (int, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var integrity string
+	if err := db.QueryRowContext(ctx, "PRAGMA integrity_check").Scan(&integrity); err != nil {
+		return 0, fmt.Errorf("check integrity: %w", err)
+	}
+	if integrity != "ok" {
+		return 0, fmt.Errorf("the backup is corrupt: %s", integrity)
+	}
+
+	fsys, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return 0, err
+	}
+	migrator, err := migrate.New(db, fsys)
+	if err != nil {
+		return 0, err
+	}
+	version, err := migrator.Version(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	if version == 0 {
+		return 0, fmt.Errorf("the backup has no schema version, it is not a backup of this application")
+	}
+	if version > migrator.Latest() {
+		return 0, fmt.Errorf("%w: the backup has version %d, latest known is %d", migrate.SchemaTooNew, version, migrator.Latest())
+	}
+
+	return version, nil
+}
+
+func copyFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
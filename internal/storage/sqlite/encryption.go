@@ -0,0 +1,26 @@
+package sqlite
+
+import (
+	"context"
+	"currency-rates-notifier/internal/storage"
+	"fmt"
+)
+
+var reencryptQueries = storage.ReencryptQueries{
+	Select:            "SELECT id, email, email_hash FROM email WHERE id > ? ORDER BY id LIMIT ?",
+	Update:            "UPDATE email SET email = ?, email_hash = ? WHERE id = ?",
+	IsUniqueViolation: isUniqueViolation,
+}
+
+// ReencryptSubscriptions encrypts the addresses that are not encrypted with the current
+// key, see storage.ReencryptSubscriptions
+func (s *Storage) ReencryptSubscriptions(ctx context.Context) (int, error) {
+	const op = "storage.sqlite.ReencryptSubscriptions"
+
+	updated, err := storage.ReencryptSubscriptions(ctx, s.db, s.keyring, reencryptQueries)
+	if err != nil {
+		return updated, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return updated, nil
+}
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS api_key;
+DROP INDEX IF EXISTS email_email_lower_idx;
+DROP TABLE IF EXISTS email;
@@ -0,0 +1,28 @@
+-- Baseline schema. Databases created before migrations existed already have these
+-- tables, so every statement is idempotent.
+CREATE TABLE IF NOT EXISTS email(
+    id INTEGER PRIMARY KEY,
+    email TEXT NOT NULL UNIQUE,
+    status TEXT NOT NULL DEFAULT 'active',
+    pairs TEXT NOT NULL DEFAULT 'USD/UAH',
+    created_at DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00');
+
+-- addresses are stored with a normalised domain, so the remaining case
+-- differences are in the local part, which most providers ignore
+CREATE UNIQUE INDEX IF NOT EXISTS email_email_lower_idx ON email(lower(email));
+
+CREATE TABLE IF NOT EXISTS api_key(
+    id INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    created_at DATETIME NOT NULL);
+
+CREATE TABLE IF NOT EXISTS audit_log(
+    id INTEGER PRIMARY KEY,
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL,
+    target TEXT NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL);
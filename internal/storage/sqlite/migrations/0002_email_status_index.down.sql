@@ -0,0 +1 @@
+DROP INDEX email_status_idx;
@@ -0,0 +1,2 @@
+-- the notifier and the admin API filter subscriptions by status
+CREATE INDEX email_status_idx ON email(status);
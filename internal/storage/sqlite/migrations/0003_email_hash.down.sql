@@ -0,0 +1,2 @@
+DROP INDEX email_email_hash_idx;
+ALTER TABLE email DROP COLUMN email_hash;
@@ -0,0 +1,13 @@
+CREATE TABLE delivery_log(
+    id INTEGER PRIMARY KEY,
+    subscription_id INTEGER NOT NULL,
+    status TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL);
+CREATE INDEX delivery_log_subscription_id_idx ON delivery_log(subscription_id);
+
+-- addresses that imports must skip, identified by storage.SuppressionHash
+CREATE TABLE suppression(
+    email_hash TEXT PRIMARY KEY,
+    reason TEXT NOT NULL,
+    created_at DATETIME NOT NULL);
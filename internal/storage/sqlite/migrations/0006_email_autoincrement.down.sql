@@ -0,0 +1,15 @@
+CREATE TABLE email_old(
+    id INTEGER PRIMARY KEY,
+    email TEXT NOT NULL UNIQUE,
+    status TEXT NOT NULL DEFAULT 'active',
+    pairs TEXT NOT NULL DEFAULT 'USD/UAH',
+    created_at DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00',
+    email_hash TEXT);
+INSERT INTO email_old(id, email, status, pairs, created_at, email_hash)
+    SELECT id, email, status, pairs, created_at, email_hash FROM email;
+DROP TABLE email;
+ALTER TABLE email_old RENAME TO email;
+
+CREATE UNIQUE INDEX email_email_lower_idx ON email(lower(email));
+CREATE INDEX email_status_idx ON email(status);
+CREATE UNIQUE INDEX email_email_hash_idx ON email(email_hash);
@@ -0,0 +1,27 @@
+-- ids of deleted subscriptions must not be handed out again, or a new subscriber
+-- would inherit the delivery log and audit trail of a deleted one
+CREATE TABLE email_new(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    email TEXT NOT NULL UNIQUE,
+    status TEXT NOT NULL DEFAULT 'active',
+    pairs TEXT NOT NULL DEFAULT 'USD/UAH',
+    created_at DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00',
+    email_hash TEXT);
+INSERT INTO email_new(id, email, status, pairs, created_at, email_hash)
+    SELECT id, email, status, pairs, created_at, email_hash FROM email;
+DROP TABLE email;
+ALTER TABLE email_new RENAME TO email;
+
+CREATE UNIQUE INDEX email_email_lower_idx ON email(lower(email));
+CREATE INDEX email_status_idx ON email(status);
+CREATE UNIQUE INDEX email_email_hash_idx ON email(email_hash);
+
+-- continue after every id that was ever referenced, including deleted subscriptions
+DELETE FROM sqlite_sequence WHERE name = 'email';
+INSERT INTO sqlite_sequence(name, seq) SELECT 'email', max(
+    (SELECT coalesce(max(id), 0) FROM email),
+    (SELECT coalesce(max(subscription_id), 0) FROM delivery_log),
+    (SELECT coalesce(max(CAST(substr(target, 12) AS INTEGER)), 0) FROM audit_log WHERE target LIKE 'subscriber/%'));
+
+-- what is left of deleted subscriptions
+DELETE FROM delivery_log WHERE subscription_id NOT IN (SELECT id FROM email);
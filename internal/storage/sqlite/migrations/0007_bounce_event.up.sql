@@ -0,0 +1,5 @@
+-- bounce events that were counted already, a report that is delivered again, e.g. when a
+-- webhook is retried, is not counted twice
+CREATE TABLE bounce_event(
+    id TEXT PRIMARY KEY,
+    created_at DATETIME NOT NULL);
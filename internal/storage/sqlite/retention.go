@@ -0,0 +1,41 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+func (s *Storage) PruneDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	const op = "storage.sqlite.PruneDeliveries"
+
+	res, err := s.exec(ctx, "DELETE FROM delivery_log WHERE created_at < ?", before.UTC().Truncate(time.Second))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	pruned, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return pruned, nil
+}
+
+func (s *Storage) PruneBounces(ctx context.Context, before time.Time) (int64, error) {
+	const op = "storage.sqlite.PruneBounces"
+
+	res, err := s.exec(ctx, "DELETE FROM bounce WHERE last_bounced_at < ?", before.UTC().Truncate(time.Second))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	pruned, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := s.exec(ctx, "DELETE FROM bounce_event WHERE created_at < ?", before.UTC().Truncate(time.Second)); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return pruned, nil
+}
@@ -1,88 +1,559 @@
 package sqlite
 
 import (
+	"context"
+	"currency-rates-notifier/internal/lib/fieldcrypt"
 	"currency-rates-notifier/internal/storage"
+	"currency-rates-notifier/internal/storage/migrate"
 	"database/sql"
+	"embed"
+	"errors"
 	"fmt"
 	"github.com/mattn/go-sqlite3"
+	"io/fs"
+	"strings"
+	"sync"
+	"time"
 )
 
+// insertSubscription inserts nothing when the address is stored in plaintext, as it is
+// until "api-server reencrypt" has run after encryption was enabled. Such a row has no
+// blind index for the unique index to catch.
+const insertSubscription = "INSERT INTO email(email, email_hash, status, pairs, created_at) SELECT ?, ?, ?, ?, ? " +
+	"WHERE NOT EXISTS(SELECT 1 FROM email WHERE email_hash IS NULL AND lower(email) = lower(?))"
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
 type Storage struct {
-	db *sql.DB
+	db       *sql.DB
+	migrator *migrate.Migrator
+	keyring  *fieldcrypt.Keyring
+	hasher   storage.SuppressionHasher
+
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
 }
 
-func New(storagePath string) (*Storage, error) {
+type options struct {
+	migrate        bool
+	keyring        *fieldcrypt.Keyring
+	suppressionKey []byte
+}
+
+type Option func(*options)
+
+// WithoutMigrations opens the database as is, leaving migrations to Migrator
+func WithoutMigrations() Option {
+	return func(o *options) {
+		o.migrate = false
+	}
+}
+
+// WithEncryption encrypts email addresses with the keyring and keeps their blind index
+// for uniqueness and lookups. Addresses stored before are read as they are until
+// ReencryptSubscriptions encrypts them.
+func WithEncryption(keyring *fieldcrypt.Keyring) Option {
+	return func(o *options) {
+		o.keyring = keyring
+	}
+}
+
+// WithSuppressionKey keys the hashes that identify suppressed and bouncing addresses. It
+// is required, and changing it forgets every suppression and bounce count.
+func WithSuppressionKey(key []byte) Option {
+	return func(o *options) {
+		o.suppressionKey = key
+	}
+}
+
+// New opens the database and applies pending migrations unless WithoutMigrations is given
+func New(storagePath string, opts ...Option) (*Storage, error) {
 	const op = "storage.sqlite.New"
 
+	o := options{migrate: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if len(o.suppressionKey) == 0 {
+		return nil, fmt.Errorf("%s: a suppression key is required", op)
+	}
+
 	db, err := sql.Open("sqlite3", storagePath)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	stmt, err := db.Prepare(`
-	CREATE TABLE IF NOT EXISTS email(
-		id INTEGER PRIMARY KEY,
-		email TEXT NOT NULL UNIQUE);
+	if err := adoptLegacySchema(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
 
-	`)
+	fsys, err := fs.Sub(migrations, "migrations")
 	if err != nil {
+		db.Close()
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
-
-	_, err = stmt.Exec()
+	migrator, err := migrate.New(db, fsys)
 	if err != nil {
+		db.Close()
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return &Storage{db: db}, nil
+	if o.migrate {
+		if _, err := migrator.Up(context.Background()); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return &Storage{db: db, migrator: migrator, keyring: o.keyring, hasher: storage.NewSuppressionHasher(o.suppressionKey), stmts: make(map[string]*sql.Stmt)}, nil
 }
 
-func (s *Storage) SaveEmail(email string) error {
-	const op = "storage.sqlite.SaveEmail"
+func (s *Storage) Migrator() *migrate.Migrator {
+	return s.migrator
+}
 
-	stmt, err := s.db.Prepare("INSERT INTO email(email) VALUES(?)")
+// adoptLegacySchema brings databases created before migrations existed to the shape of
+// the baseline migration, whose statements are idempotent, so that it can be recorded
+// as applied. Databases without subscription status got the new columns with defaults.
+// Addresses that are stored twice in different case have to be merged by hand first, as
+// the baseline cannot create its case-insensitive unique index over them.
+func adoptLegacySchema(db *sql.DB) error {
+	var hasEmail, hasMigrations bool
+	err := db.QueryRow(`SELECT
+		EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'email'),
+		EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations')`).Scan(&hasEmail, &hasMigrations)
 	if err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+		return fmt.Errorf("inspect schema: %w", err)
+	}
+	if !hasEmail || hasMigrations {
+		return nil
 	}
 
-	_, err = stmt.Exec(email)
+	columns := []struct{ name, definition string }{
+		{"status", "TEXT NOT NULL DEFAULT 'active'"},
+		{"pairs", "TEXT NOT NULL DEFAULT 'USD/UAH'"},
+		{"created_at", "DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00'"},
+	}
+	for _, column := range columns {
+		if err := addColumnIfMissing(db, "email", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+
+	return checkCaseDuplicates(db)
+}
+
+func checkCaseDuplicates(db *sql.DB) error {
+	rows, err := db.Query(`SELECT group_concat(id, ', ') FROM (SELECT id, email FROM email ORDER BY id)
+		GROUP BY lower(email) HAVING count(*) > 1 ORDER BY min(id) LIMIT 10`)
 	if err != nil {
-		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
-			return fmt.Errorf("%s: %w", op, storage.EmailExists)
+		return fmt.Errorf("find duplicate addresses: %w", err)
+	}
+	defer rows.Close()
+
+	var groups []string
+	for rows.Next() {
+		var ids string
+		if err := rows.Scan(&ids); err != nil {
+			return fmt.Errorf("find duplicate addresses: %w", err)
 		}
+		groups = append(groups, "("+ids+")")
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("find duplicate addresses: %w", err)
+	}
+	if len(groups) > 0 {
+		return fmt.Errorf("the same address is stored in different case by subscriptions %s, keep one subscription of each group and start again",
+			strings.Join(groups, ", "))
+	}
 
-		return fmt.Errorf("%s: %w", op, err)
+	return nil
+}
+
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return fmt.Errorf("inspect table %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("inspect table %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("inspect table %s: %w", table, err)
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("add column %s.%s: %w", table, column, err)
 	}
 
 	return nil
 }
 
-func (s *Storage) GetAllEmails() ([]string, error) {
-	const op = "storage.sqlite.GetAllEmails"
-	stmt, err := s.db.Prepare("SELECT email FROM email")
+func (s *Storage) SaveSubscription(ctx context.Context, subscription storage.Subscription) (storage.Subscription, error) {
+	const op = "storage.sqlite.SaveSubscription"
+
+	email, emailHash, err := storage.SealEmail(s.keyring, subscription.Email)
+	if err != nil {
+		return storage.Subscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+	subscription.CreatedAt = time.Now().UTC().Truncate(time.Second)
+
+	res, err := s.exec(ctx, insertSubscription,
+		email, emailHash, subscription.Status, joinPairs(subscription.Pairs), subscription.CreatedAt, subscription.Email)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.Subscription{}, fmt.Errorf("%s: %w", op, storage.EmailExists)
+		}
+
+		return storage.Subscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return storage.Subscription{}, fmt.Errorf("%s: rows affected: %w", op, err)
+	} else if affected == 0 {
+		return storage.Subscription{}, fmt.Errorf("%s: %w", op, storage.EmailExists)
+	}
+
+	subscription.ID, err = res.LastInsertId()
+	if err != nil {
+		return storage.Subscription{}, fmt.Errorf("%s: last insert id: %w", op, err)
+	}
+
+	return subscription, nil
+}
+
+// ImportSubscriptions inserts a batch of subscriptions in one transaction. The returned
+// slice holds storage.EmailExists for every subscription that was already stored,
+// storage.EmailSuppressed for suppressed addresses and nil for the others. With dryRun
+// the transaction is rolled back, so the result only tells what an import would do.
+func (s *Storage) ImportSubscriptions(ctx context.Context, subscriptions []storage.Subscription, dryRun bool) ([]error, error) {
+	const op = "storage.sqlite.ImportSubscriptions"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: begin transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	insert, err := s.prepare(ctx, insertSubscription)
 	if err != nil {
-		return nil, fmt.Errorf("%s: prepare statement: %w", op, err)
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
+	stmt := tx.StmtContext(ctx, insert)
 	defer stmt.Close()
+	suppressed, err := s.prepare(ctx, "SELECT EXISTS(SELECT 1 FROM suppression WHERE email_hash = ?)")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	suppressedStmt := tx.StmtContext(ctx, suppressed)
+	defer suppressedStmt.Close()
 
-	rows, err := stmt.Query()
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	results := make([]error, len(subscriptions))
+	for i, subscription := range subscriptions {
+		var isSuppressed bool
+		if err := suppressedStmt.QueryRowContext(ctx, s.hasher.Hash(subscription.Email)).Scan(&isSuppressed); err != nil {
+			return nil, fmt.Errorf("%s: check suppression: %w", op, err)
+		}
+		if isSuppressed {
+			results[i] = storage.EmailSuppressed
+			continue
+		}
+
+		email, emailHash, err := storage.SealEmail(s.keyring, subscription.Email)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		res, err := stmt.ExecContext(ctx, email, emailHash, subscription.Status, joinPairs(subscription.Pairs), createdAt, subscription.Email)
+		if isUniqueViolation(err) {
+			results[i] = storage.EmailExists
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if affected, err := res.RowsAffected(); err != nil {
+			return nil, fmt.Errorf("%s: rows affected: %w", op, err)
+		} else if affected == 0 {
+			results[i] = storage.EmailExists
+		}
+	}
+
+	if dryRun {
+		return results, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%s: commit: %w", op, err)
+	}
+
+	return results, nil
+}
+
+// GetActiveSubscriptionsAfter returns up to limit active subscriptions with an id
+// greater than afterID, ordered by id
+func (s *Storage) GetActiveSubscriptionsAfter(ctx context.Context, afterID int64, limit int) ([]storage.Subscription, error) {
+	const op = "storage.sqlite.GetActiveSubscriptionsAfter"
+
+	rows, err := s.query(ctx, "SELECT id, email, status, pairs, created_at FROM email WHERE status = ? AND id > ? ORDER BY id LIMIT ?", storage.StatusActive, afterID, limit)
 	if err != nil {
 		return nil, fmt.Errorf("%s: execute query: %w", op, err)
 	}
 	defer rows.Close()
 
-	var urls []string
+	subscriptions := make([]storage.Subscription, 0, limit)
 	for rows.Next() {
-		var url string
-		if err := rows.Scan(&url); err != nil {
+		subscription, err := s.scanSubscription(rows)
+		if err != nil {
 			return nil, fmt.Errorf("%s: scan row: %w", op, err)
 		}
-		urls = append(urls, url)
+		subscriptions = append(subscriptions, subscription)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("%s: rows iteration: %w", op, err)
 	}
 
-	return urls, nil
+	return subscriptions, nil
+}
+
+// ListSubscriptions returns a page of subscriptions ordered by id and the number of
+// subscriptions matching the filter regardless of paging.
+func (s *Storage) ListSubscriptions(ctx context.Context, filter storage.SubscriptionFilter) ([]storage.Subscription, int, error) {
+	const op = "storage.sqlite.ListSubscriptions"
+
+	where := " WHERE 1 = 1"
+	var args []any
+	if filter.Search != "" && s.keyring != nil {
+		// encrypted addresses only match as a whole
+		where += ` AND (email LIKE ? ESCAPE '\' OR email_hash = ?)`
+		args = append(args, "%"+escapeLike(filter.Search)+"%", s.keyring.Index(filter.Search))
+	} else if filter.Search != "" {
+		where += ` AND email LIKE ? ESCAPE '\'`
+		args = append(args, "%"+escapeLike(filter.Search)+"%")
+	}
+	if filter.Status != "" {
+		where += " AND status = ?"
+		args = append(args, filter.Status)
+	}
+
+	var total int
+	if err := s.queryRow(ctx, "SELECT count(*) FROM email"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("%s: count: %w", op, err)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := s.query(ctx, "SELECT id, email, status, pairs, created_at FROM email"+where+" ORDER BY id LIMIT ? OFFSET ?",
+		append(args, limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: execute query: %w", op, err)
+	}
+	defer rows.Close()
+
+	subscriptions := []storage.Subscription{}
+	for rows.Next() {
+		subscription, err := s.scanSubscription(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: scan row: %w", op, err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("%s: rows iteration: %w", op, err)
+	}
+
+	return subscriptions, total, nil
+}
+
+func (s *Storage) GetSubscription(ctx context.Context, id int64) (storage.Subscription, error) {
+	const op = "storage.sqlite.GetSubscription"
+
+	row := s.queryRow(ctx, "SELECT id, email, status, pairs, created_at FROM email WHERE id = ?", id)
+	subscription, err := s.scanSubscription(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Subscription{}, fmt.Errorf("%s: %w", op, storage.SubscriptionNotFound)
+	}
+	if err != nil {
+		return storage.Subscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return subscription, nil
+}
+
+// UpdateSubscription stores the status and pairs of the subscription with the given id
+func (s *Storage) UpdateSubscription(ctx context.Context, subscription storage.Subscription) (storage.Subscription, error) {
+	const op = "storage.sqlite.UpdateSubscription"
+
+	res, err := s.exec(ctx, "UPDATE email SET status = ?, pairs = ? WHERE id = ?",
+		subscription.Status, joinPairs(subscription.Pairs), subscription.ID)
+	if err != nil {
+		return storage.Subscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := expectAffected(res, storage.SubscriptionNotFound); err != nil {
+		return storage.Subscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return s.GetSubscription(ctx, subscription.ID)
+}
+
+// DeleteSubscription deletes a subscription together with its delivery log
+func (s *Storage) DeleteSubscription(ctx context.Context, id int64) error {
+	const op = "storage.sqlite.DeleteSubscription"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: begin transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM delivery_log WHERE subscription_id = ?", id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM email WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := expectAffected(res, storage.SubscriptionNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: commit: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for query, stmt := range s.stmts {
+		stmt.Close()
+		delete(s.stmts, query)
+	}
+
+	return s.db.Close()
+}
+
+// prepare returns the statement for query, preparing it on first use. Statements are
+// kept until the storage is closed.
+func (s *Storage) prepare(ctx context.Context, query string) (*sql.Stmt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stmt, ok := s.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := s.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("prepare statement: %w", err)
+	}
+	s.stmts[query] = stmt
+
+	return stmt, nil
+}
+
+func (s *Storage) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	stmt, err := s.prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.ExecContext(ctx, args...)
+}
+
+func (s *Storage) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	stmt, err := s.prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.QueryContext(ctx, args...)
+}
+
+func (s *Storage) queryRow(ctx context.Context, query string, args ...any) scanner {
+	stmt, err := s.prepare(ctx, query)
+	if err != nil {
+		return errRow{err}
+	}
+	return stmt.QueryRowContext(ctx, args...)
+}
+
+func joinPairs(pairs []string) string {
+	return strings.Join(pairs, ",")
+}
+
+func splitPairs(pairs string) []string {
+	if pairs == "" {
+		return nil
+	}
+	return strings.Split(pairs, ",")
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// errRow is a row whose statement could not be prepared
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(...any) error {
+	return r.err
+}
+
+func (s *Storage) scanSubscription(row scanner) (storage.Subscription, error) {
+	var (
+		subscription storage.Subscription
+		pairs        string
+	)
+	if err := row.Scan(&subscription.ID, &subscription.Email, &subscription.Status, &pairs, &subscription.CreatedAt); err != nil {
+		return storage.Subscription{}, err
+	}
+	subscription.Pairs = splitPairs(pairs)
+
+	email, err := storage.OpenEmail(s.keyring, subscription.Email)
+	if err != nil {
+		return storage.Subscription{}, fmt.Errorf("subscription %d: %w", subscription.ID, err)
+	}
+	subscription.Email = email
+
+	return subscription, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// expectAffected returns notFound when the statement did not change any row
+func expectAffected(res sql.Result, notFound error) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		return notFound
+	}
+
+	return nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
 }
@@ -0,0 +1,239 @@
+package sqlite
+
+import (
+	"context"
+	"currency-rates-notifier/internal/storage"
+	"currency-rates-notifier/internal/storage/migrate"
+	"currency-rates-notifier/internal/storage/storagetest"
+	"database/sql"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrationsApplyAndRevert(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(filepath.Join(t.TempDir(), "storage.db"), WithSuppressionKey(storagetest.SuppressionKey))
+	require.NoError(t, err)
+	defer s.Close()
+
+	version, err := s.Migrator().Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, s.Migrator().Latest(), version)
+
+	_, err = s.SaveSubscription(ctx, storage.Subscription{Email: "user@example.com", Status: storage.StatusActive, Pairs: []string{"USD/UAH"}})
+	require.NoError(t, err)
+
+	reverted, err := s.Migrator().Down(ctx, version)
+	require.NoError(t, err)
+	require.Len(t, reverted, version)
+
+	var tables int
+	require.NoError(t, s.db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name != 'schema_migrations' AND name NOT LIKE 'sqlite_%'").Scan(&tables))
+	require.Zero(t, tables)
+
+	applied, err := s.Migrator().Up(ctx)
+	require.NoError(t, err)
+	require.Len(t, applied, version)
+}
+
+func TestDeletedSubscriptionIDsAreNotReused(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(filepath.Join(t.TempDir(), "storage.db"), WithSuppressionKey(storagetest.SuppressionKey))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// a subscription deleted before ids were kept unique left its delivery log behind
+	_, err = s.Migrator().Down(ctx, s.Migrator().Latest()-5)
+	require.NoError(t, err)
+	_, err = s.db.Exec(`INSERT INTO email(id, email) VALUES (1, 'kept@example.com');
+		INSERT INTO delivery_log(subscription_id, status, created_at) VALUES (1, 'sent', '2026-01-01 00:00:00'), (2, 'failed', '2026-01-01 00:00:00')`)
+	require.NoError(t, err)
+	_, err = s.Migrator().Up(ctx)
+	require.NoError(t, err)
+
+	deliveries, err := s.ListDeliveries(ctx, 2)
+	require.NoError(t, err)
+	require.Empty(t, deliveries)
+	deliveries, err = s.ListDeliveries(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+
+	saved, err := s.SaveSubscription(ctx, storage.Subscription{Email: "new@example.com", Status: storage.StatusActive, Pairs: []string{"USD/UAH"}})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), saved.ID, "the id of the deleted subscription is skipped")
+}
+
+func TestSuppressionKeyIsRequired(t *testing.T) {
+	_, err := New(filepath.Join(t.TempDir(), "storage.db"))
+	require.ErrorContains(t, err, "suppression key is required")
+}
+
+func TestLegacyDatabaseIsAdopted(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.db")
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	_, err = db.Exec(`CREATE TABLE email(id INTEGER PRIMARY KEY, email TEXT NOT NULL UNIQUE); INSERT INTO email(email) VALUES ('old@example.com');`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	s, err := New(path, WithSuppressionKey(storagetest.SuppressionKey))
+	require.NoError(t, err)
+	defer s.Close()
+
+	subscriptions, err := s.GetActiveSubscriptionsAfter(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, subscriptions, 1)
+	require.Equal(t, []string{"USD/UAH"}, subscriptions[0].Pairs)
+
+	pending, err := s.Migrator().Pending(ctx)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+}
+
+func TestLegacyDatabaseWithCaseDuplicates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.db")
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	_, err = db.Exec(`CREATE TABLE email(id INTEGER PRIMARY KEY, email TEXT NOT NULL UNIQUE);
+		INSERT INTO email(email) VALUES ('user@example.com'), ('other@example.com'), ('User@example.com'), ('USER@example.com');`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = New(path, WithSuppressionKey(storagetest.SuppressionKey))
+	require.ErrorContains(t, err, "subscriptions (1, 3, 4)")
+
+	db, err = sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`DELETE FROM email WHERE id IN (3, 4)`)
+	require.NoError(t, err)
+
+	s, err := New(path, WithSuppressionKey(storagetest.SuppressionKey))
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		s, err := New(filepath.Join(t.TempDir(), "storage.db"), WithSuppressionKey(storagetest.SuppressionKey))
+		require.NoError(t, err)
+		return s
+	})
+}
+
+func TestConformanceWithEncryption(t *testing.T) {
+	storagetest.RunEncrypted(t, func(t *testing.T) storage.Store {
+		s, err := New(filepath.Join(t.TempDir(), "storage.db"), WithSuppressionKey(storagetest.SuppressionKey), WithEncryption(storagetest.Keyring(t, "k1")))
+		require.NoError(t, err)
+		return s
+	})
+}
+
+func TestReencryptSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.db")
+
+	plain, err := New(path, WithSuppressionKey(storagetest.SuppressionKey))
+	require.NoError(t, err)
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		_, err := plain.SaveSubscription(ctx, storage.Subscription{Email: email, Status: storage.StatusActive, Pairs: []string{"USD/UAH"}})
+		require.NoError(t, err)
+	}
+	_, err = plain.ReencryptSubscriptions(ctx)
+	require.ErrorIs(t, err, storage.EncryptionDisabled)
+	require.NoError(t, plain.Close())
+
+	// plaintext rows have no blind index yet, they must still keep addresses unique
+	unmigrated, err := New(path, WithSuppressionKey(storagetest.SuppressionKey), WithEncryption(storagetest.Keyring(t, "k1")))
+	require.NoError(t, err)
+	_, err = unmigrated.SaveSubscription(ctx, storage.Subscription{Email: "A@example.com", Status: storage.StatusActive})
+	require.ErrorIs(t, err, storage.EmailExists)
+	results, err := unmigrated.ImportSubscriptions(ctx, []storage.Subscription{
+		{Email: "b@example.com", Status: storage.StatusActive}, {Email: "d@example.com", Status: storage.StatusActive},
+	}, true)
+	require.NoError(t, err)
+	require.ErrorIs(t, results[0], storage.EmailExists)
+	require.NoError(t, results[1])
+	require.NoError(t, unmigrated.Close())
+
+	for _, current := range []string{"k1", "k2"} {
+		s, err := New(path, WithSuppressionKey(storagetest.SuppressionKey), WithEncryption(storagetest.Keyring(t, current)))
+		require.NoError(t, err)
+
+		updated, err := s.ReencryptSubscriptions(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 3, updated)
+		updated, err = s.ReencryptSubscriptions(ctx)
+		require.NoError(t, err)
+		require.Zero(t, updated, "addresses encrypted with the current key are left alone")
+
+		var stored string
+		require.NoError(t, s.db.QueryRow("SELECT email FROM email ORDER BY id LIMIT 1").Scan(&stored))
+		require.True(t, strings.HasPrefix(stored, "enc:"+current+":"))
+
+		found, _, err := s.ListSubscriptions(ctx, storage.SubscriptionFilter{Search: "B@example.com"})
+		require.NoError(t, err)
+		require.Len(t, found, 1)
+		require.Equal(t, "b@example.com", found[0].Email)
+
+		_, err = s.SaveSubscription(ctx, storage.Subscription{Email: "C@example.com", Status: storage.StatusActive})
+		require.ErrorIs(t, err, storage.EmailExists)
+		require.NoError(t, s.Close())
+	}
+
+	plain, err = New(path, WithSuppressionKey(storagetest.SuppressionKey))
+	require.NoError(t, err)
+	defer plain.Close()
+	_, err = plain.GetSubscription(ctx, 1)
+	require.ErrorIs(t, err, storage.EncryptionDisabled)
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path, backupPath := filepath.Join(dir, "storage.db"), filepath.Join(dir, "backup.db")
+
+	s, err := New(path, WithSuppressionKey(storagetest.SuppressionKey))
+	require.NoError(t, err)
+	_, err = s.SaveSubscription(ctx, storage.Subscription{Email: "kept@example.com", Status: storage.StatusActive})
+	require.NoError(t, err)
+	require.NoError(t, s.Backup(ctx, backupPath))
+	require.Error(t, s.Backup(ctx, backupPath), "an existing backup is not overwritten")
+	_, err = s.SaveSubscription(ctx, storage.Subscription{Email: "lost@example.com", Status: storage.StatusActive})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	version, err := Restore(ctx, backupPath, path)
+	require.NoError(t, err)
+	require.Equal(t, s.Migrator().Latest(), version)
+
+	s, err = New(path, WithSuppressionKey(storagetest.SuppressionKey))
+	require.NoError(t, err)
+	subscriptions, err := s.GetActiveSubscriptionsAfter(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, subscriptions, 1)
+	require.Equal(t, "kept@example.com", subscriptions[0].Email)
+
+	// a backup taken by a newer release
+	_, err = s.db.Exec("INSERT INTO schema_migrations(version, name, applied_at) VALUES(1000, 'future', CURRENT_TIMESTAMP)")
+	require.NoError(t, err)
+	newerPath := filepath.Join(dir, "newer.db")
+	require.NoError(t, s.Backup(ctx, newerPath))
+	require.NoError(t, s.Close())
+	_, err = Restore(ctx, newerPath, path)
+	require.ErrorIs(t, err, migrate.SchemaTooNew)
+
+	notDatabase := filepath.Join(dir, "notes.txt")
+	require.NoError(t, os.WriteFile(notDatabase, []byte(strings.Repeat("not a database\n", 100)), 0o600))
+	_, err = Restore(ctx, notDatabase, path)
+	require.Error(t, err)
+
+	_, err = os.Stat(path + ".restore")
+	require.True(t, os.IsNotExist(err), "the working copy is removed")
+}
@@ -1,7 +1,194 @@
 package storage
 
-import "errors"
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"currency-rates-notifier/internal/storage/migrate"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
 
 var (
-	EmailExists = errors.New("email exists")
+	EmailExists          = errors.New("email exists")
+	SubscriptionNotFound = errors.New("subscription not found")
+	APIKeyNotFound       = errors.New("api key not found")
+	// EmailSuppressed is returned for suppressed addresses, which imports must not subscribe again
+	EmailSuppressed = errors.New("email suppressed")
+	// EncryptionDisabled is returned when reading an encrypted address without a keyring
+	EncryptionDisabled = errors.New("email encryption is not configured")
 )
+
+const (
+	StatusActive       = "active"
+	StatusUnsubscribed = "unsubscribed"
+
+	DeliverySent   = "sent"
+	DeliveryFailed = "failed"
+
+	// SuppressionErased marks addresses whose owner had their data erased
+	SuppressionErased = "erased"
+	// SuppressionBounced marks addresses that bounced too many times
+	SuppressionBounced = "bounced"
+	// SuppressionComplained marks addresses whose owner reported our mail as spam
+	SuppressionComplained = "complained"
+)
+
+type Subscription struct {
+	ID        int64
+	Email     string
+	Status    string
+	Pairs     []string
+	CreatedAt time.Time
+}
+
+// SubscriptionFilter narrows a listing of subscriptions, zero values match everything.
+// Search matches a substring of the email, or only the whole email when addresses are encrypted.
+type SubscriptionFilter struct {
+	Search string
+	Status string
+	Limit  int
+	Offset int
+}
+
+// Delivery records a notification sent to a subscriber
+type Delivery struct {
+	ID             int64
+	SubscriptionID int64
+	Status         string
+	Error          string
+	CreatedAt      time.Time
+}
+
+// APIKey grants access to the admin API. Only the hash of the key is stored.
+type APIKey struct {
+	ID        int64
+	Name      string
+	Scope     string
+	Hash      string
+	CreatedAt time.Time
+}
+
+// AuditEntry records a change made through the admin API
+type AuditEntry struct {
+	ID        int64
+	Actor     string
+	Action    string
+	Target    string
+	Details   string
+	RequestID string
+	CreatedAt time.Time
+}
+
+// Subscribers is the subscription part of a storage backend
+type Subscribers interface {
+	SaveSubscription(ctx context.Context, subscription Subscription) (Subscription, error)
+	ImportSubscriptions(ctx context.Context, subscriptions []Subscription, dryRun bool) ([]error, error)
+	GetActiveSubscriptionsAfter(ctx context.Context, afterID int64, limit int) ([]Subscription, error)
+	ListSubscriptions(ctx context.Context, filter SubscriptionFilter) ([]Subscription, int, error)
+	GetSubscription(ctx context.Context, id int64) (Subscription, error)
+	UpdateSubscription(ctx context.Context, subscription Subscription) (Subscription, error)
+	DeleteSubscription(ctx context.Context, id int64) error
+}
+
+type Deliveries interface {
+	SaveDeliveries(ctx context.Context, deliveries []Delivery) error
+	// ListDeliveries returns the deliveries to a subscription, oldest first
+	ListDeliveries(ctx context.Context, subscriptionID int64) ([]Delivery, error)
+	// FailedSubscriptionIDs returns the subscriptions whose last delivery failed at or
+	// after since, by id
+	FailedSubscriptionIDs(ctx context.Context, since time.Time) ([]int64, error)
+}
+
+// DataSubjects serves requests of subscribers about their personal data
+type DataSubjects interface {
+	GetSubscriptionByEmail(ctx context.Context, email string) (Subscription, error)
+	// EraseSubscription deletes a subscription with its deliveries and bounce count, removes
+	// its details from the audit log and suppresses the address, so imports skip it
+	EraseSubscription(ctx context.Context, id int64) error
+}
+
+// Suppressions keeps the addresses that must not be mailed, identified by SuppressionHasher
+type Suppressions interface {
+	// SuppressEmail suppresses an address. An address suppressed already keeps its reason.
+	SuppressEmail(ctx context.Context, email, reason string) error
+	// SuppressedEmails returns which of the given addresses are suppressed
+	SuppressedEmails(ctx context.Context, emails []string) (map[string]struct{}, error)
+	// RecordHardBounce counts a hard bounce of an address and returns its count so far. An
+	// event whose id was recorded before is not counted again, one without an id always is.
+	RecordHardBounce(ctx context.Context, email, eventID string) (int, error)
+}
+
+// Retention removes records that outlived their retention period
+type Retention interface {
+	// PruneDeliveries deletes the deliveries made before the given time
+	PruneDeliveries(ctx context.Context, before time.Time) (int64, error)
+	// PruneBounces forgets the hard bounces of addresses that last bounced before the given
+	// time, and the ids of the events recorded before it. Suppressions are kept.
+	PruneBounces(ctx context.Context, before time.Time) (int64, error)
+}
+
+type APIKeys interface {
+	SaveAPIKey(ctx context.Context, key APIKey) (APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	DeleteAPIKey(ctx context.Context, id int64) error
+}
+
+type AuditLog interface {
+	SaveAuditEntry(ctx context.Context, entry AuditEntry) error
+	ListAuditEntries(ctx context.Context, limit, offset int) ([]AuditEntry, int, error)
+}
+
+// Store is implemented by every storage backend
+type Store interface {
+	Subscribers
+	Deliveries
+	DataSubjects
+	Suppressions
+	Retention
+	APIKeys
+	AuditLog
+
+	Close() error
+}
+
+// Reencrypting is implemented by backends that can encrypt email addresses
+type Reencrypting interface {
+	ReencryptSubscriptions(ctx context.Context) (int, error)
+}
+
+// BackingUp is implemented by backends that can copy their database while it is in use
+type BackingUp interface {
+	Backup(ctx context.Context, path string) error
+}
+
+// Migrating is implemented by backends with a versioned SQL schema
+type Migrating interface {
+	Migrator() *migrate.Migrator
+}
+
+// SuppressionHasher identifies suppressed and bouncing addresses without storing them.
+// The hash is keyed, so an erased address cannot be recovered by hashing candidate
+// addresses. Addresses differing only in case get the same hash.
+type SuppressionHasher struct {
+	key []byte
+}
+
+func NewSuppressionHasher(key []byte) SuppressionHasher {
+	return SuppressionHasher{key: key}
+}
+
+func (h SuppressionHasher) Hash(email string) string {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(strings.ToLower(email)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SubscriberAuditTarget is the audit log target of changes to a subscription
+func SubscriberAuditTarget(id int64) string {
+	return fmt.Sprintf("subscriber/%d", id)
+}
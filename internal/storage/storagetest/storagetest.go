@@ -0,0 +1,464 @@
+// Package storagetest holds tests every storage backend has to pass.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"currency-rates-notifier/internal/lib/fieldcrypt"
+	"currency-rates-notifier/internal/storage"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// Run runs the conformance suite. open must return an empty, migrated store;
+// it is called once per test.
+func Run(t *testing.T, open func(t *testing.T) storage.Store) {
+	run(t, open, testListSubscriptions)
+}
+
+// RunEncrypted runs the suite against a store that encrypts email addresses, which
+// only finds whole addresses when searching
+func RunEncrypted(t *testing.T, open func(t *testing.T) storage.Store) {
+	run(t, open, testSearchEncryptedSubscriptions)
+}
+
+func run(t *testing.T, open func(t *testing.T) storage.Store, testList func(t *testing.T, s storage.Store)) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.Store)
+	}{
+		{"SaveSubscription", testSaveSubscription},
+		{"GetActiveSubscriptions", testGetActiveSubscriptions},
+		{"ListSubscriptions", testList},
+		{"UpdateAndDeleteSubscription", testUpdateAndDeleteSubscription},
+		{"ImportSubscriptions", testImportSubscriptions},
+		{"Deliveries", testDeliveries},
+		{"EraseSubscription", testEraseSubscription},
+		{"Suppressions", testSuppressions},
+		{"Retention", testRetention},
+		{"APIKeys", testAPIKeys},
+		{"AuditLog", testAuditLog},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := open(t)
+			t.Cleanup(func() { s.Close() })
+			tt.test(t, s)
+		})
+	}
+}
+
+// SuppressionKey keys the suppression hashes of the storages under test
+var SuppressionKey = bytes.Repeat([]byte{7}, 32)
+
+// Keyring returns a keyring with the keys "k1" and "k2", current is the id of the key
+// new values are encrypted with
+func Keyring(t *testing.T, current string) *fieldcrypt.Keyring {
+	keys := map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32), "k2": bytes.Repeat([]byte{2}, 32)}
+	keyring, err := fieldcrypt.NewKeyring(current, keys, bytes.Repeat([]byte{9}, 32))
+	require.NoError(t, err)
+	return keyring
+}
+
+func subscription(email string, pairs ...string) storage.Subscription {
+	if len(pairs) == 0 {
+		pairs = []string{"USD/UAH"}
+	}
+	return storage.Subscription{Email: email, Status: storage.StatusActive, Pairs: pairs}
+}
+
+func save(t *testing.T, s storage.Store, sub storage.Subscription) storage.Subscription {
+	t.Helper()
+	saved, err := s.SaveSubscription(context.Background(), sub)
+	require.NoError(t, err)
+	return saved
+}
+
+func testSaveSubscription(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	saved := save(t, s, subscription("user@example.com", "USD/UAH", "EUR/UAH"))
+	require.NotZero(t, saved.ID)
+	require.False(t, saved.CreatedAt.IsZero())
+
+	got, err := s.GetSubscription(ctx, saved.ID)
+	require.NoError(t, err)
+	require.Equal(t, "user@example.com", got.Email)
+	require.Equal(t, storage.StatusActive, got.Status)
+	require.Equal(t, []string{"USD/UAH", "EUR/UAH"}, got.Pairs)
+	require.True(t, saved.CreatedAt.Equal(got.CreatedAt))
+
+	_, err = s.SaveSubscription(ctx, subscription("user@example.com"))
+	require.ErrorIs(t, err, storage.EmailExists)
+
+	_, err = s.SaveSubscription(ctx, subscription("User@example.com"))
+	require.ErrorIs(t, err, storage.EmailExists, "addresses differing in case are the same subscriber")
+}
+
+func testGetActiveSubscriptions(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	active, err := s.GetActiveSubscriptionsAfter(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, active)
+
+	var want []int64
+	for n := range 7 {
+		sub := subscription(fmt.Sprintf("user%d@example.com", n), "EUR/UAH")
+		if n%3 == 1 {
+			sub.Status = storage.StatusUnsubscribed
+			save(t, s, sub)
+			continue
+		}
+		want = append(want, save(t, s, sub).ID)
+	}
+
+	active, err = s.GetActiveSubscriptionsAfter(ctx, want[1], 2)
+	require.NoError(t, err)
+	require.Len(t, active, 2)
+	require.Equal(t, want[2], active[0].ID)
+	require.Equal(t, want[3], active[1].ID)
+	require.Equal(t, []string{"EUR/UAH"}, active[1].Pairs)
+
+	var got []int64
+	for subscription, err := range storage.ActiveSubscriptions(ctx, s, 2) {
+		require.NoError(t, err)
+		require.Equal(t, storage.StatusActive, subscription.Status)
+		got = append(got, subscription.ID)
+	}
+	require.Equal(t, want, got)
+}
+
+func testListSubscriptions(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	for _, email := range []string{"ann@example.com", "bob@example.com", "ann_b@test.org", "annb@test.org"} {
+		save(t, s, subscription(email))
+	}
+	inactive := subscription("ann@other.com")
+	inactive.Status = storage.StatusUnsubscribed
+	save(t, s, inactive)
+
+	all, total, err := s.ListSubscriptions(ctx, storage.SubscriptionFilter{})
+	require.NoError(t, err)
+	require.Equal(t, 5, total)
+	require.Len(t, all, 5)
+
+	page, total, err := s.ListSubscriptions(ctx, storage.SubscriptionFilter{Limit: 2, Offset: 2})
+	require.NoError(t, err)
+	require.Equal(t, 5, total)
+	require.Equal(t, []int64{all[2].ID, all[3].ID}, []int64{page[0].ID, page[1].ID})
+
+	found, total, err := s.ListSubscriptions(ctx, storage.SubscriptionFilter{Search: "ANN"})
+	require.NoError(t, err)
+	require.Equal(t, 4, total, "search is case-insensitive")
+	require.Len(t, found, 4)
+
+	found, total, err = s.ListSubscriptions(ctx, storage.SubscriptionFilter{Search: "ann_"})
+	require.NoError(t, err)
+	require.Equal(t, 1, total, "wildcards in the search are literal")
+	require.Equal(t, "ann_b@test.org", found[0].Email)
+
+	found, total, err = s.ListSubscriptions(ctx, storage.SubscriptionFilter{Search: "ann", Status: storage.StatusUnsubscribed})
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	require.Equal(t, "ann@other.com", found[0].Email)
+
+	empty, total, err := s.ListSubscriptions(ctx, storage.SubscriptionFilter{Offset: 10})
+	require.NoError(t, err)
+	require.Equal(t, 5, total)
+	require.NotNil(t, empty)
+	require.Empty(t, empty)
+}
+
+func testSearchEncryptedSubscriptions(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	save(t, s, subscription("ann@example.com"))
+	bob := save(t, s, subscription("Bob@example.com"))
+
+	found, total, err := s.ListSubscriptions(ctx, storage.SubscriptionFilter{Search: "bob@EXAMPLE.com"})
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	require.Equal(t, bob.ID, found[0].ID)
+	require.Equal(t, "Bob@example.com", found[0].Email)
+
+	_, total, err = s.ListSubscriptions(ctx, storage.SubscriptionFilter{Search: "example"})
+	require.NoError(t, err)
+	require.Zero(t, total, "parts of encrypted addresses do not match")
+}
+
+func testUpdateAndDeleteSubscription(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	saved := save(t, s, subscription("user@example.com"))
+	saved.Status = storage.StatusUnsubscribed
+	saved.Pairs = []string{"EUR/UAH", "PLN/UAH"}
+
+	updated, err := s.UpdateSubscription(ctx, saved)
+	require.NoError(t, err)
+	require.Equal(t, storage.StatusUnsubscribed, updated.Status)
+	require.Equal(t, []string{"EUR/UAH", "PLN/UAH"}, updated.Pairs)
+	require.Equal(t, "user@example.com", updated.Email)
+
+	_, err = s.UpdateSubscription(ctx, storage.Subscription{ID: saved.ID + 100, Status: storage.StatusActive})
+	require.ErrorIs(t, err, storage.SubscriptionNotFound)
+
+	require.NoError(t, s.SaveDeliveries(ctx, []storage.Delivery{{SubscriptionID: saved.ID, Status: storage.DeliveryFailed, Error: "mailbox full"}}))
+	require.NoError(t, s.DeleteSubscription(ctx, saved.ID))
+	require.ErrorIs(t, s.DeleteSubscription(ctx, saved.ID), storage.SubscriptionNotFound)
+
+	_, err = s.GetSubscription(ctx, saved.ID)
+	require.ErrorIs(t, err, storage.SubscriptionNotFound)
+	deliveries, err := s.ListDeliveries(ctx, saved.ID)
+	require.NoError(t, err)
+	require.Empty(t, deliveries, "the delivery log of a deleted subscription is deleted with it")
+
+	next := save(t, s, subscription("next@example.com"))
+	require.NotEqual(t, saved.ID, next.ID, "the id of a deleted subscription is not reused")
+	deliveries, err = s.ListDeliveries(ctx, next.ID)
+	require.NoError(t, err)
+	require.Empty(t, deliveries)
+	failed, err := s.FailedSubscriptionIDs(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, failed)
+}
+
+func testImportSubscriptions(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	save(t, s, subscription("existing@example.com"))
+	batch := []storage.Subscription{subscription("new@example.com"), subscription("Existing@example.com"), subscription("other@example.com")}
+
+	results, err := s.ImportSubscriptions(ctx, batch, true)
+	require.NoError(t, err)
+	require.Equal(t, []error{nil, storage.EmailExists, nil}, results)
+
+	_, total, err := s.ListSubscriptions(ctx, storage.SubscriptionFilter{})
+	require.NoError(t, err)
+	require.Equal(t, 1, total, "a dry run does not store anything")
+
+	results, err = s.ImportSubscriptions(ctx, batch, false)
+	require.NoError(t, err)
+	require.Equal(t, []error{nil, storage.EmailExists, nil}, results)
+
+	_, total, err = s.ListSubscriptions(ctx, storage.SubscriptionFilter{})
+	require.NoError(t, err)
+	require.Equal(t, 3, total)
+}
+
+func testDeliveries(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	first := save(t, s, subscription("first@example.com"))
+	second := save(t, s, subscription("second@example.com"))
+
+	err := s.SaveDeliveries(ctx, []storage.Delivery{
+		{SubscriptionID: first.ID, Status: storage.DeliverySent},
+		{SubscriptionID: second.ID, Status: storage.DeliverySent},
+		{SubscriptionID: first.ID, Status: storage.DeliveryFailed, Error: "mailbox full"},
+	})
+	require.NoError(t, err)
+
+	deliveries, err := s.ListDeliveries(ctx, first.ID)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	require.Equal(t, storage.DeliverySent, deliveries[0].Status)
+	require.Equal(t, storage.DeliveryFailed, deliveries[1].Status)
+	require.Equal(t, "mailbox full", deliveries[1].Error)
+	require.Equal(t, first.ID, deliveries[1].SubscriptionID)
+	require.False(t, deliveries[1].CreatedAt.IsZero())
+
+	deliveries, err = s.ListDeliveries(ctx, second.ID+100)
+	require.NoError(t, err)
+	require.NotNil(t, deliveries)
+	require.Empty(t, deliveries)
+
+	failed, err := s.FailedSubscriptionIDs(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, []int64{first.ID}, failed, "only the last delivery counts")
+
+	require.NoError(t, s.SaveDeliveries(ctx, []storage.Delivery{{SubscriptionID: first.ID, Status: storage.DeliverySent}}))
+	failed, err = s.FailedSubscriptionIDs(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, failed, "a later delivery succeeded")
+
+	require.NoError(t, s.SaveDeliveries(ctx, []storage.Delivery{{SubscriptionID: second.ID, Status: storage.DeliveryFailed, CreatedAt: time.Now().Add(-2 * time.Hour)}}))
+	failed, err = s.FailedSubscriptionIDs(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.NotNil(t, failed)
+	require.Empty(t, failed, "failures before since are not returned")
+}
+
+func testEraseSubscription(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	erased := save(t, s, subscription("Erased@example.com"))
+	kept := save(t, s, subscription("kept@example.com"))
+	require.NoError(t, s.SaveDeliveries(ctx, []storage.Delivery{{SubscriptionID: erased.ID, Status: storage.DeliverySent}}))
+	require.NoError(t, s.SaveAuditEntry(ctx, storage.AuditEntry{Actor: "admin", Action: "subscriber.update", Target: storage.SubscriberAuditTarget(erased.ID), Details: `{"email":"Erased@example.com"}`}))
+
+	found, err := s.GetSubscriptionByEmail(ctx, "erased@EXAMPLE.com")
+	require.NoError(t, err)
+	require.Equal(t, erased.ID, found.ID)
+	require.Equal(t, "Erased@example.com", found.Email)
+
+	require.NoError(t, s.EraseSubscription(ctx, erased.ID))
+	require.ErrorIs(t, s.EraseSubscription(ctx, erased.ID), storage.SubscriptionNotFound)
+
+	_, err = s.GetSubscriptionByEmail(ctx, "Erased@example.com")
+	require.ErrorIs(t, err, storage.SubscriptionNotFound)
+	deliveries, err := s.ListDeliveries(ctx, erased.ID)
+	require.NoError(t, err)
+	require.Empty(t, deliveries)
+	entries, _, err := s.ListAuditEntries(ctx, 0, 0)
+	require.NoError(t, err)
+	require.Empty(t, entries[0].Details, "audit details of an erased subscriber are removed")
+
+	results, err := s.ImportSubscriptions(ctx, []storage.Subscription{subscription("erased@example.com"), subscription("new@example.com")}, false)
+	require.NoError(t, err)
+	require.Equal(t, []error{storage.EmailSuppressed, nil}, results)
+
+	_, err = s.GetSubscription(ctx, kept.ID)
+	require.NoError(t, err)
+}
+
+func testSuppressions(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	suppressed, err := s.SuppressedEmails(ctx, nil)
+	require.NoError(t, err)
+	require.Empty(t, suppressed)
+
+	for want := 1; want <= 2; want++ {
+		count, err := s.RecordHardBounce(ctx, "Bounced@example.com", "")
+		require.NoError(t, err)
+		require.Equal(t, want, count)
+	}
+	count, err := s.RecordHardBounce(ctx, "other@example.com", "")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	require.NoError(t, s.SuppressEmail(ctx, "bounced@example.com", storage.SuppressionBounced))
+	require.NoError(t, s.SuppressEmail(ctx, "BOUNCED@example.com", storage.SuppressionComplained), "suppressing twice is not an error")
+
+	suppressed, err = s.SuppressedEmails(ctx, []string{"Bounced@Example.com", "other@example.com", "bounced@example.com"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]struct{}{"Bounced@Example.com": {}, "bounced@example.com": {}}, suppressed)
+
+	results, err := s.ImportSubscriptions(ctx, []storage.Subscription{subscription("bounced@example.com")}, true)
+	require.NoError(t, err)
+	require.Equal(t, []error{storage.EmailSuppressed}, results)
+
+	// an event delivered again is not counted again
+	for range 2 {
+		count, err = s.RecordHardBounce(ctx, "repeated@example.com", "sendgrid:event-1/0")
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+	}
+	count, err = s.RecordHardBounce(ctx, "repeated@example.com", "sendgrid:event-2/0")
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	// erasure forgets the bounces of an address
+	erased := save(t, s, subscription("other@example.com"))
+	require.NoError(t, s.EraseSubscription(ctx, erased.ID))
+	count, err = s.RecordHardBounce(ctx, "other@example.com", "")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
+
+func testRetention(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	sub := save(t, s, subscription("user@example.com"))
+	now := time.Now().UTC().Truncate(time.Second)
+
+	require.NoError(t, s.SaveDeliveries(ctx, []storage.Delivery{
+		{SubscriptionID: sub.ID, Status: storage.DeliverySent, CreatedAt: now.Add(-72 * time.Hour)},
+		{SubscriptionID: sub.ID, Status: storage.DeliveryFailed, CreatedAt: now.Add(-48 * time.Hour)},
+		{SubscriptionID: sub.ID, Status: storage.DeliverySent, CreatedAt: now},
+	}))
+	pruned, err := s.PruneDeliveries(ctx, now.Add(-24*time.Hour))
+	require.NoError(t, err)
+	require.EqualValues(t, 2, pruned)
+	deliveries, err := s.ListDeliveries(ctx, sub.ID)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	require.Equal(t, now, deliveries[0].CreatedAt.UTC())
+
+	_, err = s.RecordHardBounce(ctx, "user@example.com", "")
+	require.NoError(t, err)
+	pruned, err = s.PruneBounces(ctx, now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Zero(t, pruned)
+	pruned, err = s.PruneBounces(ctx, now.Add(time.Hour))
+	require.NoError(t, err)
+	require.EqualValues(t, 1, pruned)
+	count, err := s.RecordHardBounce(ctx, "user@example.com", "")
+	require.NoError(t, err)
+	require.Equal(t, 1, count, "pruned bounces are not counted")
+
+	_, err = s.RecordHardBounce(ctx, "user@example.com", "sendgrid:event-1/0")
+	require.NoError(t, err)
+	_, err = s.PruneBounces(ctx, now.Add(time.Hour))
+	require.NoError(t, err)
+	count, err = s.RecordHardBounce(ctx, "user@example.com", "sendgrid:event-1/0")
+	require.NoError(t, err)
+	require.Equal(t, 1, count, "the ids of pruned events are forgotten")
+}
+
+func testAPIKeys(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	saved, err := s.SaveAPIKey(ctx, storage.APIKey{Name: "support", Scope: "read", Hash: "abc"})
+	require.NoError(t, err)
+	require.NotZero(t, saved.ID)
+
+	got, err := s.GetAPIKeyByHash(ctx, "abc")
+	require.NoError(t, err)
+	require.Equal(t, saved.ID, got.ID)
+	require.Equal(t, "support", got.Name)
+	require.Equal(t, "read", got.Scope)
+
+	_, err = s.GetAPIKeyByHash(ctx, "unknown")
+	require.ErrorIs(t, err, storage.APIKeyNotFound)
+
+	keys, err := s.ListAPIKeys(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+
+	require.NoError(t, s.DeleteAPIKey(ctx, saved.ID))
+	require.ErrorIs(t, s.DeleteAPIKey(ctx, saved.ID), storage.APIKeyNotFound)
+
+	keys, err = s.ListAPIKeys(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, keys)
+	require.Empty(t, keys)
+}
+
+func testAuditLog(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	for _, action := range []string{"subscriber.update", "subscriber.delete", "api_key.create"} {
+		err := s.SaveAuditEntry(ctx, storage.AuditEntry{Actor: "admin", Action: action, Target: "subscriber/1", Details: `{"a":1}`, RequestID: "req"})
+		require.NoError(t, err)
+	}
+
+	entries, total, err := s.ListAuditEntries(ctx, 2, 0)
+	require.NoError(t, err)
+	require.Equal(t, 3, total)
+	require.Len(t, entries, 2)
+	require.Equal(t, "api_key.create", entries[0].Action, "newest entries come first")
+	require.Equal(t, "admin", entries[0].Actor)
+	require.Equal(t, `{"a":1}`, entries[0].Details)
+	require.Equal(t, "req", entries[0].RequestID)
+	require.False(t, entries[0].CreatedAt.IsZero())
+
+	entries, _, err = s.ListAuditEntries(ctx, 0, 2)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "subscriber.update", entries[0].Action)
+}